@@ -1,15 +1,21 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"rayleigh/pkg/bluesky"
 	"rayleigh/pkg/db/query"
+	"rayleigh/pkg/jetstream"
 )
 
 type Handler struct {
 	queries *query.Queries
+	// nil leaves handles out, post URLs then use the creator's DID
+	resolver *bluesky.Resolver
 }
 
 func NewHandler(queries *query.Queries) *Handler {
@@ -23,11 +29,38 @@ type CreatePostRequest struct {
 	CreatorDID string   `json:"creator_did"`
 	Text       string   `json:"text"`
 	Tags       []string `json:"tags"`
+	Langs      []string `json:"langs,omitempty"`
 }
 
 type SearchPostsRequest struct {
 	Tags         []string  `json:"tags"`
 	CreatorDIDs  []string  `json:"creator_dids,omitempty"`
+	Langs        []string  `json:"langs,omitempty"`     // any of these, e.g. "en" or "ja"
+	HasMedia     bool      `json:"has_media,omitempty"` // only posts with images or video attached
+	Sort         string    `json:"sort,omitempty"`      // sortRecent, the default, or sortEngagement
+	CreatedAfter time.Time `json:"created_after"`
+	Limit        int32     `json:"limit"`
+	Offset       int32     `json:"offset"`
+}
+
+// orders SearchPosts returns posts in
+const (
+	// newest first
+	sortRecent = "recent"
+	// most likes plus reposts first, newest first among equals
+	sortEngagement = "engagement"
+)
+
+type SearchLinkedPostsRequest struct {
+	Tags         []string  `json:"tags"`
+	Domain       string    `json:"domain"` // subdomains match too
+	CreatedAfter time.Time `json:"created_after"`
+	Limit        int32     `json:"limit"`
+	Offset       int32     `json:"offset"`
+}
+
+type SearchMentionsRequest struct {
+	DID          string    `json:"did"`
 	CreatedAfter time.Time `json:"created_after"`
 	Limit        int32     `json:"limit"`
 	Offset       int32     `json:"offset"`
@@ -57,7 +90,8 @@ func (h *Handler) CreatePostWithTags(w http.ResponseWriter, r *http.Request) {
 		CreatorDid: req.CreatorDID,
 		CreatedAt:  time.Now(),
 		Text:       req.Text,
-		Tags:       req.Tags,
+		Langs:      jetstream.NormalizeLangs(req.Langs),
+		Tags:       jetstream.NormalizeTags(req.Tags),
 	})
 	if err != nil {
 		http.Error(w, "Failed to create post: "+err.Error(), http.StatusInternalServerError)
@@ -79,7 +113,8 @@ func (h *Handler) SearchPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate request
+	// Validate request, stored tags are normalized the same way
+	req.Tags = jetstream.NormalizeTags(req.Tags)
 	if len(req.Tags) == 0 {
 		http.Error(w, "At least one tag is required", http.StatusBadRequest)
 		return
@@ -92,26 +127,77 @@ func (h *Handler) SearchPosts(w http.ResponseWriter, r *http.Request) {
 	if req.CreatedAfter.IsZero() {
 		req.CreatedAfter = time.Now().AddDate(-1, 0, 0) // Default to 1 year ago
 	}
+	// stored languages are normalized the same way
+	req.Langs = jetstream.NormalizeLangs(req.Langs)
+	if req.Sort != "" && req.Sort != sortRecent && req.Sort != sortEngagement {
+		http.Error(w, "Sort must be recent or engagement", http.StatusBadRequest)
+		return
+	}
+	byEngagement := req.Sort == sortEngagement
 
 	var posts interface{}
 	var err error
 
-	if len(req.CreatorDIDs) > 0 {
+	switch {
+	case len(req.CreatorDIDs) > 0 && len(req.Langs) > 0:
+		// Search by tags, creators and languages
+		var rows []query.GetRecentRootPostsByTagAndCreatorAndLangsRow
+		rows, err = h.queries.GetRecentRootPostsByTagAndCreatorAndLangs(r.Context(), query.GetRecentRootPostsByTagAndCreatorAndLangsParams{
+			TagNames:         req.Tags,
+			CreatedAfter:     req.CreatedAfter,
+			CreatorDids:      req.CreatorDIDs,
+			Langs:            req.Langs,
+			RowOffset:        req.Offset,
+			RowLimit:         req.Limit,
+			MediaOnly:        req.HasMedia,
+			SortByEngagement: byEngagement,
+		})
+		posts = linkPosts(r.Context(), h.resolver, rows, func(p query.GetRecentRootPostsByTagAndCreatorAndLangsRow) (string, string) {
+			return p.CreatorDid, p.PostID
+		})
+	case len(req.Langs) > 0:
+		// Search by tags and languages
+		var rows []query.GetRecentRootPostsByTagsAndLangsRow
+		rows, err = h.queries.GetRecentRootPostsByTagsAndLangs(r.Context(), query.GetRecentRootPostsByTagsAndLangsParams{
+			TagNames:         req.Tags,
+			CreatedAfter:     req.CreatedAfter,
+			Langs:            req.Langs,
+			RowOffset:        req.Offset,
+			RowLimit:         req.Limit,
+			MediaOnly:        req.HasMedia,
+			SortByEngagement: byEngagement,
+		})
+		posts = linkPosts(r.Context(), h.resolver, rows, func(p query.GetRecentRootPostsByTagsAndLangsRow) (string, string) {
+			return p.CreatorDid, p.PostID
+		})
+	case len(req.CreatorDIDs) > 0:
 		// Search by tags and creators
-		posts, err = h.queries.GetRecentRootPostsByTagAndCreator(r.Context(), query.GetRecentRootPostsByTagAndCreatorParams{
-			TagNames:     req.Tags,
-			CreatedAfter: req.CreatedAfter,
-			CreatorDids:  req.CreatorDIDs,
-			RowOffset:    req.Offset,
-			RowLimit:     req.Limit,
+		var rows []query.GetRecentRootPostsByTagAndCreatorRow
+		rows, err = h.queries.GetRecentRootPostsByTagAndCreator(r.Context(), query.GetRecentRootPostsByTagAndCreatorParams{
+			TagNames:         req.Tags,
+			CreatedAfter:     req.CreatedAfter,
+			CreatorDids:      req.CreatorDIDs,
+			RowOffset:        req.Offset,
+			RowLimit:         req.Limit,
+			MediaOnly:        req.HasMedia,
+			SortByEngagement: byEngagement,
 		})
-	} else {
+		posts = linkPosts(r.Context(), h.resolver, rows, func(p query.GetRecentRootPostsByTagAndCreatorRow) (string, string) {
+			return p.CreatorDid, p.PostID
+		})
+	default:
 		// Search by tags only
-		posts, err = h.queries.GetRecentRootPostsByTags(r.Context(), query.GetRecentRootPostsByTagsParams{
-			TagNames:     req.Tags,
-			CreatedAfter: req.CreatedAfter,
-			RowOffset:    req.Offset,
-			RowLimit:     req.Limit,
+		var rows []query.GetRecentRootPostsByTagsRow
+		rows, err = h.queries.GetRecentRootPostsByTags(r.Context(), query.GetRecentRootPostsByTagsParams{
+			TagNames:         req.Tags,
+			CreatedAfter:     req.CreatedAfter,
+			RowOffset:        req.Offset,
+			RowLimit:         req.Limit,
+			MediaOnly:        req.HasMedia,
+			SortByEngagement: byEngagement,
+		})
+		posts = linkPosts(r.Context(), h.resolver, rows, func(p query.GetRecentRootPostsByTagsRow) (string, string) {
+			return p.CreatorDid, p.PostID
 		})
 	}
 
@@ -122,4 +208,155 @@ func (h *Handler) SearchPosts(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
-} 
\ No newline at end of file
+}
+
+// GetThread returns the reply tree of the thread a stored post belongs to
+func (h *Handler) GetThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req GetThreadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.PostID == "" || req.CreatorDID == "" {
+		http.Error(w, "PostID and CreatorDID are required", http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.queries.GetPost(r.Context(), query.GetPostParams{
+		CreatorDid: req.CreatorDID,
+		PostID:     req.PostID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get post: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// a reply is shown within the whole thread, from its root down
+	rootURI := jetstream.PostURI(post.CreatorDid, post.PostID)
+	if post.ReplyRootUri.Valid {
+		rootURI = post.ReplyRootUri.String
+	}
+	rootDID, rootPostID, err := jetstream.ParsePostURI(rootURI)
+	if err != nil {
+		http.Error(w, "Invalid thread root: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.queries.GetThreadByRoot(r.Context(), query.GetThreadByRootParams{
+		RootUri:        rootURI,
+		RootCreatorDid: rootDID,
+		RootPostID:     rootPostID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to get thread: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	thread := buildThread(rootURI, rows)
+	thread.addLinks(h.threadHandles(r, rootDID, rows))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thread)
+}
+
+// SearchLinkedPosts finds posts in the tags that link to a domain
+func (h *Handler) SearchLinkedPosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SearchLinkedPostsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	req.Tags = jetstream.NormalizeTags(req.Tags)
+	req.Domain = jetstream.NormalizeDomain(req.Domain)
+	if len(req.Tags) == 0 || req.Domain == "" {
+		http.Error(w, "At least one tag and a domain are required", http.StatusBadRequest)
+		return
+	}
+
+	// Set default values
+	if req.Limit == 0 {
+		req.Limit = 50
+	}
+	if req.CreatedAfter.IsZero() {
+		req.CreatedAfter = time.Now().AddDate(-1, 0, 0) // Default to 1 year ago
+	}
+
+	posts, err := h.queries.GetRecentRootPostsByTagsAndLinkDomain(r.Context(), query.GetRecentRootPostsByTagsAndLinkDomainParams{
+		TagNames:     req.Tags,
+		Domain:       req.Domain,
+		CreatedAfter: req.CreatedAfter,
+		RowLimit:     req.Limit,
+		RowOffset:    req.Offset,
+	})
+	if err != nil {
+		http.Error(w, "Failed to search posts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(linkPosts(r.Context(), h.resolver, posts, func(p query.GetRecentRootPostsByTagsAndLinkDomainRow) (string, string) {
+		return p.CreatorDid, p.PostID
+	}))
+}
+
+// SearchMentions finds posts and replies mentioning a DID
+func (h *Handler) SearchMentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SearchMentionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.DID == "" {
+		http.Error(w, "DID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Set default values
+	if req.Limit == 0 {
+		req.Limit = 50
+	}
+	if req.CreatedAfter.IsZero() {
+		req.CreatedAfter = time.Now().AddDate(-1, 0, 0) // Default to 1 year ago
+	}
+
+	posts, err := h.queries.GetRecentPostsByMention(r.Context(), query.GetRecentPostsByMentionParams{
+		Did:          req.DID,
+		CreatedAfter: req.CreatedAfter,
+		RowLimit:     req.Limit,
+		RowOffset:    req.Offset,
+	})
+	if err != nil {
+		http.Error(w, "Failed to search posts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(linkPosts(r.Context(), h.resolver, posts, func(p query.Post) (string, string) {
+		return p.CreatorDid, p.PostID
+	}))
+}
@@ -133,12 +133,14 @@ func TestSearchPosts(t *testing.T) {
 			CreatorDID: "did:test:123",
 			Text:      "Test post 1",
 			Tags:      []string{"test", "integration"},
+			Langs:     []string{"en"},
 		},
 		{
 			PostID:     "test-post-2",
 			CreatorDID: "did:test:456",
 			Text:      "Test post 2",
 			Tags:      []string{"test"},
+			Langs:     []string{"ja"},
 		},
 	}
 
@@ -148,11 +150,29 @@ func TestSearchPosts(t *testing.T) {
 			CreatorDid: post.CreatorDID,
 			CreatedAt:  time.Now(),
 			Text:       post.Text,
+			Langs:      post.Langs,
 			Tags:       post.Tags,
 		}
+		if post.PostID == "test-post-2" {
+			params.EmbedType = sql.NullString{String: "app.bsky.embed.images", Valid: true}
+			params.MediaKinds = []string{"image"}
+			params.MediaCids = []string{"bafkreitestimage"}
+			params.MediaMimeTypes = []string{"image/jpeg"}
+			params.MediaAlts = []string{"a test image"}
+		}
 		err := query.New(db).CreatePostWithTags(context.Background(), params)
 		require.NoError(t, err)
 	}
+	// the older post is the liked one
+	_, err := query.New(db).AddPostInteraction(context.Background(), query.AddPostInteractionParams{
+		Did:           "did:test:789",
+		Kind:          "like",
+		Rkey:          "test-like-1",
+		CreatedAt:     time.Now(),
+		SubjectDid:    "did:test:123",
+		SubjectPostID: "test-post-1",
+	})
+	require.NoError(t, err)
 
 	tests := []struct {
 		name           string
@@ -177,6 +197,21 @@ func TestSearchPosts(t *testing.T) {
 				assert.Contains(t, []string{posts[0].Text, posts[1].Text}, "Test post 2")
 			},
 		},
+		{
+			name: "search tags are normalized",
+			request: SearchPostsRequest{
+				Tags:        []string{"#ＴＥＳＴ"},
+				CreatedAfter: time.Now().Add(-24 * time.Hour),
+				Limit:       50,
+			},
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var posts []query.GetRecentRootPostsByTagsRow
+				err := json.NewDecoder(resp.Body).Decode(&posts)
+				require.NoError(t, err)
+				assert.Len(t, posts, 2)
+			},
+		},
 		{
 			name: "search by tags and creator",
 			request: SearchPostsRequest{
@@ -194,6 +229,75 @@ func TestSearchPosts(t *testing.T) {
 				assert.Equal(t, "Test post 1", posts[0].Text)
 			},
 		},
+		{
+			name: "search by tags and language",
+			request: SearchPostsRequest{
+				Tags:        []string{"test"},
+				Langs:       []string{"JA"},
+				CreatedAfter: time.Now().Add(-24 * time.Hour),
+				Limit:       50,
+			},
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var posts []query.GetRecentRootPostsByTagsAndLangsRow
+				err := json.NewDecoder(resp.Body).Decode(&posts)
+				require.NoError(t, err)
+				assert.Len(t, posts, 1)
+				assert.Equal(t, "Test post 2", posts[0].Text)
+			},
+		},
+		{
+			name: "search by tags, creator and language",
+			request: SearchPostsRequest{
+				Tags:        []string{"test"},
+				CreatorDIDs: []string{"did:test:123"},
+				Langs:       []string{"ja"},
+				CreatedAfter: time.Now().Add(-24 * time.Hour),
+				Limit:       50,
+			},
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var posts []query.GetRecentRootPostsByTagAndCreatorAndLangsRow
+				err := json.NewDecoder(resp.Body).Decode(&posts)
+				require.NoError(t, err)
+				assert.Empty(t, posts)
+			},
+		},
+		{
+			name: "search by tags with media",
+			request: SearchPostsRequest{
+				Tags:        []string{"test"},
+				HasMedia:    true,
+				CreatedAfter: time.Now().Add(-24 * time.Hour),
+				Limit:       50,
+			},
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var posts []query.GetRecentRootPostsByTagsRow
+				err := json.NewDecoder(resp.Body).Decode(&posts)
+				require.NoError(t, err)
+				assert.Len(t, posts, 1)
+				assert.Equal(t, "Test post 2", posts[0].Text)
+			},
+		},
+		{
+			name: "search by tags sorted by engagement",
+			request: SearchPostsRequest{
+				Tags:         []string{"test"},
+				Sort:         sortEngagement,
+				CreatedAfter: time.Now().Add(-24 * time.Hour),
+				Limit:        50,
+			},
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var posts []query.GetRecentRootPostsByTagsRow
+				err := json.NewDecoder(resp.Body).Decode(&posts)
+				require.NoError(t, err)
+				require.Len(t, posts, 2)
+				assert.Equal(t, "Test post 1", posts[0].Text)
+				assert.Equal(t, int32(1), posts[0].Likes)
+			},
+		},
 		{
 			name: "no tags provided",
 			request: SearchPostsRequest{
@@ -201,6 +305,14 @@ func TestSearchPosts(t *testing.T) {
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "unknown sort",
+			request: SearchPostsRequest{
+				Tags: []string{"test"},
+				Sort: "oldest",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -223,4 +335,118 @@ func TestSearchPosts(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+} 
+func TestGetThread(t *testing.T) {
+	server, db := setupTestServer(t)
+	defer db.Close()
+
+	_, err := db.Exec(`TRUNCATE posts, tags, post_tags CASCADE`)
+	require.NoError(t, err)
+
+	rootURI := "at://did:test:123/app.bsky.feed.post/test-root"
+	replyURI := "at://did:test:456/app.bsky.feed.post/test-reply"
+	testPosts := []query.CreatePostWithTagsParams{
+		{PostID: "test-root", CreatorDid: "did:test:123", Text: "Root post", Tags: []string{"test"}},
+		{
+			PostID: "test-reply", CreatorDid: "did:test:456", Text: "Reply", Tags: []string{"test"},
+			ReplyRootUri:   sql.NullString{String: rootURI, Valid: true},
+			ReplyParentUri: sql.NullString{String: rootURI, Valid: true},
+		},
+		{
+			PostID: "test-nested", CreatorDid: "did:test:123", Text: "Nested reply", Tags: []string{"test"},
+			ReplyRootUri:   sql.NullString{String: rootURI, Valid: true},
+			ReplyParentUri: sql.NullString{String: replyURI, Valid: true},
+		},
+	}
+	for i, params := range testPosts {
+		params.CreatedAt = time.Now().Add(time.Duration(i) * time.Minute)
+		require.NoError(t, query.New(db).CreatePostWithTags(context.Background(), params))
+	}
+
+	tests := []struct {
+		name           string
+		request        GetThreadRequest
+		expectedStatus int
+	}{
+		{"thread from its root", GetThreadRequest{PostID: "test-root", CreatorDID: "did:test:123"}, http.StatusOK},
+		{"thread from a nested reply", GetThreadRequest{PostID: "test-nested", CreatorDID: "did:test:123"}, http.StatusOK},
+		{"unknown post", GetThreadRequest{PostID: "missing", CreatorDID: "did:test:123"}, http.StatusNotFound},
+		{"missing required fields", GetThreadRequest{PostID: "test-root"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.request)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/posts/thread", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			server.handler.GetThread(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var thread ThreadNode
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&thread))
+			assert.Equal(t, rootURI, thread.URI)
+			require.Len(t, thread.Replies, 1)
+			assert.Equal(t, "Reply", thread.Replies[0].Text)
+			require.Len(t, thread.Replies[0].Replies, 1)
+			assert.Equal(t, "Nested reply", thread.Replies[0].Replies[0].Text)
+		})
+	}
+}
+
+func TestSearchLinkedPostsAndMentions(t *testing.T) {
+	server, db := setupTestServer(t)
+	defer db.Close()
+
+	_, err := db.Exec(`TRUNCATE posts, tags, post_tags CASCADE`)
+	require.NoError(t, err)
+
+	err = query.New(db).CreatePostWithTags(context.Background(), query.CreatePostWithTagsParams{
+		PostID:      "test-post-1",
+		CreatorDid:  "did:test:123",
+		CreatedAt:   time.Now(),
+		Text:        "Test post linking to example.com for @someone",
+		Tags:        []string{"test"},
+		Mentions:    []string{"did:test:456"},
+		LinkUrls:    []string{"https://blog.example.com/post"},
+		LinkDomains: []string{"blog.example.com"},
+	})
+	require.NoError(t, err)
+
+	search := func(path string, handler http.HandlerFunc, request interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := search("/api/posts/links", server.handler.SearchLinkedPosts, SearchLinkedPostsRequest{Tags: []string{"test"}, Domain: "WWW.Example.com"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var linked []query.GetRecentRootPostsByTagsAndLinkDomainRow
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&linked))
+	require.Len(t, linked, 1)
+	assert.Equal(t, "https://blog.example.com/post", linked[0].LinkUrl)
+
+	w = search("/api/posts/links", server.handler.SearchLinkedPosts, SearchLinkedPostsRequest{Tags: []string{"test"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = search("/api/posts/mentions", server.handler.SearchMentions, SearchMentionsRequest{DID: "did:test:456"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var mentions []query.Post
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&mentions))
+	require.Len(t, mentions, 1)
+	assert.Equal(t, "test-post-1", mentions[0].PostID)
+
+	w = search("/api/posts/mentions", server.handler.SearchMentions, SearchMentionsRequest{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
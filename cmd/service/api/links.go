@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"rayleigh/pkg/bluesky"
+	"rayleigh/pkg/db/query"
+	"rayleigh/pkg/jetstream"
+)
+
+// postLinks are the fields added to every post in a search result
+type postLinks struct {
+	// the creator's handle, left out when it isn't known
+	Handle string `json:",omitempty"`
+	// the post on bsky.app
+	URL string
+}
+
+// linkedPost is a post found by a search with its links added after the post's own fields
+type linkedPost[T any] struct {
+	post  T
+	links postLinks
+}
+
+func (p linkedPost[T]) MarshalJSON() ([]byte, error) {
+	post, err := json.Marshal(p.post)
+	if err != nil {
+		return nil, err
+	}
+	links, err := json.Marshal(p.links)
+	if err != nil {
+		return nil, err
+	}
+	if len(post) < 2 || post[0] != '{' || post[len(post)-1] != '}' {
+		return nil, fmt.Errorf("post encodes as %s, not an object", post)
+	}
+	if len(post) == 2 {
+		return links, nil
+	}
+	// splice the links into the post's object
+	return append(append(post[:len(post)-1], ','), links[1:]...), nil
+}
+
+// linkPosts adds each post's creator handle and bsky.app URL, key returns a post's creator DID and record key.
+// Without a resolver, or for creators whose handle can't be resolved, the URL uses the DID.
+func linkPosts[T any](ctx context.Context, resolver *bluesky.Resolver, posts []T, key func(T) (string, string)) []linkedPost[T] {
+	handles := map[string]string{}
+	if resolver != nil && len(posts) > 0 {
+		dids := make([]string, 0, len(posts))
+		for _, post := range posts {
+			did, _ := key(post)
+			dids = append(dids, did)
+		}
+		handles = resolver.ResolveAll(ctx, dids)
+	}
+
+	linked := make([]linkedPost[T], 0, len(posts))
+	for _, post := range posts {
+		did, postID := key(post)
+		linked = append(linked, linkedPost[T]{post: post, links: newPostLinks(handles[did], did, postID)})
+	}
+	return linked
+}
+
+func newPostLinks(handle, did, postID string) postLinks {
+	if handle == "" {
+		return postLinks{URL: bluesky.PostURL(did, postID)}
+	}
+	return postLinks{Handle: handle, URL: bluesky.PostURL(handle, postID)}
+}
+
+// threadHandles resolves the handles of everyone in a thread, the root's creator included when the root isn't stored
+func (h *Handler) threadHandles(r *http.Request, rootDID string, rows []query.GetThreadByRootRow) map[string]string {
+	if h.resolver == nil {
+		return nil
+	}
+	dids := []string{rootDID}
+	for _, row := range rows {
+		dids = append(dids, row.CreatorDid)
+	}
+	return h.resolver.ResolveAll(r.Context(), dids)
+}
+
+// addLinks sets the handle and bsky.app URL of every post in the thread
+func (n *ThreadNode) addLinks(handles map[string]string) {
+	if did, postID, err := jetstream.ParsePostURI(n.URI); err == nil {
+		links := newPostLinks(handles[did], did, postID)
+		n.Handle, n.PostURL = links.Handle, links.URL
+	}
+	for _, reply := range n.Replies {
+		reply.addLinks(handles)
+	}
+}
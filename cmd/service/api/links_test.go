@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"rayleigh/pkg/db/query"
+	"rayleigh/pkg/jetstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkPostsWithoutResolver(t *testing.T) {
+	posts := []query.GetRecentRootPostsByTagsRow{
+		{PostID: "3ldgevpyjhk2d", CreatorDid: "did:plc:rayleightestlinks", Text: "First sketch", TagName: "art", CreatedAt: time.Now().UTC()},
+	}
+	linked := linkPosts(context.Background(), nil, posts, func(p query.GetRecentRootPostsByTagsRow) (string, string) {
+		return p.CreatorDid, p.PostID
+	})
+
+	body, err := json.Marshal(linked)
+	require.NoError(t, err)
+
+	// the post's own fields still decode as before
+	var decoded []query.GetRecentRootPostsByTagsRow
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, posts, decoded)
+
+	var links []map[string]any
+	require.NoError(t, json.Unmarshal(body, &links))
+	require.Len(t, links, 1)
+	assert.Equal(t, "https://bsky.app/profile/did:plc:rayleightestlinks/post/3ldgevpyjhk2d", links[0]["URL"])
+	assert.NotContains(t, links[0], "Handle")
+
+	// no results are an empty list
+	body, err = json.Marshal(linkPosts(context.Background(), nil, []query.Post(nil), func(p query.Post) (string, string) {
+		return p.CreatorDid, p.PostID
+	}))
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(body))
+}
+
+func TestThreadLinks(t *testing.T) {
+	rootURI := jetstream.PostURI("did:test:root", "root")
+	rows := []query.GetThreadByRootRow{
+		{PostID: "reply", CreatorDid: "did:test:a", ReplyParentUri: sql.NullString{String: rootURI, Valid: true}},
+	}
+
+	thread := buildThread(rootURI, rows)
+	thread.addLinks(map[string]string{"did:test:root": "root.rayleigh.test"})
+
+	// the root isn't stored, but it still gets a link
+	assert.True(t, thread.NotFound)
+	assert.Equal(t, "root.rayleigh.test", thread.Handle)
+	assert.Equal(t, "https://bsky.app/profile/root.rayleigh.test/post/root", thread.PostURL)
+	require.Len(t, thread.Replies, 1)
+	assert.Empty(t, thread.Replies[0].Handle)
+	assert.Equal(t, "https://bsky.app/profile/did:test:a/post/reply", thread.Replies[0].PostURL)
+}
@@ -1,32 +1,121 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
+	"rayleigh/pkg/bluesky"
 	"rayleigh/pkg/db/query"
 )
 
 type Server struct {
 	handler *Handler
 	port    int
+	logger  *slog.Logger
+
+	mu     sync.Mutex
+	server *http.Server
 }
 
 func NewServer(queries *query.Queries, port int) *Server {
 	return &Server{
 		handler: NewHandler(queries),
 		port:    port,
+		logger:  slog.Default(),
 	}
 }
 
+// WithLogger sets where requests are logged, slog.Default() otherwise
+func (s *Server) WithLogger(logger *slog.Logger) *Server {
+	s.logger = logger
+	return s
+}
+
+// WithResolver adds the creators' handles to results, post URLs use their DIDs otherwise
+func (s *Server) WithResolver(resolver *bluesky.Resolver) *Server {
+	s.handler.resolver = resolver
+	return s
+}
+
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 
 	// Register routes
 	mux.HandleFunc("/api/posts/create", s.handler.CreatePostWithTags)
 	mux.HandleFunc("/api/posts/search", s.handler.SearchPosts)
+	mux.HandleFunc("/api/posts/thread", s.handler.GetThread)
+	mux.HandleFunc("/api/posts/links", s.handler.SearchLinkedPosts)
+	mux.HandleFunc("/api/posts/mentions", s.handler.SearchMentions)
 
 	// Start server
-	addr := fmt.Sprintf(":%d", s.port)
-	return http.ListenAndServe(addr, mux)
-} 
\ No newline at end of file
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.port),
+		Handler: logRequests(s.logger, mux),
+	}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	s.logger.Info("Serving API", "addr", server.Addr)
+	return server.ListenAndServe()
+}
+
+// Shutdown stops a started server, waiting for requests in flight to finish. Start then returns http.ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// logRequests logs every request with its status and latency, and the error message of server errors
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", string(rec.errBody)))
+		}
+		logger.LogAttrs(r.Context(), level, "Request", attrs...)
+	})
+}
+
+// maxLoggedError caps how much of a server error's body is logged
+const maxLoggedError = 512
+
+// statusRecorder notes the status a handler responds with, keeping the start of the body of server errors
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	errBody []byte
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status >= http.StatusInternalServerError && len(r.errBody) < maxLoggedError {
+		r.errBody = append(r.errBody, p[:min(len(p), maxLoggedError-len(r.errBody))]...)
+	}
+	return r.ResponseWriter.Write(p)
+}
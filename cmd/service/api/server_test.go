@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRequests(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fine"))
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Failed to search posts: connection refused", http.StatusInternalServerError)
+	})
+	handler := logRequests(logger, mux)
+
+	for _, path := range []string{"/ok", "/broken"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+
+	assert.Equal(t, "INFO", lines[0]["level"])
+	assert.Equal(t, "POST", lines[0]["method"])
+	assert.Equal(t, "/ok", lines[0]["path"])
+	assert.Equal(t, float64(http.StatusOK), lines[0]["status"])
+	assert.Contains(t, lines[0], "latency")
+	assert.NotContains(t, lines[0], "error")
+
+	assert.Equal(t, "ERROR", lines[1]["level"])
+	assert.Equal(t, float64(http.StatusInternalServerError), lines[1]["status"])
+	assert.Equal(t, "Failed to search posts: connection refused\n", lines[1]["error"])
+}
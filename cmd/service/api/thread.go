@@ -0,0 +1,65 @@
+package api
+
+import (
+	"time"
+
+	"rayleigh/pkg/db/query"
+	"rayleigh/pkg/jetstream"
+)
+
+type GetThreadRequest struct {
+	PostID     string `json:"post_id"`
+	CreatorDID string `json:"creator_did"`
+}
+
+// ThreadNode is a post in a reply tree. A root that isn't stored only has its URI and links set.
+type ThreadNode struct {
+	URI        string        `json:"uri"`
+	PostID     string        `json:"post_id,omitempty"`
+	CreatorDID string        `json:"creator_did,omitempty"`
+	CreatedAt  *time.Time    `json:"created_at,omitempty"`
+	Text       string        `json:"text,omitempty"`
+	Tags       []string      `json:"tags,omitempty"`
+	Handle     string        `json:"handle,omitempty"`
+	PostURL    string        `json:"url,omitempty"`
+	NotFound   bool          `json:"not_found,omitempty"`
+	Replies    []*ThreadNode `json:"replies"`
+}
+
+// buildThread arranges the rows of GetThreadByRoot into a tree under rootURI.
+// Only replies that passed the ingestion filter are stored, so a reply whose
+// parent is missing is attached to the root rather than dropped.
+func buildThread(rootURI string, rows []query.GetThreadByRootRow) *ThreadNode {
+	nodes := make(map[string]*ThreadNode, len(rows))
+	for _, row := range rows {
+		createdAt := row.CreatedAt
+		nodes[jetstream.PostURI(row.CreatorDid, row.PostID)] = &ThreadNode{
+			URI:        jetstream.PostURI(row.CreatorDid, row.PostID),
+			PostID:     row.PostID,
+			CreatorDID: row.CreatorDid,
+			CreatedAt:  &createdAt,
+			Text:       row.Text,
+			Tags:       row.Tags,
+			Replies:    []*ThreadNode{},
+		}
+	}
+
+	root, ok := nodes[rootURI]
+	if !ok {
+		root = &ThreadNode{URI: rootURI, NotFound: true, Replies: []*ThreadNode{}}
+	}
+
+	// rows are oldest first, so replies end up in posting order
+	for _, row := range rows {
+		node := nodes[jetstream.PostURI(row.CreatorDid, row.PostID)]
+		if node == root {
+			continue
+		}
+		parent, ok := nodes[row.ReplyParentUri.String]
+		if !ok || parent == node {
+			parent = root
+		}
+		parent.Replies = append(parent.Replies, node)
+	}
+	return root
+}
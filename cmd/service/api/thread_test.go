@@ -0,0 +1,51 @@
+package api
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"rayleigh/pkg/db/query"
+	"rayleigh/pkg/jetstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildThread(t *testing.T) {
+	rootURI := jetstream.PostURI("did:test:root", "root")
+	replyURI := jetstream.PostURI("did:test:a", "reply")
+	reply := func(did, postID, parentURI string) query.GetThreadByRootRow {
+		return query.GetThreadByRootRow{
+			PostID:         postID,
+			CreatorDid:     did,
+			CreatedAt:      time.Now(),
+			ReplyRootUri:   sql.NullString{String: rootURI, Valid: true},
+			ReplyParentUri: sql.NullString{String: parentURI, Valid: true},
+		}
+	}
+
+	rows := []query.GetThreadByRootRow{
+		{PostID: "root", CreatorDid: "did:test:root", Text: "root post", Tags: []string{"test"}},
+		reply("did:test:a", "reply", rootURI),
+		reply("did:test:b", "nested", replyURI),
+		// its parent was filtered out at ingestion
+		reply("did:test:c", "orphan", jetstream.PostURI("did:test:d", "missing")),
+	}
+
+	thread := buildThread(rootURI, rows)
+	assert.Equal(t, rootURI, thread.URI)
+	assert.False(t, thread.NotFound)
+	assert.Equal(t, []string{"test"}, thread.Tags)
+	require.Len(t, thread.Replies, 2)
+	assert.Equal(t, "reply", thread.Replies[0].PostID)
+	assert.Equal(t, "orphan", thread.Replies[1].PostID)
+	require.Len(t, thread.Replies[0].Replies, 1)
+	assert.Equal(t, "nested", thread.Replies[0].Replies[0].PostID)
+
+	// without a stored root the replies hang off a placeholder
+	thread = buildThread(rootURI, rows[1:])
+	assert.True(t, thread.NotFound)
+	require.Len(t, thread.Replies, 2)
+	assert.Equal(t, "reply", thread.Replies[0].PostID)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"firehose/pkg/config"
+	"firehose/pkg/db/query"
+	"firehose/pkg/logging"
+	"firehose/pkg/server/guzzle"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	logConfig  = logging.RegisterFlags(flag.CommandLine, "logs/dead_letters.log")
+	limit      = flag.Int("limit", 50, "Number of dead letters listed")
+	offset     = flag.Int("offset", 0, "Number of dead letters skipped when listing")
+	all        = flag.Bool("all", false, "Retry or discard every dead letter instead of the given ids")
+	filterPath = flag.String("filter", "", "Path to the JSON filter rules guzzle runs with, used when retrying")
+	textTags   = flag.Bool("text-tags", false, "Also take #hashtags written in post text when retrying, as guzzle -text-tags")
+)
+
+const usage = `Usage: dead_letters [flags] <command> [args]
+
+Commands:
+  list                    list dead letters, oldest first
+  show <id>               print a dead letter with its event
+  retry <id>... | -all    process dead lettered events again, deleting those that succeed
+  discard <id>... | -all  delete dead letters
+  import <file>           load a dead letter file written while the database was down
+
+A guzzle running meanwhile only learns of posts a retry writes when it restarts. Until then it
+doesn't count their likes and reposts or keep untagged replies to them.
+
+Flags:
+`
+
+func main() {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load("dead_letters", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connStr, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	q := query.New(db)
+	args := flag.Args()[1:]
+
+	switch flag.Arg(0) {
+	case "list":
+		err = list(ctx, q)
+	case "show":
+		if len(args) != 1 {
+			log.Fatal("show takes a single dead letter id")
+		}
+		err = show(ctx, q, args[0])
+	case "retry":
+		err = retry(ctx, q, connStr, args)
+	case "discard":
+		err = discard(ctx, q, args)
+	case "import":
+		if len(args) != 1 {
+			log.Fatal("import takes the path of a dead letter file")
+		}
+		err = importFile(ctx, q, args[0])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func list(ctx context.Context, q *query.Queries) error {
+	letters, err := q.ListDeadLetters(ctx, query.ListDeadLettersParams{RowLimit: int32(*limit), RowOffset: int32(*offset)})
+	if err != nil {
+		return fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDID\tTIME_US\tOPERATION\tRKEY\tATTEMPTS\tLAST FAILED\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%d\t%s\t%s\n",
+			letter.ID, letter.Did, letter.TimeUs, letter.Operation, letter.Rkey,
+			letter.Attempts, letter.LastFailedAt.Format(time.RFC3339), letter.Error)
+	}
+	return w.Flush()
+}
+
+func show(ctx context.Context, q *query.Queries, arg string) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+	letter, err := q.GetDeadLetter(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get dead letter %d: %w", id, err)
+	}
+
+	var event bytes.Buffer
+	if err := json.Indent(&event, letter.Event, "", "  "); err != nil {
+		return fmt.Errorf("failed to format event of dead letter %d: %w", id, err)
+	}
+	fmt.Printf("ID:           %d\n", letter.ID)
+	fmt.Printf("DID:          %s\n", letter.Did)
+	fmt.Printf("Time:         %d\n", letter.TimeUs)
+	fmt.Printf("Commit:       %s %s %s\n", letter.Operation, letter.Collection, letter.Rkey)
+	fmt.Printf("Attempts:     %d\n", letter.Attempts)
+	fmt.Printf("First failed: %s\n", letter.FirstFailedAt.Format(time.RFC3339))
+	fmt.Printf("Last failed:  %s\n", letter.LastFailedAt.Format(time.RFC3339))
+	fmt.Printf("Error:        %s\n", letter.Error)
+	fmt.Printf("Event:\n%s\n", event.String())
+	return nil
+}
+
+func retry(ctx context.Context, q *query.Queries, connStr string, args []string) error {
+	letters, err := selectLetters(ctx, q, args)
+	if err != nil {
+		return err
+	}
+
+	var filter guzzle.FilterRules
+	if *filterPath != "" {
+		if filter, err = guzzle.LoadFilterRules(*filterPath); err != nil {
+			return fmt.Errorf("failed to load filter rules: %w", err)
+		}
+	}
+	logger, logCloser, err := logging.New(*logConfig)
+	if err != nil {
+		return err
+	}
+	defer logCloser.Close()
+	// retries that fail again go back to the table, there's no file to fall back to
+	g, err := guzzle.New(&guzzle.Config{
+		Logger:      logger,
+		DatabaseURL: connStr,
+		Filter:      filter,
+		TextTags:    *textTags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create guzzle: %w", err)
+	}
+	defer g.Close()
+
+	var failed int
+	for _, letter := range letters {
+		if err := g.RetryDeadLetter(ctx, letter); err != nil {
+			failed++
+			log.Printf("Dead letter %d failed again: %v", letter.ID, err)
+			continue
+		}
+		log.Printf("Dead letter %d processed", letter.ID)
+	}
+	log.Printf("Retried %d dead letters, %d failed again", len(letters), failed)
+	return nil
+}
+
+func discard(ctx context.Context, q *query.Queries, args []string) error {
+	letters, err := selectLetters(ctx, q, args)
+	if err != nil {
+		return err
+	}
+	ids := make([]int32, 0, len(letters))
+	for _, letter := range letters {
+		ids = append(ids, letter.ID)
+	}
+	if err := q.DeleteDeadLetters(ctx, ids); err != nil {
+		return fmt.Errorf("failed to delete dead letters: %w", err)
+	}
+	log.Printf("Discarded %d dead letters", len(ids))
+	return nil
+}
+
+func importFile(ctx context.Context, q *query.Queries, path string) error {
+	letters, err := guzzle.ReadDeadLetterFile(path)
+	if err != nil {
+		return err
+	}
+	for _, letter := range letters {
+		if err := q.SaveDeadLetter(ctx, letter); err != nil {
+			return fmt.Errorf("failed to save dead letter for event %d from %s: %w", letter.TimeUs, letter.Did, err)
+		}
+	}
+	log.Printf("Imported %d dead letters from %s, it can now be removed", len(letters), path)
+	return nil
+}
+
+// selectLetters loads the dead letters named by id, or all of them with -all
+func selectLetters(ctx context.Context, q *query.Queries, args []string) ([]query.DeadLetter, error) {
+	if *all {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("-all can't be combined with dead letter ids")
+		}
+		var letters []query.DeadLetter
+		const page = 500
+		for offset := int32(0); ; offset += page {
+			batch, err := q.ListDeadLetters(ctx, query.ListDeadLettersParams{RowLimit: page, RowOffset: offset})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list dead letters: %w", err)
+			}
+			letters = append(letters, batch...)
+			if len(batch) < page {
+				return letters, nil
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("give dead letter ids or -all")
+	}
+	letters := make([]query.DeadLetter, 0, len(args))
+	for _, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return nil, err
+		}
+		letter, err := q.GetDeadLetter(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dead letter %d: %w", id, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func parseID(arg string) (int32, error) {
+	id, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid dead letter id %q", arg)
+	}
+	return int32(id), nil
+}
@@ -3,26 +3,35 @@ package main
 import (
 	"context"
 	"database/sql"
-	"firehose/pkg/db"
+	"firehose/pkg/config"
 	"firehose/pkg/db/query"
+	"flag"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() < 1 {
 		log.Fatal("Please provide a tag name as an argument.")
 	}
 
-	tagName := os.Args[1]
+	tagName := flag.Arg(0)
 	limit := int32(10)
 	offset := int32(0)
 
-	connStr := db.GetPostgresURL()
+	cfg, err := config.Load("find_posts_by_tag", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connStr, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
 	dbConn, err := sql.Open("postgres", connStr)
 	if err != nil {
 		log.Fatalf("Failed to connect to the database: %v", err)
@@ -32,11 +41,11 @@ func main() {
 	dbQueries := query.New(dbConn)
 
 	for {
-		posts, err := dbQueries.GetRecentPostsByTags(context.Background(), query.GetRecentPostsByTagsParams{
-			Column1:   []string{tagName},
-			CreatedAt: time.Now(), // Use a specific time if needed
-			Limit:     limit,
-			Offset:    offset,
+		posts, err := dbQueries.GetRecentRootPostsByTags(context.Background(), query.GetRecentRootPostsByTagsParams{
+			TagNames:     []string{tagName},
+			CreatedAfter: time.Time{}, // every post, use a specific time if needed
+			RowLimit:     limit,
+			RowOffset:    offset,
 		})
 		if err != nil {
 			log.Fatalf("Failed to get posts: %v", err)
@@ -1,24 +1,30 @@
 package main
 
 import (
+	"flag"
 	"log"
-	"os"
 
+	"firehose/pkg/config"
+	"firehose/pkg/db/generate"
 	"firehose/pkg/db/migration"
 )
 
 func main() {
-	if err := migration.LoadEnv(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-
 	// Check if sqlc is installed
-	if ok, instruction := migration.CheckSQLC(); !ok {
+	if ok, instruction := generate.CheckSQLC(); !ok {
 		log.Fatalf("sqlc not found: %s", instruction)
 	}
 
-	// Use connection string from environment
-	connectionString := os.Getenv("POSTGRESQL_URL")
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := config.Load("migrate_down", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connectionString, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
 	migrationsPath := "file://db/migrations"
 
 	if err := migration.MigrateDown(connectionString, migrationsPath); err != nil {
@@ -26,5 +32,5 @@ func main() {
 	}
 
 	// Generate SQLC bindings
-	migration.GenerateSQLC()
+	generate.GenerateSQLC()
 }
@@ -1,26 +1,30 @@
 package main
 
 import (
+	"flag"
 	"log"
 
-	"firehose/pkg/db"
+	"firehose/pkg/config"
 	"firehose/pkg/db/generate"
 	"firehose/pkg/db/migration"
-
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := config.Load("migrate_up", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 	// Check if sqlc is installed
 	if ok, instruction := generate.CheckSQLC(); !ok {
 		log.Fatalf("sqlc not found: %s", instruction)
 	}
 
-	// Use connection string from environment
-	connectionString := db.GetPostgresURL()
+	connectionString, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
 	migrationsPath := "file://db/migrations"
 
 	if err := migration.MigrateUp(connectionString, migrationsPath); err != nil {
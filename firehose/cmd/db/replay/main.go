@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"firehose/pkg/config"
+	"firehose/pkg/logging"
+	"firehose/pkg/replay"
+	"firehose/pkg/server/guzzle"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	_ "github.com/lib/pq"
+)
+
+var (
+	logConfig  = logging.RegisterFlags(flag.CommandLine, "logs/replay.log")
+	from       = flag.String("from", "", "Skip events before this time, as time_us, RFC3339 or YYYY-MM-DD")
+	to         = flag.String("to", "", "Skip events after this time, as time_us, RFC3339 or YYYY-MM-DD")
+	dryRun     = flag.Bool("dry-run", false, "Report what would be written without touching the database")
+	progress   = flag.Duration("progress", 10*time.Second, "How often progress is reported")
+	did        = flag.String("did", replay.DefaultDID, "DID bare post records are credited to, they don't carry one")
+	batchSize  = flag.Int("batch-size", 100, "Number of posts written to the database per batch")
+	filterPath = flag.String("filter", "", "Path to a JSON file of filter rules, as guzzle -filter")
+	textTags   = flag.Bool("text-tags", false, "Also take #hashtags written in post text, as guzzle -text-tags")
+)
+
+const usage = `Usage: replay [flags] <path>...
+
+Feeds events through guzzle's processing as if they had come from the firehose. A path can be
+an event archive directory, a .zst archive segment or a file of jetstream events, exported post
+rows or bare post records, like those in db/test/data/samples.
+
+A guzzle running meanwhile only learns of the posts written here when it restarts. Until then it
+doesn't count their likes and reposts or keep untagged replies to them.
+
+Flags:
+`
+
+// stats counts what happened to the events read so far
+type stats struct {
+	read      int
+	failed    int
+	malformed int
+	// events by dry run outcome, a commit operation or a filter reason
+	outcomes  map[string]int
+	lastEvent int64
+	started   time.Time
+}
+
+func main() {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load("replay", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// a dry run never touches the database, so it doesn't need one configured
+	var connStr string
+	if !*dryRun {
+		if connStr, err = cfg.Database.ConnString(); err != nil {
+			log.Fatalf("Invalid database configuration: %v", err)
+		}
+	}
+
+	opts := replay.Options{DID: *did}
+	if opts.FromUS, err = parseTime(*from); err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	if opts.ToUS, err = parseTime(*to); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	var filter guzzle.FilterRules
+	if *filterPath != "" {
+		if filter, err = guzzle.LoadFilterRules(*filterPath); err != nil {
+			log.Fatalf("Failed to load filter rules: %v", err)
+		}
+	}
+	// processed events are logged there, progress and the summary go to the terminal
+	logger, logCloser, err := logging.New(*logConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logCloser.Close()
+	g, err := guzzle.New(&guzzle.Config{
+		Logger:      logger,
+		DatabaseURL: connStr,
+		BatchSize:   *batchSize,
+		Filter:      filter,
+		TextTags:    *textTags,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create guzzle: %v", err)
+	}
+	defer g.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	s := &stats{outcomes: map[string]int{}, started: time.Now()}
+	lastReport := time.Now()
+	for _, path := range flag.Args() {
+		log.Printf("Replaying %s", path)
+		err := replay.Read(path, opts, func(evt *models.Event, decodeErr error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if decodeErr != nil {
+				s.malformed++
+				log.Printf("Skipping %v", decodeErr)
+				return nil
+			}
+
+			s.read++
+			s.lastEvent = evt.TimeUS
+			if err := process(ctx, g, evt, s); err != nil {
+				s.failed++
+				log.Printf("Event %d from %s failed: %v", evt.TimeUS, evt.Did, err)
+			}
+			if time.Since(lastReport) >= *progress {
+				s.report()
+				lastReport = time.Now()
+			}
+			return nil
+		})
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Interrupted")
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to replay %s: %v", path, err)
+		}
+	}
+
+	if !*dryRun {
+		if err := g.Flush(context.Background()); err != nil {
+			log.Printf("Failed to write the last batch: %v", err)
+		}
+	}
+	s.report()
+	s.summary()
+}
+
+// process replays an event, or just works out what replaying it would do in a dry run
+func process(ctx context.Context, g *guzzle.Guzzle, evt *models.Event, s *stats) error {
+	if !*dryRun {
+		return g.ReplayEvent(ctx, evt)
+	}
+	outcome, err := g.DryRun(evt)
+	if err != nil {
+		return err
+	}
+	s.outcomes[outcome]++
+	return nil
+}
+
+func (s *stats) report() {
+	elapsed := time.Since(s.started)
+	var at string
+	if s.lastEvent > 0 {
+		at = ", at " + time.UnixMicro(s.lastEvent).UTC().Format(time.RFC3339)
+	}
+	log.Printf("Progress - Events: %d (%.0f/s), Failed: %d, Malformed: %d%s",
+		s.read, float64(s.read)/elapsed.Seconds(), s.failed, s.malformed, at)
+}
+
+// summary lists the dry run outcomes, most common first
+func (s *stats) summary() {
+	if !*dryRun {
+		return
+	}
+	outcomes := make([]string, 0, len(s.outcomes))
+	for outcome := range s.outcomes {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		if s.outcomes[outcomes[i]] != s.outcomes[outcomes[j]] {
+			return s.outcomes[outcomes[i]] > s.outcomes[outcomes[j]]
+		}
+		return outcomes[i] < outcomes[j]
+	})
+
+	log.Printf("Dry run, nothing was written. Events would be:")
+	for _, outcome := range outcomes {
+		label := "filtered, " + outcome
+		switch outcome {
+		case models.CommitOperationCreate, models.CommitOperationUpdate, models.CommitOperationDelete:
+			label = outcome
+		}
+		log.Printf("  %-28s %d", label, s.outcomes[outcome])
+	}
+}
+
+// parseTime reads a time given as time_us, RFC3339 or a date, returning it as time_us
+func parseTime(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if timeUS, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return timeUS, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UnixMicro(), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a time_us, RFC3339 time or YYYY-MM-DD date", value)
+}
@@ -1,41 +1,51 @@
 package main
 
 import (
+	"firehose/pkg/config"
 	"firehose/pkg/db"
 	"firehose/pkg/db/generate"
 	"firehose/pkg/db/migration"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
 	// Check if sqlc is installed
 	if ok, instruction := generate.CheckSQLC(); !ok {
 		log.Fatalf("sqlc not found: %s", instruction)
 	}
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+	cfg, err := config.Load("setup_local_db", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connStr, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
 	}
-
-	connStr := db.GetPostgresURL()
 
 	// Check if the database exists
-	if !db.DatabaseExists(connStr) {
+	exists, err := db.DatabaseExists(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to check the database: %v", err)
+	}
+	if !exists {
 		fmt.Println("Database does not exist.")
-		if db.CreateDatabase() {
-			fmt.Println("Database created successfully.")
-			if !createInitialMigration() {
-				log.Println("Failed to create initial migration files.")
-			}
-		} else {
-			log.Fatal("Failed to create database.")
+		if err := db.CreateDatabase(cfg.Database); err != nil {
+			log.Fatalf("Failed to create database: %v", err)
+		}
+		fmt.Println("Database created successfully.")
+		if !createInitialMigration() {
+			log.Println("Failed to create initial migration files.")
 		}
 	} else {
 		fmt.Println("Database exists.")
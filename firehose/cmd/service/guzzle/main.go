@@ -4,34 +4,103 @@ import (
 	"context"
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
+	"firehose/pkg/config"
+	"firehose/pkg/logging"
 	"firehose/pkg/server/guzzle"
 )
 
 var (
-	logPath = flag.String("log", "logs/guzzle.log", "Path to the log file")
-	cursor  = flag.String("cursor", "", "Cursor in DD/MM/YYYY format")
+	logConfig     = logging.RegisterFlags(flag.CommandLine, "logs/guzzle.log")
+	cursor        = flag.String("cursor", "", "Where to start reading, overriding the saved cursor: a time_us, RFC3339 time, DD/MM/YYYY date, a duration ago like -2h, or last-saved. Empty resumes from the saved cursor or goes live")
+	replayWindow  = flag.Duration("replay-window", 24*time.Hour, "How far back jetstream keeps events, older cursors warn that events were lost")
+	workers       = flag.Int("workers", 1, "Number of event processing workers, events from the same DID stay in order")
+	queueSize     = flag.Int("queue-size", 100, "Events each worker can queue before reading from the firehose pauses")
+	batchSize     = flag.Int("batch-size", 1, "Number of posts written to the database per batch")
+	flushInterval = flag.Duration("flush-interval", time.Second, "Longest a partial batch of posts waits before it's written")
+	httpAddr      = flag.String("http-addr", ":8081", "Address for the /metrics, /healthz and /readyz endpoints, empty to disable")
+	readyWindow   = flag.Duration("ready-window", time.Minute, "Readiness fails when no event has arrived for this long")
+	filterPath    = flag.String("filter", "", "Path to a JSON file of filter rules, by default posts with tags that aren't replies are kept")
+	textTags      = flag.Bool("text-tags", false, "Also take #hashtags written in post text that have no tag facet")
+	deadLetters   = flag.String("dead-letter-file", "logs/dead_letters.jsonl", "File failed events are written to when the database can't take them, empty to drop them")
+	archiveDir    = flag.String("archive-dir", "", "Directory raw events are archived to as zstd compressed JSONL segments, empty to disable")
+	archiveKept   = flag.Bool("archive-kept-only", false, "Archive only the events of posts that pass the filter, post deletes and account changes, instead of every event")
+	archiveSizeMB = flag.Int64("archive-segment-mb", 256, "Uncompressed size in MB at which an archive segment is rotated")
+	archiveKeep   = flag.Duration("archive-retention", 7*24*time.Hour, "Archive segments older than this are deleted, 0 keeps them forever")
+	purgeInterval = flag.Duration("purge-interval", time.Hour, "How often the posts of deleted accounts are hard deleted, 0 to never purge them")
+	tagSweep      = flag.Duration("tag-sweep-interval", time.Hour, "How often tags no post uses any more are deleted, 0 to never delete them")
 )
 
 func main() {
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	cfg, err := config.Load("guzzle", flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	connStr, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+
+	// Everything, including the jetstream client and the standard logger, goes to the one structured log
+	logger, logCloser, err := logging.New(*logConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logCloser.Close()
+	slog.SetDefault(logger)
+
+	if *deadLetters != "" {
+		if err := os.MkdirAll(filepath.Dir(*deadLetters), 0755); err != nil {
+			fatal("Failed to create dead letter directory", err)
+		}
+	}
+
+	// Catch a bad cursor before connecting to anything
+	if _, err := guzzle.ParseCursor(*cursor, time.Now()); err != nil {
+		fatal("Invalid -cursor", err)
+	}
 
-	// Create logs directory if needed
-	logDir := filepath.Dir(*logPath)
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		log.Fatalf("Failed to create logs directory: %v", err)
+	var filter guzzle.FilterRules
+	if *filterPath != "" {
+		rules, err := guzzle.LoadFilterRules(*filterPath)
+		if err != nil {
+			fatal("Failed to load filter rules", err)
+		}
+		filter = rules
 	}
 
 	// Create guzzle service
 	g, err := guzzle.New(&guzzle.Config{
-		LogPath: *logPath,
+		Logger:         logger,
+		DatabaseURL:    connStr,
+		Workers:        *workers,
+		QueueSize:      *queueSize,
+		BatchSize:      *batchSize,
+		FlushInterval:  *flushInterval,
+		HTTPAddr:       *httpAddr,
+		ReadyWindow:    *readyWindow,
+		ReplayWindow:   *replayWindow,
+		Filter:         filter,
+		TextTags:       *textTags,
+		DeadLetterPath: *deadLetters,
+
+		ArchiveDir:         *archiveDir,
+		ArchiveKeptOnly:    *archiveKept,
+		ArchiveSegmentSize: *archiveSizeMB * 1024 * 1024,
+		ArchiveRetention:   *archiveKeep,
+		PurgeInterval:      *purgeInterval,
+		TagSweepInterval:   *tagSweep,
 	})
 	if err != nil {
-		log.Fatalf("Failed to create guzzle service: %v", err)
+		fatal("Failed to create guzzle service", err)
 	}
 
 	// Ensure clean shutdown
@@ -39,7 +108,7 @@ func main() {
 	defer func() {
 		cancel()
 		if err := g.Close(); err != nil {
-			log.Printf("Error during shutdown: %v", err)
+			logger.Error("Error during shutdown", "error", err)
 		}
 	}()
 
@@ -48,13 +117,18 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigCh
-		log.Printf("Received %s signal, initiating graceful shutdown...", sig)
+		logger.Info("Received signal, initiating graceful shutdown", "signal", sig.String())
 		cancel()
 	}()
 
 	// Run guzzle service with cursor and duration
-	log.Printf("Starting guzzle service ")
 	if err := g.Run(ctx, *cursor); err != nil && err != context.Canceled {
-		log.Printf("Guzzle service error: %v", err)
+		logger.Error("Guzzle service error", "error", err)
 	}
 }
+
+// fatal logs at error level, where log.Fatalf would go through the structured log at info, and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
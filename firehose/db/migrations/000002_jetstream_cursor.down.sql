@@ -0,0 +1,3 @@
+-- Migration to drop the jetstream_cursors table
+
+DROP TABLE IF EXISTS jetstream_cursors;
@@ -0,0 +1,7 @@
+-- Migration to create the jetstream_cursors table used to resume ingestion after a restart
+
+CREATE TABLE IF NOT EXISTS jetstream_cursors (
+    name VARCHAR(64) PRIMARY KEY,
+    time_us BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- Migration to drop the unique (creator_did, post_id) constraint, removed duplicates are not restored
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_creator_did_post_id_key;
@@ -0,0 +1,22 @@
+-- Migration to make (creator_did, post_id) unique so replayed events can't duplicate posts
+
+-- move the tag links of duplicate rows onto the earliest row for each post
+WITH ranked_posts AS (
+    SELECT id, MIN(id) OVER (PARTITION BY creator_did, post_id) AS keep_id
+    FROM posts
+)
+INSERT INTO post_tags (post_id, tag_id, created_at)
+SELECT ranked_posts.keep_id, pt.tag_id, pt.created_at
+FROM post_tags pt
+JOIN ranked_posts ON pt.post_id = ranked_posts.id
+WHERE ranked_posts.id <> ranked_posts.keep_id
+ON CONFLICT (post_id, tag_id) DO NOTHING;
+
+-- then drop the duplicates, their post_tags cascade
+DELETE FROM posts p
+USING posts keep
+WHERE p.creator_did = keep.creator_did
+  AND p.post_id = keep.post_id
+  AND p.id > keep.id;
+
+ALTER TABLE posts ADD CONSTRAINT posts_creator_did_post_id_key UNIQUE (creator_did, post_id);
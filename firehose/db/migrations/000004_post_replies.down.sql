@@ -0,0 +1,13 @@
+-- Migration to drop the reply references. Replies are deleted first, without the
+-- references they would be indistinguishable from top level posts.
+
+DELETE FROM posts WHERE reply_root_uri IS NOT NULL;
+DELETE FROM tags t WHERE NOT EXISTS (SELECT 1 FROM post_tags pt WHERE pt.tag_id = t.id);
+
+DROP INDEX IF EXISTS idx_posts_reply_root_uri;
+
+ALTER TABLE posts
+    DROP COLUMN IF EXISTS reply_root_uri,
+    DROP COLUMN IF EXISTS reply_root_cid,
+    DROP COLUMN IF EXISTS reply_parent_uri,
+    DROP COLUMN IF EXISTS reply_parent_cid;
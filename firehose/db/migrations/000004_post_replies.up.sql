@@ -0,0 +1,9 @@
+-- Migration to store the thread a reply belongs to, NULL for top level posts
+
+ALTER TABLE posts
+    ADD COLUMN IF NOT EXISTS reply_root_uri VARCHAR(512),
+    ADD COLUMN IF NOT EXISTS reply_root_cid VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS reply_parent_uri VARCHAR(512),
+    ADD COLUMN IF NOT EXISTS reply_parent_cid VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_posts_reply_root_uri ON posts(reply_root_uri);
@@ -0,0 +1,5 @@
+-- Migration to drop post languages
+
+DROP INDEX IF EXISTS idx_posts_langs;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS langs;
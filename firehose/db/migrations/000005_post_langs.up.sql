@@ -0,0 +1,5 @@
+-- Migration to store the languages a post declares, lowercased BCP-47 tags
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS langs TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_posts_langs ON posts USING GIN (langs);
@@ -0,0 +1,4 @@
+-- Migration to drop the mention and link indexes
+
+DROP TABLE IF EXISTS post_links;
+DROP TABLE IF EXISTS post_mentions;
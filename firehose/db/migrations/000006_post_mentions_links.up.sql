@@ -0,0 +1,18 @@
+-- Migration to index the mention and link facets of posts
+
+CREATE TABLE IF NOT EXISTS post_mentions (
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    did VARCHAR(255) NOT NULL,
+    PRIMARY KEY (post_id, did)
+);
+
+CREATE TABLE IF NOT EXISTS post_links (
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- lowercased host without a leading www.
+    domain VARCHAR(255) NOT NULL,
+    PRIMARY KEY (post_id, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_mentions_did ON post_mentions(did);
+CREATE INDEX IF NOT EXISTS idx_post_links_domain ON post_links(domain);
@@ -0,0 +1,4 @@
+-- Migration to drop the embeds of posts
+
+DROP TABLE IF EXISTS post_media;
+DROP TABLE IF EXISTS post_embeds;
@@ -0,0 +1,24 @@
+-- Migration to store the embeds of posts: link cards, quoted posts and attached media
+
+CREATE TABLE IF NOT EXISTS post_embeds (
+    post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    -- the $type of the embed, e.g. app.bsky.embed.recordWithMedia
+    embed_type VARCHAR(64) NOT NULL,
+    external_uri TEXT,
+    external_title TEXT,
+    quoted_uri VARCHAR(512),
+    quoted_cid VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS post_media (
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    -- 1-based order of the image or video in the post
+    position INTEGER NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    blob_cid VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(255) NOT NULL DEFAULT '',
+    alt TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (post_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_embeds_quoted_uri ON post_embeds(quoted_uri) WHERE quoted_uri IS NOT NULL;
@@ -0,0 +1 @@
+-- Merged tags can't be split apart again, the canonical names are kept
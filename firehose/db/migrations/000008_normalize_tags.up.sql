@@ -0,0 +1,38 @@
+-- Migration to merge tags that only differ in the form jetstream.NormalizeTag canonicalizes away,
+-- e.g. "#Art", "art" and "ＡＲＴ". normalize() needs Postgres 13 and a UTF8 database.
+-- Postgres has no case folding, lower() matches it for everything but a few special cases like ß.
+
+CREATE TEMPORARY TABLE tag_canonical AS
+SELECT id, CASE WHEN char_length(c.name) > 64 THEN '' ELSE c.name END AS name
+FROM (
+    SELECT id, btrim(regexp_replace(btrim(normalize(lower(normalize(name, NFKC)), NFKC)), '^#', '')) AS name
+    FROM tags
+) AS c;
+
+-- every tag maps onto the one already spelled canonically, or the oldest one
+CREATE TEMPORARY TABLE tag_merges AS
+SELECT c.id, c.name,
+       first_value(c.id) OVER (PARTITION BY c.name ORDER BY (t.name = c.name) DESC, c.id) AS keep_id
+FROM tag_canonical c
+JOIN tags t ON t.id = c.id
+WHERE c.name <> '';
+
+INSERT INTO post_tags (post_id, tag_id, created_at)
+SELECT pt.post_id, m.keep_id, MIN(pt.created_at)
+FROM post_tags pt
+JOIN tag_merges m ON m.id = pt.tag_id
+WHERE m.id <> m.keep_id
+GROUP BY pt.post_id, m.keep_id
+ON CONFLICT (post_id, tag_id) DO NOTHING;
+
+-- post_tags of the merged and unusable tags go with them
+DELETE FROM tags WHERE id IN (SELECT id FROM tag_merges WHERE id <> keep_id);
+DELETE FROM tags WHERE id IN (SELECT id FROM tag_canonical WHERE name = '');
+
+UPDATE tags t
+SET name = m.name
+FROM tag_merges m
+WHERE t.id = m.id AND t.name <> m.name;
+
+DROP TABLE tag_merges;
+DROP TABLE tag_canonical;
@@ -0,0 +1,3 @@
+-- Migration to drop the source of post tags
+
+ALTER TABLE post_tags DROP COLUMN IF EXISTS source;
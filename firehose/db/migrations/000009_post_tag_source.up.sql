@@ -0,0 +1,3 @@
+-- Migration to record whether a post's tag came from a tag facet or was parsed from its text
+
+ALTER TABLE post_tags ADD COLUMN IF NOT EXISTS source VARCHAR(16) NOT NULL DEFAULT 'facet';
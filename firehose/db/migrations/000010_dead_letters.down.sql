@@ -0,0 +1,3 @@
+-- Migration to drop the dead letter store
+
+DROP TABLE IF EXISTS dead_letters;
@@ -0,0 +1,20 @@
+-- Migration to keep the events that failed processing so they can be inspected and retried
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id SERIAL PRIMARY KEY,
+    did VARCHAR(255) NOT NULL,
+    time_us BIGINT NOT NULL,
+    collection VARCHAR(255) NOT NULL DEFAULT '',
+    operation VARCHAR(16) NOT NULL DEFAULT '',
+    rkey VARCHAR(255) NOT NULL DEFAULT '',
+    -- the error of the latest attempt
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    -- the jetstream event as received
+    event JSONB NOT NULL,
+    first_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (did, time_us)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_last_failed_at ON dead_letters(last_failed_at);
@@ -0,0 +1,3 @@
+-- Migration to drop the account statuses and handles
+
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,16 @@
+-- Migration to keep the status and current handle of accounts, from jetstream's account and identity events
+
+CREATE TABLE IF NOT EXISTS accounts (
+    did VARCHAR(255) PRIMARY KEY,
+    -- false once the account is deactivated, taken down, suspended or deleted, its posts are hidden
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    -- why the account is inactive, e.g. takendown or deleted, empty while it's active
+    status VARCHAR(32) NOT NULL DEFAULT '',
+    handle VARCHAR(255),
+    -- time_us of the events that set the status and the handle, so older events replayed later don't undo them
+    status_time_us BIGINT NOT NULL DEFAULT 0,
+    handle_time_us BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_accounts_inactive ON accounts(status) WHERE NOT active;
@@ -0,0 +1,4 @@
+-- Migration to drop the like and repost counts
+
+DROP TABLE IF EXISTS post_engagement;
+DROP TABLE IF EXISTS post_interactions;
@@ -0,0 +1,21 @@
+-- Migration to count the likes and reposts of stored posts
+
+-- the likes and reposts counted, so their deletes, which only name the like or repost, can be uncounted
+CREATE TABLE IF NOT EXISTS post_interactions (
+    did VARCHAR(255) NOT NULL,
+    -- like or repost
+    kind VARCHAR(16) NOT NULL,
+    rkey VARCHAR(255) NOT NULL,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (did, kind, rkey)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_interactions_post_id ON post_interactions(post_id);
+
+-- kept up to date as likes and reposts come and go
+CREATE TABLE IF NOT EXISTS post_engagement (
+    post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    likes INTEGER NOT NULL DEFAULT 0,
+    reposts INTEGER NOT NULL DEFAULT 0
+);
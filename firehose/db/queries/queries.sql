@@ -1,51 +1,462 @@
 
 -- name: GetRecentRootPostsByTags :many
-SELECT DISTINCT p.*, t.name AS tag_name
+-- tagged root posts, newest first or, with sort_by_engagement, most liked and reposted first.
+-- A post has one post_tags row per tag, so it's returned once for each requested tag it carries.
+SELECT p.*, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
 FROM posts p
 JOIN post_tags pt ON p.id = pt.post_id
 JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
 WHERE t.name = ANY(@tag_names::text[]) 
   AND p.created_at >= @created_after
-ORDER BY p.created_at DESC
+  AND p.reply_root_uri IS NULL
+  AND (NOT @media_only::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN @sort_by_engagement::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
 LIMIT @row_limit OFFSET @row_offset;
 
 -- name: GetRecentRootPostsByTagAndCreator :many
-SELECT p.*, t.name AS tag_name
+SELECT p.*, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
 FROM posts p
 JOIN post_tags pt ON p.id = pt.post_id
 JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
 WHERE t.name = ANY(@tag_names::text[])
   AND p.created_at >= @created_after
   AND p.creator_did = ANY(@creator_dids::text[])
+  AND p.reply_root_uri IS NULL
+  AND (NOT @media_only::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN @sort_by_engagement::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: GetRecentRootPostsByTagsAndLangs :many
+-- like GetRecentRootPostsByTags, only posts declaring at least one of the given lowercased languages
+SELECT p.*, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
+WHERE t.name = ANY(@tag_names::text[])
+  AND p.created_at >= @created_after
+  AND p.reply_root_uri IS NULL
+  AND p.langs && @langs::text[]
+  AND (NOT @media_only::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN @sort_by_engagement::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: GetRecentRootPostsByTagAndCreatorAndLangs :many
+-- like GetRecentRootPostsByTagAndCreator, only posts declaring at least one of the given lowercased languages
+SELECT p.*, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
+WHERE t.name = ANY(@tag_names::text[])
+  AND p.created_at >= @created_after
+  AND p.creator_did = ANY(@creator_dids::text[])
+  AND p.reply_root_uri IS NULL
+  AND p.langs && @langs::text[]
+  AND (NOT @media_only::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN @sort_by_engagement::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: GetRecentRootPostsByTagsAndLinkDomain :many
+-- root posts in any of the tags linking to the domain or one of its subdomains
+SELECT DISTINCT p.*, t.name AS tag_name, l.url AS link_url
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+JOIN post_links l ON p.id = l.post_id
+WHERE t.name = ANY(@tag_names::text[])
+  AND (l.domain = @domain::text OR right(l.domain, length(@domain::text) + 1) = '.' || @domain::text)
+  AND p.created_at >= @created_after
+  AND p.reply_root_uri IS NULL
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY p.created_at DESC
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: GetRecentPostsByMention :many
+-- posts and replies mentioning the DID
+SELECT p.*
+FROM posts p
+JOIN post_mentions m ON p.id = m.post_id
+WHERE m.did = @did
+  AND p.created_at >= @created_after
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
 ORDER BY p.created_at DESC
 LIMIT @row_limit OFFSET @row_offset;
 
 -- name: GetPostById :one
 SELECT * FROM posts WHERE id = $1;
 
+-- name: GetPost :one
+-- not found while the creator's account is inactive
+SELECT p.* FROM posts p
+WHERE p.creator_did = @creator_did AND p.post_id = @post_id
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active);
+
+-- name: GetThreadByRoot :many
+-- the root post, when stored, and every stored reply in its thread with their tags, oldest first
+SELECT p.*, COALESCE(array_agg(t.name) FILTER (WHERE t.name IS NOT NULL), '{}')::text[] AS tags
+FROM posts p
+LEFT JOIN post_tags pt ON p.id = pt.post_id
+LEFT JOIN tags t ON pt.tag_id = t.id
+WHERE (p.reply_root_uri = @root_uri::text
+   OR (p.creator_did = @root_creator_did AND p.post_id = @root_post_id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+GROUP BY p.id
+ORDER BY p.created_at, p.id;
+
 -- name: CreatePostWithTags :exec
--- $6: tags
+-- inserts a post with its tags, mentions, links and embed, doing nothing if it is already stored.
+-- Tags are upserted rather than skipped when they exist: the no-op update locks the tag, in name order, and returns
+-- its id even when another transaction inserted it after this statement's snapshot was taken.
 WITH new_post AS (
-    INSERT INTO posts (post_id, creator_did, created_at, text)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    ON CONFLICT (creator_did, post_id) DO NOTHING
     RETURNING id
 ),
-inserted_tags AS (
+all_tags AS (
     INSERT INTO tags (name)
-    SELECT unnest(sqlc.arg('tags')::text[])
-    ON CONFLICT (name) DO NOTHING
+    SELECT DISTINCT name FROM unnest(sqlc.arg('tags')::text[]) AS t(name)
+    ORDER BY name
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
     RETURNING id, name
 ),
-existing_tags AS (
-    SELECT id, name
-    FROM tags
-    WHERE name = ANY(sqlc.arg('tags')::text[])
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT new_post.id, m.did
+    FROM new_post, unnest(sqlc.arg('mentions')::text[]) AS m(did)
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT new_post.id, l.url, l.domain
+    FROM new_post, unnest(sqlc.arg('link_urls')::text[], sqlc.arg('link_domains')::text[]) AS l(url, domain)
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+inserted_embed AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT new_post.id, sqlc.narg('embed_type')::text, sqlc.narg('external_uri')::text, sqlc.narg('external_title')::text, sqlc.narg('quoted_uri')::text, sqlc.narg('quoted_cid')::text
+    FROM new_post
+    WHERE sqlc.narg('embed_type')::text IS NOT NULL
+    ON CONFLICT (post_id) DO NOTHING
+),
+inserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT new_post.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM new_post, unnest(sqlc.arg('media_kinds')::text[], sqlc.arg('media_cids')::text[], sqlc.arg('media_mime_types')::text[], sqlc.arg('media_alts')::text[]) WITH ORDINALITY AS m(kind, blob_cid, mime_type, alt, position)
+    ON CONFLICT (post_id, position) DO NOTHING
 )
-INSERT INTO post_tags (post_id, tag_id)
-SELECT new_post.id, tag_id
-FROM (
-    SELECT id AS tag_id FROM inserted_tags
-    UNION
-    SELECT id AS tag_id FROM existing_tags
-) AS all_tags
-JOIN new_post ON true;
\ No newline at end of file
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT new_post.id, all_tags.id, COALESCE(s.source, 'facet')
+FROM unnest(sqlc.arg('tags')::text[], sqlc.arg('tag_sources')::text[]) AS s(name, source)
+JOIN all_tags ON all_tags.name = s.name
+JOIN new_post ON true
+ON CONFLICT (post_id, tag_id) DO NOTHING;
+
+-- name: CreatePostsWithTags :exec
+-- writes a batch of posts in one round trip, the link_* arrays hold one (post, tag, source) triple per element
+-- and every tag in the batch is upserted once, as in CreatePostWithTags. The mention_* and url_* arrays likewise hold one
+-- (post, mentioned DID) and (post, URL) pair per element. The embed_* arrays hold one element per post
+-- with an embed, empty strings stored as NULL, and the media_* arrays one element per image or video.
+-- Top level posts have empty reply_* elements, stored as NULL.
+-- unnest flattens nested arrays, so each post's languages arrive comma separated.
+WITH new_posts AS (
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    SELECT u.post_id, u.creator_did, u.created_at, u.text,
+           NULLIF(u.reply_root_uri, ''), NULLIF(u.reply_root_cid, ''), NULLIF(u.reply_parent_uri, ''), NULLIF(u.reply_parent_cid, ''),
+           COALESCE(string_to_array(NULLIF(u.langs, ''), ','), '{}')
+    FROM unnest(
+        @post_ids::text[], @creator_dids::text[], @created_ats::timestamp[], @texts::text[],
+        @reply_root_uris::text[], @reply_root_cids::text[], @reply_parent_uris::text[], @reply_parent_cids::text[],
+        @langs::text[]
+    ) AS u(post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    ON CONFLICT (creator_did, post_id) DO NOTHING
+    RETURNING id, post_id, creator_did
+),
+links AS (
+    SELECT *
+    FROM unnest(@link_post_ids::text[], @link_creator_dids::text[], @link_tags::text[], @link_tag_sources::text[]) AS l(post_id, creator_did, tag, source)
+),
+all_tags AS (
+    INSERT INTO tags (name)
+    SELECT DISTINCT tag FROM links
+    ORDER BY tag
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+    RETURNING id, name
+),
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT new_posts.id, m.did
+    FROM unnest(@mention_post_ids::text[], @mention_creator_dids::text[], @mention_dids::text[]) AS m(post_id, creator_did, did)
+    JOIN new_posts ON new_posts.post_id = m.post_id AND new_posts.creator_did = m.creator_did
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT new_posts.id, u.url, u.domain
+    FROM unnest(@url_post_ids::text[], @url_creator_dids::text[], @urls::text[], @url_domains::text[]) AS u(post_id, creator_did, url, domain)
+    JOIN new_posts ON new_posts.post_id = u.post_id AND new_posts.creator_did = u.creator_did
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+inserted_embeds AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT new_posts.id, e.embed_type, NULLIF(e.external_uri, ''), NULLIF(e.external_title, ''), NULLIF(e.quoted_uri, ''), NULLIF(e.quoted_cid, '')
+    FROM unnest(
+        @embed_post_ids::text[], @embed_creator_dids::text[], @embed_types::text[],
+        @external_uris::text[], @external_titles::text[], @quoted_uris::text[], @quoted_cids::text[]
+    ) AS e(post_id, creator_did, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    JOIN new_posts ON new_posts.post_id = e.post_id AND new_posts.creator_did = e.creator_did
+    ON CONFLICT (post_id) DO NOTHING
+),
+inserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT new_posts.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM unnest(
+        @media_post_ids::text[], @media_creator_dids::text[], @media_positions::int[],
+        @media_kinds::text[], @media_cids::text[], @media_mime_types::text[], @media_alts::text[]
+    ) AS m(post_id, creator_did, position, kind, blob_cid, mime_type, alt)
+    JOIN new_posts ON new_posts.post_id = m.post_id AND new_posts.creator_did = m.creator_did
+    ON CONFLICT (post_id, position) DO NOTHING
+)
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT new_posts.id, all_tags.id, COALESCE(links.source, 'facet')
+FROM links
+JOIN new_posts ON new_posts.post_id = links.post_id AND new_posts.creator_did = links.creator_did
+JOIN all_tags ON all_tags.name = links.tag
+ON CONFLICT (post_id, tag_id) DO NOTHING;
+
+-- name: DeletePost :exec
+-- removes a post by its natural key, cascading to post_tags. Tags no other post uses are left to DeleteOrphanedTags.
+DELETE FROM posts
+WHERE creator_did = @creator_did AND post_id = @post_id;
+
+-- name: DeleteOrphanedTags :execrows
+-- drops up to row_limit tags no post uses. Run it in a repeatable read transaction: a tag linked to a post
+-- after the transaction started then fails the delete with a serialization error, instead of the delete
+-- cascading to the new post_tags row. Tags locked by a post being written are skipped.
+DELETE FROM tags
+WHERE id IN (
+    SELECT t.id
+    FROM tags t
+    WHERE NOT EXISTS (SELECT 1 FROM post_tags pt WHERE pt.tag_id = t.id)
+    LIMIT @row_limit
+    FOR UPDATE SKIP LOCKED
+);
+
+-- name: GetJetstreamCursor :one
+SELECT time_us FROM jetstream_cursors WHERE name = $1;
+
+-- name: SaveJetstreamCursor :exec
+INSERT INTO jetstream_cursors (name, time_us, updated_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP)
+ON CONFLICT (name) DO UPDATE
+SET time_us = EXCLUDED.time_us,
+    updated_at = EXCLUDED.updated_at;
+
+-- name: UpsertPostWithTags :exec
+-- replaces the text, created_at, languages, tags, mentions, links and embed of a stored post, inserting it if it is not stored yet.
+-- A post's reply references can't change, so they are only written on insert. Tags the edit removes that no other post uses are left to DeleteOrphanedTags.
+-- Tags are upserted as in CreatePostWithTags.
+WITH target_post AS (
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    VALUES (@post_id, @creator_did, @created_at, @text, @reply_root_uri, @reply_root_cid, @reply_parent_uri, @reply_parent_cid, @langs)
+    ON CONFLICT (creator_did, post_id) DO UPDATE
+    SET text = EXCLUDED.text,
+        created_at = EXCLUDED.created_at,
+        langs = EXCLUDED.langs
+    RETURNING id
+),
+all_tags AS (
+    INSERT INTO tags (name)
+    SELECT DISTINCT name FROM unnest(sqlc.arg('tags')::text[]) AS t(name)
+    ORDER BY name
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+    RETURNING id AS tag_id, name
+),
+removed_post_tags AS (
+    DELETE FROM post_tags
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND tag_id NOT IN (SELECT tag_id FROM all_tags)
+),
+removed_mentions AS (
+    DELETE FROM post_mentions
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND did <> ALL(@mentions::text[])
+),
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT target_post.id, m.did
+    FROM target_post, unnest(@mentions::text[]) AS m(did)
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+removed_links AS (
+    DELETE FROM post_links
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND url <> ALL(@link_urls::text[])
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT target_post.id, l.url, l.domain
+    FROM target_post, unnest(@link_urls::text[], @link_domains::text[]) AS l(url, domain)
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+removed_embed AS (
+    DELETE FROM post_embeds
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND sqlc.narg('embed_type')::text IS NULL
+),
+upserted_embed AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT target_post.id, sqlc.narg('embed_type')::text, sqlc.narg('external_uri')::text, sqlc.narg('external_title')::text, sqlc.narg('quoted_uri')::text, sqlc.narg('quoted_cid')::text
+    FROM target_post
+    WHERE sqlc.narg('embed_type')::text IS NOT NULL
+    ON CONFLICT (post_id) DO UPDATE
+    SET embed_type = EXCLUDED.embed_type,
+        external_uri = EXCLUDED.external_uri,
+        external_title = EXCLUDED.external_title,
+        quoted_uri = EXCLUDED.quoted_uri,
+        quoted_cid = EXCLUDED.quoted_cid
+),
+removed_media AS (
+    DELETE FROM post_media
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND position > COALESCE(cardinality(sqlc.arg('media_kinds')::text[]), 0)
+),
+upserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT target_post.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM target_post, unnest(sqlc.arg('media_kinds')::text[], sqlc.arg('media_cids')::text[], sqlc.arg('media_mime_types')::text[], sqlc.arg('media_alts')::text[]) WITH ORDINALITY AS m(kind, blob_cid, mime_type, alt, position)
+    ON CONFLICT (post_id, position) DO UPDATE
+    SET kind = EXCLUDED.kind,
+        blob_cid = EXCLUDED.blob_cid,
+        mime_type = EXCLUDED.mime_type,
+        alt = EXCLUDED.alt
+)
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT target_post.id, all_tags.tag_id, COALESCE(s.source, 'facet')
+FROM unnest(sqlc.arg('tags')::text[], sqlc.arg('tag_sources')::text[]) AS s(name, source)
+JOIN all_tags ON all_tags.name = s.name
+JOIN target_post ON true
+ON CONFLICT (post_id, tag_id) DO UPDATE
+SET source = EXCLUDED.source;
+
+-- name: SaveDeadLetter :exec
+-- records an event that failed processing, counting another attempt if it failed before
+INSERT INTO dead_letters (did, time_us, collection, operation, rkey, error, event)
+VALUES (@did, @time_us, @collection, @operation, @rkey, @error, @event::jsonb)
+ON CONFLICT (did, time_us) DO UPDATE
+SET error = EXCLUDED.error,
+    attempts = dead_letters.attempts + 1,
+    last_failed_at = CURRENT_TIMESTAMP;
+
+-- name: GetDeadLetter :one
+SELECT * FROM dead_letters WHERE id = $1;
+
+-- name: ListDeadLetters :many
+-- oldest first
+SELECT * FROM dead_letters
+ORDER BY id
+LIMIT @row_limit OFFSET @row_offset;
+
+-- name: DeleteDeadLetters :exec
+DELETE FROM dead_letters WHERE id = ANY(@ids::int[]);
+
+-- name: SaveAccountStatus :exec
+-- records an account event's status, unless a later event was recorded already
+INSERT INTO accounts (did, active, status, status_time_us)
+VALUES (@did, @active, @status, @time_us)
+ON CONFLICT (did) DO UPDATE
+SET active = EXCLUDED.active,
+    status = EXCLUDED.status,
+    status_time_us = EXCLUDED.status_time_us,
+    updated_at = CURRENT_TIMESTAMP
+WHERE accounts.status_time_us <= EXCLUDED.status_time_us;
+
+-- name: SaveAccountHandle :exec
+-- records an identity event's handle, NULL when the account has none, unless a later event was recorded already
+INSERT INTO accounts (did, handle, handle_time_us)
+VALUES (@did, sqlc.narg('handle'), @time_us)
+ON CONFLICT (did) DO UPDATE
+SET handle = EXCLUDED.handle,
+    handle_time_us = EXCLUDED.handle_time_us,
+    updated_at = CURRENT_TIMESTAMP
+WHERE accounts.handle_time_us <= EXCLUDED.handle_time_us;
+
+-- name: GetAccount :one
+SELECT * FROM accounts WHERE did = $1;
+
+-- name: GetAccountHandles :many
+-- the cached handles of the DIDs, DIDs that were never seen have no row
+SELECT did, handle, handle_time_us FROM accounts WHERE did = ANY(@dids::text[]);
+
+-- name: PurgeDeletedAccountPosts :one
+-- hard deletes up to row_limit posts of deleted accounts and counts them. Tags no other post uses are left to DeleteOrphanedTags.
+WITH doomed_posts AS (
+    SELECT p.id
+    FROM posts p
+    JOIN accounts a ON a.did = p.creator_did
+    WHERE NOT a.active AND a.status = 'deleted'
+    LIMIT @row_limit
+),
+deleted_posts AS (
+    DELETE FROM posts
+    WHERE id IN (SELECT id FROM doomed_posts)
+    RETURNING id
+)
+SELECT count(*) FROM deleted_posts;
+
+-- name: AddPostInteraction :execrows
+-- counts a like or repost of a stored post, once however often it's seen. A subject that isn't stored counts nothing.
+WITH counted AS (
+    INSERT INTO post_interactions (did, kind, rkey, post_id, created_at)
+    SELECT @did::text, @kind::text, @rkey::text, p.id, @created_at::timestamp
+    FROM posts p
+    WHERE p.creator_did = @subject_did AND p.post_id = @subject_post_id
+    ON CONFLICT (did, kind, rkey) DO NOTHING
+    RETURNING post_id, kind
+)
+INSERT INTO post_engagement (post_id, likes, reposts)
+SELECT post_id, (kind = 'like')::int, (kind = 'repost')::int FROM counted
+ON CONFLICT (post_id) DO UPDATE
+SET likes = post_engagement.likes + EXCLUDED.likes,
+    reposts = post_engagement.reposts + EXCLUDED.reposts;
+
+-- name: RemovePostInteraction :execrows
+-- uncounts a deleted like or repost, one that was never counted changes nothing
+WITH removed AS (
+    DELETE FROM post_interactions
+    WHERE did = @did AND kind = @kind AND rkey = @rkey
+    RETURNING post_id, kind
+)
+UPDATE post_engagement e
+SET likes = e.likes - (r.kind = 'like')::int,
+    reposts = e.reposts - (r.kind = 'repost')::int
+FROM removed r
+WHERE e.post_id = r.post_id;
+
+-- name: CountPosts :one
+SELECT count(*) FROM posts;
+
+-- name: ListPostKeys :many
+-- the natural keys of stored posts in id order, a page after after_id at a time
+SELECT id, creator_did, post_id FROM posts WHERE id > @after_id ORDER BY id LIMIT @row_limit;
+
+-- name: CountPostInteractions :one
+SELECT count(*) FROM post_interactions;
+
+-- name: ListPostInteractionKeys :many
+-- the keys of counted likes and reposts in key order, a page after the given key at a time
+SELECT did, kind, rkey FROM post_interactions
+WHERE (did, kind, rkey) > (@after_did::text, @after_kind::text, @after_rkey::text)
+ORDER BY did, kind, rkey
+LIMIT @row_limit;
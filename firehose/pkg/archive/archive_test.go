@@ -0,0 +1,225 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var samplesDir = filepath.Join("..", "..", "db", "test", "data", "samples")
+
+func loadSampleEvents(t *testing.T, name string) []*models.Event {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join(samplesDir, name))
+	require.NoError(t, err)
+	defer file.Close()
+
+	var events []*models.Event
+	decoder := json.NewDecoder(file)
+	for {
+		var evt models.Event
+		err := decoder.Decode(&evt)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		events = append(events, &evt)
+	}
+	return events
+}
+
+func readAll(t *testing.T, dir string, fromUS int64, toUS int64) []*models.Event {
+	t.Helper()
+	var events []*models.Event
+	require.NoError(t, Read(dir, fromUS, toUS, func(evt *models.Event) error {
+		events = append(events, evt)
+		return nil
+	}))
+	return events
+}
+
+func writeAll(t *testing.T, w *Writer, events []*models.Event) {
+	t.Helper()
+	for _, evt := range events {
+		require.NoError(t, w.Write(evt))
+	}
+}
+
+func TestWriterRotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	w, err := Open(Config{Dir: dir, SegmentSize: 16 * 1024})
+	require.NoError(t, err)
+	writeAll(t, w, events)
+	require.NoError(t, w.Close())
+
+	index, err := LoadIndex(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(index.Segments), 1)
+	assert.Equal(t, w.Index(), *index)
+
+	var total int64
+	for i, segment := range index.Segments {
+		first, last, ok := parseSegmentName(segment.File)
+		require.True(t, ok, segment.File)
+		assert.Equal(t, segment.FirstTimeUS, first)
+		assert.Equal(t, segment.LastTimeUS, last)
+		assert.FileExists(t, filepath.Join(dir, segment.File))
+		assert.Positive(t, segment.Bytes)
+		if i > 0 {
+			assert.GreaterOrEqual(t, segment.FirstTimeUS, index.Segments[i-1].LastTimeUS)
+		}
+		total += segment.Events
+	}
+	assert.Equal(t, int64(len(events)), total)
+	assert.Equal(t, events[0].TimeUS, index.Segments[0].FirstTimeUS)
+	assert.Equal(t, events[len(events)-1].TimeUS, index.Segments[len(index.Segments)-1].LastTimeUS)
+
+	// everything reads back in order and unchanged
+	archived := readAll(t, dir, 0, 0)
+	require.Len(t, archived, len(events))
+	for i := range events {
+		assert.Equal(t, events[i].TimeUS, archived[i].TimeUS)
+		assert.JSONEq(t, string(events[i].Commit.Record), string(archived[i].Commit.Record))
+	}
+}
+
+func TestIndexFind(t *testing.T) {
+	index := &Index{Segments: []Segment{
+		{File: segmentName(100, 200), FirstTimeUS: 100, LastTimeUS: 200},
+		{File: segmentName(300, 400), FirstTimeUS: 300, LastTimeUS: 400},
+	}}
+
+	tests := []struct {
+		name   string
+		timeUS int64
+		want   string
+		found  bool
+	}{
+		{"before the archive", 50, segmentName(100, 200), true},
+		{"inside a segment", 150, segmentName(100, 200), true},
+		{"segment end", 200, segmentName(100, 200), true},
+		{"between segments", 250, segmentName(300, 400), true},
+		{"after the archive", 500, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segment, found := index.Find(tt.timeUS)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.want, segment.File)
+		})
+	}
+
+	assert.Len(t, index.Range(0, 0), 2)
+	assert.Len(t, index.Range(150, 250), 1)
+	assert.Len(t, index.Range(250, 0), 1)
+	assert.Len(t, index.Range(0, 99), 0)
+}
+
+func TestReadTimeRange(t *testing.T) {
+	dir := t.TempDir()
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	w, err := Open(Config{Dir: dir, SegmentSize: 16 * 1024})
+	require.NoError(t, err)
+	writeAll(t, w, events)
+	require.NoError(t, w.Close())
+
+	from, to := events[40].TimeUS, events[90].TimeUS
+	archived := readAll(t, dir, from, to)
+	require.NotEmpty(t, archived)
+	for _, evt := range archived {
+		assert.GreaterOrEqual(t, evt.TimeUS, from)
+		assert.LessOrEqual(t, evt.TimeUS, to)
+	}
+	assert.Equal(t, events[40].TimeUS, archived[0].TimeUS)
+
+	// ErrStop ends the read early without an error
+	read := 0
+	require.NoError(t, Read(dir, 0, 0, func(*models.Event) error {
+		read++
+		if read == 3 {
+			return ErrStop
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, read)
+}
+
+func TestRetentionDeletesExpiredSegments(t *testing.T) {
+	dir := t.TempDir()
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	w, err := Open(Config{Dir: dir, SegmentSize: 16 * 1024, Retention: time.Hour})
+	require.NoError(t, err)
+	// pretend the sample events are recent, then that the first half is over an hour old
+	w.now = func() time.Time { return time.UnixMicro(events[0].TimeUS) }
+	writeAll(t, w, events)
+	expired := w.Index().Segments[0]
+
+	w.now = func() time.Time { return time.UnixMicro(expired.LastTimeUS).Add(time.Hour + time.Microsecond) }
+	require.NoError(t, w.Close())
+
+	index, err := LoadIndex(dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, index.Segments)
+	assert.NotEqual(t, expired.File, index.Segments[0].File)
+	assert.NoFileExists(t, filepath.Join(dir, expired.File))
+	for _, segment := range index.Segments {
+		assert.Greater(t, segment.LastTimeUS, expired.LastTimeUS)
+	}
+}
+
+func TestOpenRecoversSegmentLeftOpen(t *testing.T) {
+	dir := t.TempDir()
+	events := loadSampleEvents(t, "jetstream-samples-with-tags-tiny.json")
+
+	w, err := Open(Config{Dir: dir})
+	require.NoError(t, err)
+	writeAll(t, w, events)
+	require.NoError(t, w.Flush())
+	// a crash: the file is never finished or renamed
+	openPath := w.file.Name()
+
+	w, err = Open(Config{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.NoFileExists(t, openPath)
+	index := w.Index()
+	require.Len(t, index.Segments, 1)
+	assert.Equal(t, int64(len(events)), index.Segments[0].Events)
+	assert.Len(t, readAll(t, dir, 0, 0), len(events))
+}
+
+func TestLoadIndexWithoutIndexFile(t *testing.T) {
+	dir := t.TempDir()
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	w, err := Open(Config{Dir: dir, SegmentSize: 16 * 1024})
+	require.NoError(t, err)
+	writeAll(t, w, events)
+	require.NoError(t, w.Close())
+	written := w.Index()
+
+	require.NoError(t, os.Remove(filepath.Join(dir, IndexFile)))
+	index, err := LoadIndex(dir)
+	require.NoError(t, err)
+	require.Len(t, index.Segments, len(written.Segments))
+	for i, segment := range index.Segments {
+		assert.Equal(t, written.Segments[i].File, segment.File)
+		assert.Equal(t, written.Segments[i].FirstTimeUS, segment.FirstTimeUS)
+		assert.Equal(t, written.Segments[i].LastTimeUS, segment.LastTimeUS)
+	}
+	assert.Len(t, readAll(t, dir, 0, 0), len(events))
+}
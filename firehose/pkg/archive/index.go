@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IndexFile lists the archive's segments, it's rewritten whenever a segment is finished or deleted
+const IndexFile = "index.json"
+
+const (
+	segmentPrefix = "events-"
+	segmentExt    = ".jsonl.zst"
+	// extension of the segment being written, it's renamed once its time range is known
+	openExt = ".open"
+)
+
+// Segment is a finished archive file holding the events between FirstTimeUS and LastTimeUS
+type Segment struct {
+	File        string `json:"file"`
+	FirstTimeUS int64  `json:"first_time_us"`
+	LastTimeUS  int64  `json:"last_time_us"`
+	Events      int64  `json:"events"`
+	// compressed size on disk
+	Bytes int64 `json:"bytes"`
+}
+
+// Index lists an archive's finished segments in time order
+type Index struct {
+	Segments []Segment `json:"segments"`
+}
+
+// segmentName names a segment by the time_us range it covers, zero padded so names sort in time order
+func segmentName(firstTimeUS, lastTimeUS int64) string {
+	return fmt.Sprintf("%s%016d-%016d%s", segmentPrefix, firstTimeUS, lastTimeUS, segmentExt)
+}
+
+// parseSegmentName reads the time_us range back out of a segment's file name
+func parseSegmentName(name string) (int64, int64, bool) {
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExt) {
+		return 0, 0, false
+	}
+	var first, last int64
+	_, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExt), "%d-%d", &first, &last)
+	if err != nil {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// LoadIndex reads an archive's index. An archive without one, say because it was copied
+// without it, is indexed from its segment file names.
+func LoadIndex(dir string) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return RebuildIndex(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive index: %w", err)
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid archive index %s: %w", filepath.Join(dir, IndexFile), err)
+	}
+	index.sort()
+	return &index, nil
+}
+
+// RebuildIndex indexes the segments found in dir by their file names.
+// Event counts aren't in the names, so they're left at 0.
+func RebuildIndex(dir string) (*Index, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	index := &Index{}
+	for _, entry := range entries {
+		first, last, ok := parseSegmentName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat archive segment: %w", err)
+		}
+		index.Segments = append(index.Segments, Segment{
+			File:        entry.Name(),
+			FirstTimeUS: first,
+			LastTimeUS:  last,
+			Bytes:       info.Size(),
+		})
+	}
+	index.sort()
+	return index, nil
+}
+
+// save atomically replaces the index file in dir
+func (idx *Index) save(dir string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, IndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, IndexFile)); err != nil {
+		return fmt.Errorf("failed to replace archive index: %w", err)
+	}
+	return nil
+}
+
+func (idx *Index) sort() {
+	sort.SliceStable(idx.Segments, func(i, j int) bool {
+		return idx.Segments[i].FirstTimeUS < idx.Segments[j].FirstTimeUS
+	})
+}
+
+// Find returns the segment to start reading from for events at or after timeUS:
+// the one covering it, or the next one when timeUS falls between segments
+func (idx *Index) Find(timeUS int64) (Segment, bool) {
+	for _, segment := range idx.Segments {
+		if segment.LastTimeUS >= timeUS {
+			return segment, true
+		}
+	}
+	return Segment{}, false
+}
+
+// Range returns the segments holding events between fromUS and toUS inclusive, 0 leaves either end open
+func (idx *Index) Range(fromUS int64, toUS int64) []Segment {
+	var segments []Segment
+	for _, segment := range idx.Segments {
+		if fromUS > 0 && segment.LastTimeUS < fromUS {
+			continue
+		}
+		if toUS > 0 && segment.FirstTimeUS > toUS {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxLineSize bounds a single archived event, records are limited well below this by the PDS
+const maxLineSize = 4 * 1024 * 1024
+
+// ErrStop can be returned by a read callback to stop reading without an error
+var ErrStop = errors.New("stop reading archive")
+
+// ReadSegment calls fn with each event of the segment at path, in the order they were archived
+func ReadSegment(path string, fn func(*models.Event) error) error {
+	_, err := readSegment(path, fn)
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+// Read calls fn with each archived event in dir between fromUS and toUS inclusive, 0 leaves either end open
+func Read(dir string, fromUS int64, toUS int64, fn func(*models.Event) error) error {
+	index, err := LoadIndex(dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range index.Range(fromUS, toUS) {
+		_, err := readSegment(filepath.Join(dir, segment.File), func(evt *models.Event) error {
+			if (fromUS > 0 && evt.TimeUS < fromUS) || (toUS > 0 && evt.TimeUS > toUS) {
+				return nil
+			}
+			return fn(evt)
+		})
+		if errors.Is(err, ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment decodes a segment, returning how many events reached fn before any error
+func readSegment(path string, fn func(*models.Event) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive segment: %w", err)
+	}
+	defer f.Close()
+
+	decoder, err := zstd.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive segment %s: %w", path, err)
+	}
+	defer decoder.Close()
+
+	var read int64
+	scanner := bufio.NewScanner(decoder)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var evt models.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			return read, fmt.Errorf("invalid event %d in archive segment %s: %w", read+1, path, err)
+		}
+		if err := fn(&evt); err != nil {
+			return read, err
+		}
+		read++
+	}
+	if err := scanner.Err(); err != nil {
+		return read, fmt.Errorf("failed to read archive segment %s: %w", path, err)
+	}
+	return read, nil
+}
@@ -0,0 +1,274 @@
+// Package archive keeps raw Jetstream events in local zstd compressed JSONL segments,
+// so history outlasts the few hours Jetstream itself can replay
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultSegmentSize is the uncompressed size at which a segment is rotated
+const DefaultSegmentSize = 256 * 1024 * 1024
+
+// Config configures an archive Writer
+type Config struct {
+	// Directory the segments and their index are written to
+	Dir string
+	// Uncompressed bytes of JSONL after which a segment is finished and a new one started
+	SegmentSize int64
+	// Segments whose newest event is older than this are deleted, 0 keeps them forever
+	Retention time.Duration
+}
+
+// Writer appends events to the archive's open segment, rotating it once it reaches the segment size.
+// It's safe for concurrent use.
+type Writer struct {
+	config Config
+	// now is swapped out by tests of retention
+	now func() time.Time
+
+	mu    sync.Mutex
+	index *Index
+	// the open segment, nil until the first event after a rotation
+	file    *os.File
+	encoder *zstd.Encoder
+	current Segment
+	written int64
+}
+
+// Open opens the archive in cfg.Dir, creating it if needed. A segment left open by a crash
+// is finished with the events that can still be read from it.
+func Open(cfg Config) (*Writer, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("archive directory is required")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	index, err := LoadIndex(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{config: cfg, now: time.Now, index: index}
+
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	if err := w.prune(); err != nil {
+		return nil, err
+	}
+	if err := w.index.save(cfg.Dir); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends an event to the archive
+func (w *Writer) Write(evt *models.Event) error {
+	line, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.start(w.openPath(evt.TimeUS), evt.TimeUS); err != nil {
+			return err
+		}
+	}
+	return w.write(line, evt.TimeUS)
+}
+
+// write appends an encoded event to the open segment, finishing it once it's full
+func (w *Writer) write(line []byte, timeUS int64) error {
+	if _, err := w.encoder.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to archive segment: %w", err)
+	}
+	w.written += int64(len(line)) + 1
+	w.current.Events++
+	// with several workers events can land slightly out of order, so the range is the min and max seen
+	if timeUS < w.current.FirstTimeUS {
+		w.current.FirstTimeUS = timeUS
+	}
+	if timeUS > w.current.LastTimeUS {
+		w.current.LastTimeUS = timeUS
+	}
+
+	if w.written >= w.config.SegmentSize {
+		return w.finish()
+	}
+	return nil
+}
+
+// Flush writes out the events buffered by the compressor, so they survive a crash
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.encoder == nil {
+		return nil
+	}
+	if err := w.encoder.Flush(); err != nil {
+		return fmt.Errorf("failed to flush archive segment: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the open segment
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.finish()
+}
+
+// Index returns a copy of the archive's index
+func (w *Writer) Index() Index {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Index{Segments: append([]Segment(nil), w.index.Segments...)}
+}
+
+// openPath is where a segment beginning at timeUS is written until it's finished
+func (w *Writer) openPath(timeUS int64) string {
+	return filepath.Join(w.config.Dir, fmt.Sprintf("%s%016d%s", segmentPrefix, timeUS, openExt))
+}
+
+// start opens a new segment at path, beginning at timeUS
+func (w *Writer) start(path string, timeUS int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create archive segment: %w", err)
+	}
+	encoder, err := zstd.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to create archive compressor: %w", err)
+	}
+
+	w.file = file
+	w.encoder = encoder
+	w.current = Segment{FirstTimeUS: timeUS, LastTimeUS: timeUS}
+	w.written = 0
+	return nil
+}
+
+// finish closes the open segment, names it by its time range and adds it to the index
+func (w *Writer) finish() error {
+	if w.file == nil {
+		return nil
+	}
+	file, encoder, segment := w.file, w.encoder, w.current
+	w.file, w.encoder = nil, nil
+
+	openPath := file.Name()
+	if err := encoder.Close(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to finish archive segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close archive segment: %w", err)
+	}
+	return w.add(openPath, segment)
+}
+
+// add renames a finished segment file to its final name and indexes it
+func (w *Writer) add(path string, segment Segment) error {
+	segment.File = segmentName(segment.FirstTimeUS, segment.LastTimeUS)
+	finalPath := filepath.Join(w.config.Dir, segment.File)
+	if err := os.Rename(path, finalPath); err != nil {
+		return fmt.Errorf("failed to rename archive segment: %w", err)
+	}
+	info, err := os.Stat(finalPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat archive segment: %w", err)
+	}
+	segment.Bytes = info.Size()
+
+	w.index.Segments = append(w.index.Segments, segment)
+	w.index.sort()
+	if err := w.prune(); err != nil {
+		return err
+	}
+	return w.index.save(w.config.Dir)
+}
+
+// prune deletes the segments that have aged out of the retention period
+func (w *Writer) prune() error {
+	if w.config.Retention <= 0 {
+		return nil
+	}
+	cutoff := w.now().Add(-w.config.Retention).UnixMicro()
+
+	kept := w.index.Segments[:0]
+	for _, segment := range w.index.Segments {
+		if segment.LastTimeUS >= cutoff {
+			kept = append(kept, segment)
+			continue
+		}
+		err := os.Remove(filepath.Join(w.config.Dir, segment.File))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete expired archive segment: %w", err)
+		}
+	}
+	w.index.Segments = kept
+	return nil
+}
+
+// recover finishes the segments a crashed writer left open. Their compressed stream is cut off,
+// so the readable events are copied into a complete segment and the rest is dropped.
+func (w *Writer) recover() error {
+	entries, err := os.ReadDir(w.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), openExt) {
+			continue
+		}
+		path := filepath.Join(w.config.Dir, entry.Name())
+
+		var copyErr error
+		// events are copied until the stream breaks off, whatever read error that gives is expected
+		readSegment(path, func(evt *models.Event) error {
+			line, err := json.Marshal(evt)
+			if err != nil {
+				copyErr = fmt.Errorf("failed to encode event: %w", err)
+				return copyErr
+			}
+			if w.file == nil {
+				// a fresh name, the open segment being read may share the start time
+				if copyErr = w.start(path+".recovered", evt.TimeUS); copyErr != nil {
+					return copyErr
+				}
+			}
+			copyErr = w.write(line, evt.TimeUS)
+			return copyErr
+		})
+		if copyErr != nil {
+			return copyErr
+		}
+		if err := w.finish(); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to delete recovered archive segment: %w", err)
+		}
+	}
+	return nil
+}
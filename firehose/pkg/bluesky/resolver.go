@@ -0,0 +1,255 @@
+package bluesky
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"firehose/pkg/db/query"
+)
+
+const (
+	DefaultPLCURL      = "https://plc.directory"
+	DefaultHandleTTL   = 24 * time.Hour
+	defaultHTTPTimeout = 10 * time.Second
+	// DID documents are a few KB, anything much bigger isn't one
+	maxDIDDocumentSize = 1 << 20
+	// DID documents fetched at once when resolving many DIDs
+	resolveConcurrency = 8
+)
+
+// ResolverConfig says where DID documents are fetched from and how long resolved handles are trusted
+type ResolverConfig struct {
+	// PLC directory did:plc documents are fetched from, DefaultPLCURL when empty
+	PLCURL string
+	// Base URL did:web documents are fetched from, sent with the DID's domain as the Host header.
+	// Empty fetches them from the domain itself over https.
+	WebBaseURL string
+	// How long a cached handle is used before it's resolved again, DefaultHandleTTL when 0
+	TTL time.Duration
+	// Client for fetching DID documents, one with a 10s timeout when nil
+	HTTPClient *http.Client
+	// Where failed resolutions are logged, slog.Default() when nil
+	Logger *slog.Logger
+}
+
+// Resolver resolves DIDs to handles from their DID documents, caching them in the accounts table.
+// Guzzle writes the handles of identity events to the same cache, so most DIDs that post are found there.
+// The handle is the one the DID document claims, it isn't checked against the handle's own DNS or
+// well-known record.
+type Resolver struct {
+	queries *query.Queries
+	config  ResolverConfig
+	now     func() time.Time
+}
+
+// NewResolver builds a resolver caching handles through queries
+func NewResolver(queries *query.Queries, cfg ResolverConfig) *Resolver {
+	if cfg.PLCURL == "" {
+		cfg.PLCURL = DefaultPLCURL
+	}
+	cfg.PLCURL = strings.TrimSuffix(cfg.PLCURL, "/")
+	cfg.WebBaseURL = strings.TrimSuffix(cfg.WebBaseURL, "/")
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultHandleTTL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Resolver{queries: queries, config: cfg, now: time.Now}
+}
+
+// Resolve returns the DID's handle, "" if it has none
+func (r *Resolver) Resolve(ctx context.Context, did string) (string, error) {
+	handles, err := r.resolve(ctx, []string{did})
+	if err != nil {
+		return "", err
+	}
+	return handles[did], nil
+}
+
+// ResolveAll returns the handles of the DIDs it could resolve. DIDs without a handle, or whose
+// resolution failed, are left out, the failures are logged rather than failing the lot.
+func (r *Resolver) ResolveAll(ctx context.Context, dids []string) map[string]string {
+	handles, err := r.resolve(ctx, dids)
+	if err != nil {
+		r.config.Logger.Warn("Failed to resolve handles", "dids", len(dids), "error", err)
+	}
+	return handles
+}
+
+// resolve reads the handles from the cache, fetching the DID documents of the missing and expired ones.
+// It returns every handle it found along with the first error.
+func (r *Resolver) resolve(ctx context.Context, dids []string) (map[string]string, error) {
+	dids = uniqueDIDs(dids)
+	handles := make(map[string]string, len(dids))
+	if len(dids) == 0 {
+		return handles, nil
+	}
+
+	cached, err := r.queries.GetAccountHandles(ctx, dids)
+	if err != nil {
+		return handles, fmt.Errorf("failed to read cached handles: %w", err)
+	}
+	fresh := make(map[string]bool, len(cached))
+	for _, row := range cached {
+		if row.HandleTimeUs == 0 || r.now().Sub(time.UnixMicro(row.HandleTimeUs)) >= r.config.TTL {
+			continue
+		}
+		fresh[row.Did] = true
+		if row.Handle.Valid {
+			handles[row.Did] = row.Handle.String
+		}
+	}
+
+	var stale []string
+	for _, did := range dids {
+		if !fresh[did] {
+			stale = append(stale, did)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	slots := make(chan struct{}, resolveConcurrency)
+	for _, did := range stale {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			handle, err := r.refresh(ctx, did)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				r.config.Logger.Debug("Failed to resolve handle", "did", did, "error", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if handle != "" {
+				handles[did] = handle
+			}
+		}()
+	}
+	wg.Wait()
+	return handles, firstErr
+}
+
+// refresh fetches the DID's handle and caches it, a DID without a handle is cached as such
+func (r *Resolver) refresh(ctx context.Context, did string) (string, error) {
+	handle, err := r.fetchHandle(ctx, did)
+	if err != nil {
+		return "", err
+	}
+	// stamped like an identity event seen now, so older events replayed later don't overwrite it
+	err = r.queries.SaveAccountHandle(ctx, query.SaveAccountHandleParams{
+		Did:    did,
+		Handle: sql.NullString{String: handle, Valid: handle != ""},
+		TimeUs: r.now().UnixMicro(),
+	})
+	if err != nil {
+		return handle, fmt.Errorf("failed to cache handle of %s: %w", did, err)
+	}
+	return handle, nil
+}
+
+// didDocument holds the part of a DID document that names the handle
+type didDocument struct {
+	ID          string   `json:"id"`
+	AlsoKnownAs []string `json:"alsoKnownAs"`
+}
+
+// fetchHandle reads the handle from the DID's document, "" if the document names none or the DID doesn't exist
+func (r *Resolver) fetchHandle(ctx context.Context, did string) (string, error) {
+	req, err := r.documentRequest(ctx, did)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch DID document of %s: %w", did, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// unknown or tombstoned, there's no handle to find
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("failed to fetch DID document of %s: %s", did, resp.Status)
+	}
+
+	var doc didDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDIDDocumentSize)).Decode(&doc); err != nil {
+		return "", fmt.Errorf("invalid DID document of %s: %w", did, err)
+	}
+	if doc.ID != did {
+		return "", fmt.Errorf("DID document of %s is for %q", did, doc.ID)
+	}
+	for _, aka := range doc.AlsoKnownAs {
+		if handle, ok := strings.CutPrefix(aka, "at://"); ok && handle != "" {
+			return strings.ToLower(handle), nil
+		}
+	}
+	return "", nil
+}
+
+// documentRequest builds the request for a DID's document: the PLC directory for did:plc,
+// and the DID's domain for did:web, did:web:example.com:user:alice living at example.com/user/alice/did.json
+func (r *Resolver) documentRequest(ctx context.Context, did string) (*http.Request, error) {
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		return http.NewRequestWithContext(ctx, http.MethodGet, r.config.PLCURL+"/"+did, nil)
+	case strings.HasPrefix(did, "did:web:"):
+		parts := strings.Split(strings.TrimPrefix(did, "did:web:"), ":")
+		host, err := url.PathUnescape(parts[0])
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid did:web %q", did)
+		}
+		path := "/.well-known/did.json"
+		if len(parts) > 1 {
+			path = "/" + strings.Join(parts[1:], "/") + "/did.json"
+		}
+
+		base := "https://" + host
+		if r.config.WebBaseURL != "" {
+			base = r.config.WebBaseURL
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Host = host
+		return req, nil
+	}
+	return nil, fmt.Errorf("unsupported DID method: %q", did)
+}
+
+func uniqueDIDs(dids []string) []string {
+	seen := make(map[string]bool, len(dids))
+	unique := make([]string, 0, len(dids))
+	for _, did := range dids {
+		if did != "" && !seen[did] {
+			seen[did] = true
+			unique = append(unique, did)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,176 @@
+// Package config loads the settings shared by the firehose commands.
+//
+// Settings are layered, each layer overriding the ones before it:
+//
+//  1. the command's flag defaults
+//  2. a YAML file, named by -config or FIREHOSE_CONFIG
+//  3. environment variables, with a .env file filling in any that aren't set
+//  4. flags given on the command line
+//
+// The file's database section and the DATABASE_URL and DB_* variables configure Postgres.
+// Every other top level section is named after a command and sets that command's flags,
+// as do variables named after the command and flag, e.g. GUZZLE_BATCH_SIZE for guzzle -batch-size.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileEnv names the YAML file when -config isn't given
+	FileEnv  = "FIREHOSE_CONFIG"
+	fileFlag = "config"
+)
+
+// Config holds the settings shared by every command, command specific ones are set on its flags
+type Config struct {
+	Database Database `yaml:"database"`
+}
+
+// RegisterFlags adds -config and the database flags to a command's flag set
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.String(fileFlag, "", "YAML config file, "+FileEnv+" by default")
+	for _, setting := range databaseSettings {
+		if setting.flag != "" {
+			fs.String(setting.flag, "", setting.usage+", "+setting.env+" by default")
+		}
+	}
+}
+
+// Load layers the config file, environment and command line for a command, whose flags must already be parsed.
+// The command's own flags are updated in place. Database settings are only checked when they're used,
+// as some commands can run without a database.
+func Load(command string, fs *flag.FlagSet) (*Config, error) {
+	// the .env file only fills in variables that aren't already set
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env file: %w", err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	owned := map[string]bool{fileFlag: true}
+	for _, setting := range databaseSettings {
+		if setting.flag != "" {
+			owned[setting.flag] = true
+		}
+	}
+
+	cfg := &Config{}
+	path := os.Getenv(FileEnv)
+	if f := fs.Lookup(fileFlag); f != nil && f.Value.String() != "" {
+		path = f.Value.String()
+	}
+	if path != "" {
+		if err := loadFile(path, command, cfg, fs, owned, explicit); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, setting := range databaseSettings {
+		if value := os.Getenv(setting.env); value != "" {
+			if err := setting.set(&cfg.Database, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", setting.env, err))
+			}
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if owned[f.Name] || explicit[f.Name] {
+			return
+		}
+		name := EnvName(command, f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	})
+
+	for _, setting := range databaseSettings {
+		if setting.flag != "" && explicit[setting.flag] {
+			if err := setting.set(&cfg.Database, fs.Lookup(setting.flag).Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", setting.flag, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// EnvName is the environment variable that sets a command's flag, e.g. GUZZLE_BATCH_SIZE for guzzle -batch-size
+func EnvName(command, flagName string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(command + "_" + flagName))
+}
+
+// loadFile reads the database section and the command's section of a config file.
+// Sections for other commands are skipped, so one file can serve them all.
+func loadFile(path, command string, cfg *Config, fs *flag.FlagSet, owned, explicit map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var sections map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if node, ok := sections["database"]; ok {
+		if err := decodeStrict(&node, &cfg.Database); err != nil {
+			return fmt.Errorf("invalid database section in %s: %w", path, err)
+		}
+	}
+
+	node, ok := sections[command]
+	if !ok {
+		return nil
+	}
+	var settings map[string]yaml.Node
+	if err := node.Decode(&settings); err != nil {
+		return fmt.Errorf("invalid %s section in %s: %w", command, path, err)
+	}
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		value := settings[name]
+		switch {
+		case name == fileFlag || fs.Lookup(name) == nil:
+			errs = append(errs, fmt.Errorf("%s: unknown %s setting %q", path, command, name))
+			continue
+		case owned[name]:
+			errs = append(errs, fmt.Errorf("%s: %s.%s belongs in the database section", path, command, name))
+			continue
+		case value.Kind != yaml.ScalarNode:
+			errs = append(errs, fmt.Errorf("%s: %s.%s must be a single value", path, command, name))
+			continue
+		case explicit[name]:
+			continue
+		}
+		if err := fs.Set(name, value.Value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s.%s: %w", path, command, name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeStrict decodes a node, failing on keys out doesn't have so typos don't go unnoticed
+func decodeStrict(node *yaml.Node, out any) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(out)
+}
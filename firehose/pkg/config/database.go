@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sslModes are the sslmode values lib/pq understands
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Database says how to reach Postgres, either as a URL or as its parts
+type Database struct {
+	// postgres:// URL, when set the parts below only fill in what it leaves out
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// file holding the password, e.g. a mounted secret, instead of Password
+	PasswordFile string `yaml:"password_file"`
+	Name         string `yaml:"name"`
+
+	// disable, require, verify-ca or verify-full. Empty keeps what URL says, or disable without one
+	SSLMode     string `yaml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert"`
+	SSLKey      string `yaml:"sslkey"`
+}
+
+// databaseSettings are the environment variables, and flags where it's safe to take one, that fill in each setting.
+// There's no password flag as it would show up in the process list.
+var databaseSettings = []struct {
+	env   string
+	flag  string
+	usage string
+	set   func(d *Database, value string) error
+}{
+	{"DATABASE_URL", "database-url", "Postgres connection URL, the db-* settings fill in what it leaves out", func(d *Database, v string) error { d.URL = v; return nil }},
+	{"DB_HOST", "db-host", "Postgres host", func(d *Database, v string) error { d.Host = v; return nil }},
+	{"DB_PORT", "db-port", "Postgres port, 5432 by default", func(d *Database, v string) error { return setPort(d, v) }},
+	{"DB_USER", "db-user", "Postgres user", func(d *Database, v string) error { d.User = v; return nil }},
+	{"DB_PASSWORD", "", "", func(d *Database, v string) error { d.Password = v; return nil }},
+	{"DB_PASSWORD_FILE", "db-password-file", "File holding the Postgres password", func(d *Database, v string) error { d.PasswordFile = v; return nil }},
+	{"DB_NAME", "db-name", "Postgres database name", func(d *Database, v string) error { d.Name = v; return nil }},
+	{"DB_SSLMODE", "db-sslmode", "Postgres sslmode: disable, require, verify-ca or verify-full", func(d *Database, v string) error { d.SSLMode = v; return nil }},
+	{"DB_SSLROOTCERT", "db-sslrootcert", "CA certificate the Postgres server certificate is verified against", func(d *Database, v string) error { d.SSLRootCert = v; return nil }},
+	{"DB_SSLCERT", "db-sslcert", "Client certificate for Postgres", func(d *Database, v string) error { d.SSLCert = v; return nil }},
+	{"DB_SSLKEY", "db-sslkey", "Client certificate key for Postgres", func(d *Database, v string) error { d.SSLKey = v; return nil }},
+}
+
+func setPort(d *Database, value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid database port %q", value)
+	}
+	d.Port = port
+	return nil
+}
+
+// Validate reports every setting that is missing or wrong, so they can all be fixed at once
+func (d Database) Validate() error {
+	var errs []error
+
+	if d.URL != "" {
+		u, err := url.Parse(d.URL)
+		if err != nil {
+			errs = append(errs, errors.New("invalid database url"))
+		} else if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			errs = append(errs, fmt.Errorf("database url must start with postgres://, not %s://", u.Scheme))
+		}
+	} else {
+		var missing []string
+		for _, field := range []struct{ name, value string }{
+			{"host", d.Host},
+			{"user", d.User},
+			{"name", d.Name},
+		} {
+			if field.value == "" {
+				missing = append(missing, field.name)
+			}
+		}
+		if d.Password == "" && d.PasswordFile == "" {
+			missing = append(missing, "password or password_file")
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("missing database settings: %s, or set a database url", strings.Join(missing, ", ")))
+		}
+	}
+
+	if d.Port < 0 || d.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid database port %d", d.Port))
+	}
+	if d.Password != "" && d.PasswordFile != "" {
+		errs = append(errs, errors.New("set only one of the database password and password_file"))
+	}
+	if d.PasswordFile != "" {
+		if _, err := d.password(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.SSLMode != "" && !slices.Contains(sslModes, d.SSLMode) {
+		errs = append(errs, fmt.Errorf("invalid database sslmode %q, want one of %s", d.SSLMode, strings.Join(sslModes, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
+// ConnString builds the connection URL for the configured database
+func (d Database) ConnString() (string, error) {
+	return d.connString("")
+}
+
+// AdminConnString builds a connection URL for the postgres maintenance database on the same server,
+// used to create the configured database
+func (d Database) AdminConnString() (string, error) {
+	return d.connString("postgres")
+}
+
+// DatabaseName is the name of the database being connected to
+func (d Database) DatabaseName() string {
+	if d.URL != "" {
+		if u, err := url.Parse(d.URL); err == nil && strings.Trim(u.Path, "/") != "" {
+			return strings.Trim(u.Path, "/")
+		}
+	}
+	return d.Name
+}
+
+func (d Database) connString(dbName string) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+	password, err := d.password()
+	if err != nil {
+		return "", err
+	}
+
+	u := &url.URL{Scheme: "postgres"}
+	if d.URL != "" {
+		if u, err = url.Parse(d.URL); err != nil {
+			return "", errors.New("invalid database url")
+		}
+	}
+
+	// the parts fill in whatever the url leaves out
+	if u.Host == "" && d.Host != "" {
+		port := d.Port
+		if port == 0 {
+			port = 5432
+		}
+		u.Host = net.JoinHostPort(d.Host, strconv.Itoa(port))
+	}
+	user, pass := d.User, password
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			pass = p
+		}
+	}
+	if pass != "" {
+		u.User = url.UserPassword(user, pass)
+	} else if user != "" {
+		u.User = url.User(user)
+	}
+	if dbName == "" {
+		dbName = d.DatabaseName()
+	}
+	u.Path = "/" + dbName
+
+	query := u.Query()
+	sslMode := d.SSLMode
+	if sslMode == "" && d.URL == "" {
+		sslMode = "disable"
+	}
+	for _, param := range []struct{ key, value string }{
+		{"sslmode", sslMode},
+		{"sslrootcert", d.SSLRootCert},
+		{"sslcert", d.SSLCert},
+		{"sslkey", d.SSLKey},
+	} {
+		if param.value != "" && !query.Has(param.key) {
+			query.Set(param.key, param.value)
+		}
+	}
+	if d.URL == "" {
+		query.Set("search_path", "public")
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// password reads PasswordFile when it's set, dropping the trailing newline most secret files have
+func (d Database) password() (string, error) {
+	if d.PasswordFile == "" {
+		return d.Password, nil
+	}
+	data, err := os.ReadFile(d.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read database password file: %w", err)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("database password file %s is empty", d.PasswordFile)
+	}
+	return password, nil
+}
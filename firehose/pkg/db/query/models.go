@@ -5,21 +5,111 @@
 package query
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 )
 
-type Post struct {
+type Account struct {
+	Did string
+	// false once the account is deactivated, taken down, suspended or deleted, its posts are hidden
+	Active bool
+	// why the account is inactive, e.g. takendown or deleted, empty while it's active
+	Status string
+	Handle sql.NullString
+	// time_us of the events that set the status and the handle, so older events replayed later don't undo them
+	StatusTimeUs int64
+	HandleTimeUs int64
+	UpdatedAt    time.Time
+}
+
+type DeadLetter struct {
 	ID         int32
-	PostID     string
-	CreatorDid string
-	CreatedAt  time.Time
-	Text       string
+	Did        string
+	TimeUs     int64
+	Collection string
+	Operation  string
+	Rkey       string
+	// the error of the latest attempt
+	Error    string
+	Attempts int32
+	// the jetstream event as received
+	Event         json.RawMessage
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+}
+
+type JetstreamCursor struct {
+	Name      string
+	TimeUs    int64
+	UpdatedAt time.Time
+}
+
+type Post struct {
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+}
+
+type PostEmbed struct {
+	PostID int32
+	// the $type of the embed, e.g. app.bsky.embed.recordWithMedia
+	EmbedType     string
+	ExternalUri   sql.NullString
+	ExternalTitle sql.NullString
+	QuotedUri     sql.NullString
+	QuotedCid     sql.NullString
+}
+
+type PostEngagement struct {
+	PostID  int32
+	Likes   int32
+	Reposts int32
+}
+
+type PostInteraction struct {
+	Did string
+	// like or repost
+	Kind      string
+	Rkey      string
+	PostID    int32
+	CreatedAt time.Time
+}
+
+type PostLink struct {
+	PostID int32
+	Url    string
+	// lowercased host without a leading www.
+	Domain string
+}
+
+type PostMedium struct {
+	PostID int32
+	// 1-based order of the image or video in the post
+	Position int32
+	Kind     string
+	BlobCid  string
+	MimeType string
+	Alt      string
+}
+
+type PostMention struct {
+	PostID int32
+	Did    string
 }
 
 type PostTag struct {
 	PostID    int32
 	TagID     int32
 	CreatedAt time.Time
+	Source    string
 }
 
 type Tag struct {
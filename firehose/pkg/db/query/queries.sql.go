@@ -7,60 +7,497 @@ package query
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+const addPostInteraction = `-- name: AddPostInteraction :execrows
+WITH counted AS (
+    INSERT INTO post_interactions (did, kind, rkey, post_id, created_at)
+    SELECT $1::text, $2::text, $3::text, p.id, $4::timestamp
+    FROM posts p
+    WHERE p.creator_did = $5 AND p.post_id = $6
+    ON CONFLICT (did, kind, rkey) DO NOTHING
+    RETURNING post_id, kind
+)
+INSERT INTO post_engagement (post_id, likes, reposts)
+SELECT post_id, (kind = 'like')::int, (kind = 'repost')::int FROM counted
+ON CONFLICT (post_id) DO UPDATE
+SET likes = post_engagement.likes + EXCLUDED.likes,
+    reposts = post_engagement.reposts + EXCLUDED.reposts
+`
+
+type AddPostInteractionParams struct {
+	Did           string
+	Kind          string
+	Rkey          string
+	CreatedAt     time.Time
+	SubjectDid    string
+	SubjectPostID string
+}
+
+// counts a like or repost of a stored post, once however often it's seen. A subject that isn't stored counts nothing.
+func (q *Queries) AddPostInteraction(ctx context.Context, arg AddPostInteractionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addPostInteraction,
+		arg.Did,
+		arg.Kind,
+		arg.Rkey,
+		arg.CreatedAt,
+		arg.SubjectDid,
+		arg.SubjectPostID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countPostInteractions = `-- name: CountPostInteractions :one
+SELECT count(*) FROM post_interactions
+`
+
+func (q *Queries) CountPostInteractions(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPostInteractions)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPosts = `-- name: CountPosts :one
+SELECT count(*) FROM posts
+`
+
+func (q *Queries) CountPosts(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPosts)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createPostWithTags = `-- name: CreatePostWithTags :exec
 WITH new_post AS (
-    INSERT INTO posts (post_id, creator_did, created_at, text)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    ON CONFLICT (creator_did, post_id) DO NOTHING
     RETURNING id
 ),
-inserted_tags AS (
+all_tags AS (
     INSERT INTO tags (name)
-    SELECT unnest($5::text[])
-    ON CONFLICT (name) DO NOTHING
+    SELECT DISTINCT name FROM unnest($10::text[]) AS t(name)
+    ORDER BY name
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
     RETURNING id, name
 ),
-existing_tags AS (
-    SELECT id, name
-    FROM tags
-    WHERE name = ANY($5::text[])
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT new_post.id, m.did
+    FROM new_post, unnest($11::text[]) AS m(did)
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT new_post.id, l.url, l.domain
+    FROM new_post, unnest($12::text[], $13::text[]) AS l(url, domain)
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+inserted_embed AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT new_post.id, $14::text, $15::text, $16::text, $17::text, $18::text
+    FROM new_post
+    WHERE $14::text IS NOT NULL
+    ON CONFLICT (post_id) DO NOTHING
+),
+inserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT new_post.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM new_post, unnest($19::text[], $20::text[], $21::text[], $22::text[]) WITH ORDINALITY AS m(kind, blob_cid, mime_type, alt, position)
+    ON CONFLICT (post_id, position) DO NOTHING
 )
-INSERT INTO post_tags (post_id, tag_id)
-SELECT new_post.id, tag_id
-FROM (
-    SELECT id AS tag_id FROM inserted_tags
-    UNION
-    SELECT id AS tag_id FROM existing_tags
-) AS all_tags
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT new_post.id, all_tags.id, COALESCE(s.source, 'facet')
+FROM unnest($10::text[], $23::text[]) AS s(name, source)
+JOIN all_tags ON all_tags.name = s.name
 JOIN new_post ON true
+ON CONFLICT (post_id, tag_id) DO NOTHING
 `
 
 type CreatePostWithTagsParams struct {
-	PostID     string
-	CreatorDid string
-	CreatedAt  time.Time
-	Text       string
-	Tags       []string
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	Tags           []string
+	Mentions       []string
+	LinkUrls       []string
+	LinkDomains    []string
+	EmbedType      sql.NullString
+	ExternalUri    sql.NullString
+	ExternalTitle  sql.NullString
+	QuotedUri      sql.NullString
+	QuotedCid      sql.NullString
+	MediaKinds     []string
+	MediaCids      []string
+	MediaMimeTypes []string
+	MediaAlts      []string
+	TagSources     []string
 }
 
-// $6: tags
+// inserts a post with its tags, mentions, links and embed, doing nothing if it is already stored.
+// Tags are upserted rather than skipped when they exist: the no-op update locks the tag, in name order, and returns
+// its id even when another transaction inserted it after this statement's snapshot was taken.
 func (q *Queries) CreatePostWithTags(ctx context.Context, arg CreatePostWithTagsParams) error {
 	_, err := q.db.ExecContext(ctx, createPostWithTags,
 		arg.PostID,
 		arg.CreatorDid,
 		arg.CreatedAt,
 		arg.Text,
+		arg.ReplyRootUri,
+		arg.ReplyRootCid,
+		arg.ReplyParentUri,
+		arg.ReplyParentCid,
+		pq.Array(arg.Langs),
 		pq.Array(arg.Tags),
+		pq.Array(arg.Mentions),
+		pq.Array(arg.LinkUrls),
+		pq.Array(arg.LinkDomains),
+		arg.EmbedType,
+		arg.ExternalUri,
+		arg.ExternalTitle,
+		arg.QuotedUri,
+		arg.QuotedCid,
+		pq.Array(arg.MediaKinds),
+		pq.Array(arg.MediaCids),
+		pq.Array(arg.MediaMimeTypes),
+		pq.Array(arg.MediaAlts),
+		pq.Array(arg.TagSources),
+	)
+	return err
+}
+
+const createPostsWithTags = `-- name: CreatePostsWithTags :exec
+WITH new_posts AS (
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    SELECT u.post_id, u.creator_did, u.created_at, u.text,
+           NULLIF(u.reply_root_uri, ''), NULLIF(u.reply_root_cid, ''), NULLIF(u.reply_parent_uri, ''), NULLIF(u.reply_parent_cid, ''),
+           COALESCE(string_to_array(NULLIF(u.langs, ''), ','), '{}')
+    FROM unnest(
+        $1::text[], $2::text[], $3::timestamp[], $4::text[],
+        $5::text[], $6::text[], $7::text[], $8::text[],
+        $9::text[]
+    ) AS u(post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    ON CONFLICT (creator_did, post_id) DO NOTHING
+    RETURNING id, post_id, creator_did
+),
+links AS (
+    SELECT *
+    FROM unnest($10::text[], $11::text[], $12::text[], $34::text[]) AS l(post_id, creator_did, tag, source)
+),
+all_tags AS (
+    INSERT INTO tags (name)
+    SELECT DISTINCT tag FROM links
+    ORDER BY tag
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+    RETURNING id, name
+),
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT new_posts.id, m.did
+    FROM unnest($13::text[], $14::text[], $15::text[]) AS m(post_id, creator_did, did)
+    JOIN new_posts ON new_posts.post_id = m.post_id AND new_posts.creator_did = m.creator_did
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT new_posts.id, u.url, u.domain
+    FROM unnest($16::text[], $17::text[], $18::text[], $19::text[]) AS u(post_id, creator_did, url, domain)
+    JOIN new_posts ON new_posts.post_id = u.post_id AND new_posts.creator_did = u.creator_did
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+inserted_embeds AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT new_posts.id, e.embed_type, NULLIF(e.external_uri, ''), NULLIF(e.external_title, ''), NULLIF(e.quoted_uri, ''), NULLIF(e.quoted_cid, '')
+    FROM unnest(
+        $20::text[], $21::text[], $22::text[],
+        $23::text[], $24::text[], $25::text[], $26::text[]
+    ) AS e(post_id, creator_did, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    JOIN new_posts ON new_posts.post_id = e.post_id AND new_posts.creator_did = e.creator_did
+    ON CONFLICT (post_id) DO NOTHING
+),
+inserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT new_posts.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM unnest(
+        $27::text[], $28::text[], $29::int[],
+        $30::text[], $31::text[], $32::text[], $33::text[]
+    ) AS m(post_id, creator_did, position, kind, blob_cid, mime_type, alt)
+    JOIN new_posts ON new_posts.post_id = m.post_id AND new_posts.creator_did = m.creator_did
+    ON CONFLICT (post_id, position) DO NOTHING
+)
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT new_posts.id, all_tags.id, COALESCE(links.source, 'facet')
+FROM links
+JOIN new_posts ON new_posts.post_id = links.post_id AND new_posts.creator_did = links.creator_did
+JOIN all_tags ON all_tags.name = links.tag
+ON CONFLICT (post_id, tag_id) DO NOTHING
+`
+
+type CreatePostsWithTagsParams struct {
+	PostIds            []string
+	CreatorDids        []string
+	CreatedAts         []time.Time
+	Texts              []string
+	ReplyRootUris      []string
+	ReplyRootCids      []string
+	ReplyParentUris    []string
+	ReplyParentCids    []string
+	Langs              []string
+	LinkPostIds        []string
+	LinkCreatorDids    []string
+	LinkTags           []string
+	MentionPostIds     []string
+	MentionCreatorDids []string
+	MentionDids        []string
+	UrlPostIds         []string
+	UrlCreatorDids     []string
+	Urls               []string
+	UrlDomains         []string
+	EmbedPostIds       []string
+	EmbedCreatorDids   []string
+	EmbedTypes         []string
+	ExternalUris       []string
+	ExternalTitles     []string
+	QuotedUris         []string
+	QuotedCids         []string
+	MediaPostIds       []string
+	MediaCreatorDids   []string
+	MediaPositions     []int32
+	MediaKinds         []string
+	MediaCids          []string
+	MediaMimeTypes     []string
+	MediaAlts          []string
+	LinkTagSources     []string
+}
+
+// writes a batch of posts in one round trip, the link_* arrays hold one (post, tag, source) triple per element
+// and every tag in the batch is upserted once, as in CreatePostWithTags. The mention_* and url_* arrays likewise hold one
+// (post, mentioned DID) and (post, URL) pair per element. The embed_* arrays hold one element per post
+// with an embed, empty strings stored as NULL, and the media_* arrays one element per image or video.
+// Top level posts have empty reply_* elements, stored as NULL.
+// unnest flattens nested arrays, so each post's languages arrive comma separated.
+func (q *Queries) CreatePostsWithTags(ctx context.Context, arg CreatePostsWithTagsParams) error {
+	_, err := q.db.ExecContext(ctx, createPostsWithTags,
+		pq.Array(arg.PostIds),
+		pq.Array(arg.CreatorDids),
+		pq.Array(arg.CreatedAts),
+		pq.Array(arg.Texts),
+		pq.Array(arg.ReplyRootUris),
+		pq.Array(arg.ReplyRootCids),
+		pq.Array(arg.ReplyParentUris),
+		pq.Array(arg.ReplyParentCids),
+		pq.Array(arg.Langs),
+		pq.Array(arg.LinkPostIds),
+		pq.Array(arg.LinkCreatorDids),
+		pq.Array(arg.LinkTags),
+		pq.Array(arg.MentionPostIds),
+		pq.Array(arg.MentionCreatorDids),
+		pq.Array(arg.MentionDids),
+		pq.Array(arg.UrlPostIds),
+		pq.Array(arg.UrlCreatorDids),
+		pq.Array(arg.Urls),
+		pq.Array(arg.UrlDomains),
+		pq.Array(arg.EmbedPostIds),
+		pq.Array(arg.EmbedCreatorDids),
+		pq.Array(arg.EmbedTypes),
+		pq.Array(arg.ExternalUris),
+		pq.Array(arg.ExternalTitles),
+		pq.Array(arg.QuotedUris),
+		pq.Array(arg.QuotedCids),
+		pq.Array(arg.MediaPostIds),
+		pq.Array(arg.MediaCreatorDids),
+		pq.Array(arg.MediaPositions),
+		pq.Array(arg.MediaKinds),
+		pq.Array(arg.MediaCids),
+		pq.Array(arg.MediaMimeTypes),
+		pq.Array(arg.MediaAlts),
+		pq.Array(arg.LinkTagSources),
 	)
 	return err
 }
 
+const deleteDeadLetters = `-- name: DeleteDeadLetters :exec
+DELETE FROM dead_letters WHERE id = ANY($1::int[])
+`
+
+func (q *Queries) DeleteDeadLetters(ctx context.Context, ids []int32) error {
+	_, err := q.db.ExecContext(ctx, deleteDeadLetters, pq.Array(ids))
+	return err
+}
+
+const deleteOrphanedTags = `-- name: DeleteOrphanedTags :execrows
+DELETE FROM tags
+WHERE id IN (
+    SELECT t.id
+    FROM tags t
+    WHERE NOT EXISTS (SELECT 1 FROM post_tags pt WHERE pt.tag_id = t.id)
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+`
+
+// drops up to row_limit tags no post uses. Run it in a repeatable read transaction: a tag linked to a post
+// after the transaction started then fails the delete with a serialization error, instead of the delete
+// cascading to the new post_tags row. Tags locked by a post being written are skipped.
+func (q *Queries) DeleteOrphanedTags(ctx context.Context, rowLimit int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOrphanedTags, rowLimit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deletePost = `-- name: DeletePost :exec
+DELETE FROM posts
+WHERE creator_did = $1 AND post_id = $2
+`
+
+type DeletePostParams struct {
+	CreatorDid string
+	PostID     string
+}
+
+// removes a post by its natural key, cascading to post_tags. Tags no other post uses are left to DeleteOrphanedTags.
+func (q *Queries) DeletePost(ctx context.Context, arg DeletePostParams) error {
+	_, err := q.db.ExecContext(ctx, deletePost, arg.CreatorDid, arg.PostID)
+	return err
+}
+
+const getAccount = `-- name: GetAccount :one
+SELECT did, active, status, handle, status_time_us, handle_time_us, updated_at FROM accounts WHERE did = $1
+`
+
+func (q *Queries) GetAccount(ctx context.Context, did string) (Account, error) {
+	row := q.db.QueryRowContext(ctx, getAccount, did)
+	var i Account
+	err := row.Scan(
+		&i.Did,
+		&i.Active,
+		&i.Status,
+		&i.Handle,
+		&i.StatusTimeUs,
+		&i.HandleTimeUs,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getAccountHandles = `-- name: GetAccountHandles :many
+SELECT did, handle, handle_time_us FROM accounts WHERE did = ANY($1::text[])
+`
+
+type GetAccountHandlesRow struct {
+	Did          string
+	Handle       sql.NullString
+	HandleTimeUs int64
+}
+
+// the cached handles of the DIDs, DIDs that were never seen have no row
+func (q *Queries) GetAccountHandles(ctx context.Context, dids []string) ([]GetAccountHandlesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAccountHandles, pq.Array(dids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAccountHandlesRow
+	for rows.Next() {
+		var i GetAccountHandlesRow
+		if err := rows.Scan(&i.Did, &i.Handle, &i.HandleTimeUs); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeadLetter = `-- name: GetDeadLetter :one
+SELECT id, did, time_us, collection, operation, rkey, error, attempts, event, first_failed_at, last_failed_at FROM dead_letters WHERE id = $1
+`
+
+func (q *Queries) GetDeadLetter(ctx context.Context, id int32) (DeadLetter, error) {
+	row := q.db.QueryRowContext(ctx, getDeadLetter, id)
+	var i DeadLetter
+	err := row.Scan(
+		&i.ID,
+		&i.Did,
+		&i.TimeUs,
+		&i.Collection,
+		&i.Operation,
+		&i.Rkey,
+		&i.Error,
+		&i.Attempts,
+		&i.Event,
+		&i.FirstFailedAt,
+		&i.LastFailedAt,
+	)
+	return i, err
+}
+
+const getJetstreamCursor = `-- name: GetJetstreamCursor :one
+SELECT time_us FROM jetstream_cursors WHERE name = $1
+`
+
+func (q *Queries) GetJetstreamCursor(ctx context.Context, name string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getJetstreamCursor, name)
+	var time_us int64
+	err := row.Scan(&time_us)
+	return time_us, err
+}
+
+const getPost = `-- name: GetPost :one
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs FROM posts p
+WHERE p.creator_did = $1 AND p.post_id = $2
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+`
+
+type GetPostParams struct {
+	CreatorDid string
+	PostID     string
+}
+
+// not found while the creator's account is inactive
+func (q *Queries) GetPost(ctx context.Context, arg GetPostParams) (Post, error) {
+	row := q.db.QueryRowContext(ctx, getPost, arg.CreatorDid, arg.PostID)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.PostID,
+		&i.CreatorDid,
+		&i.CreatedAt,
+		&i.Text,
+		&i.ReplyRootUri,
+		&i.ReplyRootCid,
+		&i.ReplyParentUri,
+		&i.ReplyParentCid,
+		pq.Array(&i.Langs),
+	)
+	return i, err
+}
+
 const getPostById = `-- name: GetPostById :one
-SELECT id, post_id, creator_did, created_at, text FROM posts WHERE id = $1
+SELECT id, post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs FROM posts WHERE id = $1
 `
 
 func (q *Queries) GetPostById(ctx context.Context, id int32) (Post, error) {
@@ -72,37 +509,114 @@ func (q *Queries) GetPostById(ctx context.Context, id int32) (Post, error) {
 		&i.CreatorDid,
 		&i.CreatedAt,
 		&i.Text,
+		&i.ReplyRootUri,
+		&i.ReplyRootCid,
+		&i.ReplyParentUri,
+		&i.ReplyParentCid,
+		pq.Array(&i.Langs),
 	)
 	return i, err
 }
 
+const getRecentPostsByMention = `-- name: GetRecentPostsByMention :many
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs
+FROM posts p
+JOIN post_mentions m ON p.id = m.post_id
+WHERE m.did = $1
+  AND p.created_at >= $2
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY p.created_at DESC
+LIMIT $3 OFFSET $4
+`
+
+type GetRecentPostsByMentionParams struct {
+	Did          string
+	CreatedAfter time.Time
+	RowLimit     int32
+	RowOffset    int32
+}
+
+// posts and replies mentioning the DID
+func (q *Queries) GetRecentPostsByMention(ctx context.Context, arg GetRecentPostsByMentionParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentPostsByMention,
+		arg.Did,
+		arg.CreatedAfter,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.CreatorDid,
+			&i.CreatedAt,
+			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getRecentRootPostsByTagAndCreator = `-- name: GetRecentRootPostsByTagAndCreator :many
-SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, t.name AS tag_name
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
 FROM posts p
 JOIN post_tags pt ON p.id = pt.post_id
 JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
 WHERE t.name = ANY($1::text[])
   AND p.created_at >= $2
   AND p.creator_did = ANY($3::text[])
-ORDER BY p.created_at DESC
+  AND p.reply_root_uri IS NULL
+  AND (NOT $6::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN $7::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
 LIMIT $5 OFFSET $4
 `
 
 type GetRecentRootPostsByTagAndCreatorParams struct {
-	TagNames     []string
-	CreatedAfter time.Time
-	CreatorDids  []string
-	RowOffset    int32
-	RowLimit     int32
+	TagNames         []string
+	CreatedAfter     time.Time
+	CreatorDids      []string
+	RowOffset        int32
+	RowLimit         int32
+	MediaOnly        bool
+	SortByEngagement bool
 }
 
 type GetRecentRootPostsByTagAndCreatorRow struct {
-	ID         int32
-	PostID     string
-	CreatorDid string
-	CreatedAt  time.Time
-	Text       string
-	TagName    string
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	TagName        string
+	Likes          int32
+	Reposts        int32
 }
 
 func (q *Queries) GetRecentRootPostsByTagAndCreator(ctx context.Context, arg GetRecentRootPostsByTagAndCreatorParams) ([]GetRecentRootPostsByTagAndCreatorRow, error) {
@@ -112,6 +626,8 @@ func (q *Queries) GetRecentRootPostsByTagAndCreator(ctx context.Context, arg Get
 		pq.Array(arg.CreatorDids),
 		arg.RowOffset,
 		arg.RowLimit,
+		arg.MediaOnly,
+		arg.SortByEngagement,
 	)
 	if err != nil {
 		return nil, err
@@ -126,7 +642,106 @@ func (q *Queries) GetRecentRootPostsByTagAndCreator(ctx context.Context, arg Get
 			&i.CreatorDid,
 			&i.CreatedAt,
 			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
 			&i.TagName,
+			&i.Likes,
+			&i.Reposts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentRootPostsByTagAndCreatorAndLangs = `-- name: GetRecentRootPostsByTagAndCreatorAndLangs :many
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
+WHERE t.name = ANY($1::text[])
+  AND p.created_at >= $2
+  AND p.creator_did = ANY($3::text[])
+  AND p.reply_root_uri IS NULL
+  AND p.langs && $4::text[]
+  AND (NOT $7::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN $8::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
+LIMIT $6 OFFSET $5
+`
+
+type GetRecentRootPostsByTagAndCreatorAndLangsParams struct {
+	TagNames         []string
+	CreatedAfter     time.Time
+	CreatorDids      []string
+	Langs            []string
+	RowOffset        int32
+	RowLimit         int32
+	MediaOnly        bool
+	SortByEngagement bool
+}
+
+type GetRecentRootPostsByTagAndCreatorAndLangsRow struct {
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	TagName        string
+	Likes          int32
+	Reposts        int32
+}
+
+// like GetRecentRootPostsByTagAndCreator, only posts declaring at least one of the given lowercased languages
+func (q *Queries) GetRecentRootPostsByTagAndCreatorAndLangs(ctx context.Context, arg GetRecentRootPostsByTagAndCreatorAndLangsParams) ([]GetRecentRootPostsByTagAndCreatorAndLangsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentRootPostsByTagAndCreatorAndLangs,
+		pq.Array(arg.TagNames),
+		arg.CreatedAfter,
+		pq.Array(arg.CreatorDids),
+		pq.Array(arg.Langs),
+		arg.RowOffset,
+		arg.RowLimit,
+		arg.MediaOnly,
+		arg.SortByEngagement,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentRootPostsByTagAndCreatorAndLangsRow
+	for rows.Next() {
+		var i GetRecentRootPostsByTagAndCreatorAndLangsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.CreatorDid,
+			&i.CreatedAt,
+			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
+			&i.TagName,
+			&i.Likes,
+			&i.Reposts,
 		); err != nil {
 			return nil, err
 		}
@@ -142,38 +757,56 @@ func (q *Queries) GetRecentRootPostsByTagAndCreator(ctx context.Context, arg Get
 }
 
 const getRecentRootPostsByTags = `-- name: GetRecentRootPostsByTags :many
-SELECT DISTINCT p.id, p.post_id, p.creator_did, p.created_at, p.text, t.name AS tag_name
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
 FROM posts p
 JOIN post_tags pt ON p.id = pt.post_id
 JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
 WHERE t.name = ANY($1::text[]) 
   AND p.created_at >= $2
-ORDER BY p.created_at DESC
+  AND p.reply_root_uri IS NULL
+  AND (NOT $5::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN $7::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
 LIMIT $4 OFFSET $3
 `
 
 type GetRecentRootPostsByTagsParams struct {
-	TagNames     []string
-	CreatedAfter time.Time
-	RowOffset    int32
-	RowLimit     int32
+	TagNames         []string
+	CreatedAfter     time.Time
+	RowOffset        int32
+	RowLimit         int32
+	MediaOnly        bool
+	SortByEngagement bool
 }
 
 type GetRecentRootPostsByTagsRow struct {
-	ID         int32
-	PostID     string
-	CreatorDid string
-	CreatedAt  time.Time
-	Text       string
-	TagName    string
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	TagName        string
+	Likes          int32
+	Reposts        int32
 }
 
+// tagged root posts, newest first or, with sort_by_engagement, most liked and reposted first.
+// A post has one post_tags row per tag, so it's returned once for each requested tag it carries.
 func (q *Queries) GetRecentRootPostsByTags(ctx context.Context, arg GetRecentRootPostsByTagsParams) ([]GetRecentRootPostsByTagsRow, error) {
 	rows, err := q.db.QueryContext(ctx, getRecentRootPostsByTags,
 		pq.Array(arg.TagNames),
 		arg.CreatedAfter,
 		arg.RowOffset,
 		arg.RowLimit,
+		arg.MediaOnly,
+		arg.SortByEngagement,
 	)
 	if err != nil {
 		return nil, err
@@ -188,7 +821,14 @@ func (q *Queries) GetRecentRootPostsByTags(ctx context.Context, arg GetRecentRoo
 			&i.CreatorDid,
 			&i.CreatedAt,
 			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
 			&i.TagName,
+			&i.Likes,
+			&i.Reposts,
 		); err != nil {
 			return nil, err
 		}
@@ -202,3 +842,673 @@ func (q *Queries) GetRecentRootPostsByTags(ctx context.Context, arg GetRecentRoo
 	}
 	return items, nil
 }
+
+const getRecentRootPostsByTagsAndLangs = `-- name: GetRecentRootPostsByTagsAndLangs :many
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, t.name AS tag_name,
+       COALESCE(e.likes, 0)::int AS likes, COALESCE(e.reposts, 0)::int AS reposts
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+LEFT JOIN post_engagement e ON e.post_id = p.id
+WHERE t.name = ANY($1::text[])
+  AND p.created_at >= $2
+  AND p.reply_root_uri IS NULL
+  AND p.langs && $3::text[]
+  AND (NOT $6::boolean OR EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = p.id))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY CASE WHEN $7::boolean THEN COALESCE(e.likes, 0) + COALESCE(e.reposts, 0) ELSE 0 END DESC, p.created_at DESC
+LIMIT $5 OFFSET $4
+`
+
+type GetRecentRootPostsByTagsAndLangsParams struct {
+	TagNames         []string
+	CreatedAfter     time.Time
+	Langs            []string
+	RowOffset        int32
+	RowLimit         int32
+	MediaOnly        bool
+	SortByEngagement bool
+}
+
+type GetRecentRootPostsByTagsAndLangsRow struct {
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	TagName        string
+	Likes          int32
+	Reposts        int32
+}
+
+// like GetRecentRootPostsByTags, only posts declaring at least one of the given lowercased languages
+func (q *Queries) GetRecentRootPostsByTagsAndLangs(ctx context.Context, arg GetRecentRootPostsByTagsAndLangsParams) ([]GetRecentRootPostsByTagsAndLangsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentRootPostsByTagsAndLangs,
+		pq.Array(arg.TagNames),
+		arg.CreatedAfter,
+		pq.Array(arg.Langs),
+		arg.RowOffset,
+		arg.RowLimit,
+		arg.MediaOnly,
+		arg.SortByEngagement,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentRootPostsByTagsAndLangsRow
+	for rows.Next() {
+		var i GetRecentRootPostsByTagsAndLangsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.CreatorDid,
+			&i.CreatedAt,
+			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
+			&i.TagName,
+			&i.Likes,
+			&i.Reposts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentRootPostsByTagsAndLinkDomain = `-- name: GetRecentRootPostsByTagsAndLinkDomain :many
+SELECT DISTINCT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, t.name AS tag_name, l.url AS link_url
+FROM posts p
+JOIN post_tags pt ON p.id = pt.post_id
+JOIN tags t ON pt.tag_id = t.id
+JOIN post_links l ON p.id = l.post_id
+WHERE t.name = ANY($1::text[])
+  AND (l.domain = $2::text OR right(l.domain, length($2::text) + 1) = '.' || $2::text)
+  AND p.created_at >= $3
+  AND p.reply_root_uri IS NULL
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+ORDER BY p.created_at DESC
+LIMIT $4 OFFSET $5
+`
+
+type GetRecentRootPostsByTagsAndLinkDomainParams struct {
+	TagNames     []string
+	Domain       string
+	CreatedAfter time.Time
+	RowLimit     int32
+	RowOffset    int32
+}
+
+type GetRecentRootPostsByTagsAndLinkDomainRow struct {
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	TagName        string
+	LinkUrl        string
+}
+
+// root posts in any of the tags linking to the domain or one of its subdomains
+func (q *Queries) GetRecentRootPostsByTagsAndLinkDomain(ctx context.Context, arg GetRecentRootPostsByTagsAndLinkDomainParams) ([]GetRecentRootPostsByTagsAndLinkDomainRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentRootPostsByTagsAndLinkDomain,
+		pq.Array(arg.TagNames),
+		arg.Domain,
+		arg.CreatedAfter,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentRootPostsByTagsAndLinkDomainRow
+	for rows.Next() {
+		var i GetRecentRootPostsByTagsAndLinkDomainRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.CreatorDid,
+			&i.CreatedAt,
+			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
+			&i.TagName,
+			&i.LinkUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getThreadByRoot = `-- name: GetThreadByRoot :many
+SELECT p.id, p.post_id, p.creator_did, p.created_at, p.text, p.reply_root_uri, p.reply_root_cid, p.reply_parent_uri, p.reply_parent_cid, p.langs, COALESCE(array_agg(t.name) FILTER (WHERE t.name IS NOT NULL), '{}')::text[] AS tags
+FROM posts p
+LEFT JOIN post_tags pt ON p.id = pt.post_id
+LEFT JOIN tags t ON pt.tag_id = t.id
+WHERE (p.reply_root_uri = $1::text
+   OR (p.creator_did = $2 AND p.post_id = $3))
+  AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.did = p.creator_did AND NOT a.active)
+GROUP BY p.id
+ORDER BY p.created_at, p.id
+`
+
+type GetThreadByRootParams struct {
+	RootUri        string
+	RootCreatorDid string
+	RootPostID     string
+}
+
+type GetThreadByRootRow struct {
+	ID             int32
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	Tags           []string
+}
+
+// the root post, when stored, and every stored reply in its thread with their tags, oldest first
+func (q *Queries) GetThreadByRoot(ctx context.Context, arg GetThreadByRootParams) ([]GetThreadByRootRow, error) {
+	rows, err := q.db.QueryContext(ctx, getThreadByRoot, arg.RootUri, arg.RootCreatorDid, arg.RootPostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetThreadByRootRow
+	for rows.Next() {
+		var i GetThreadByRootRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.PostID,
+			&i.CreatorDid,
+			&i.CreatedAt,
+			&i.Text,
+			&i.ReplyRootUri,
+			&i.ReplyRootCid,
+			&i.ReplyParentUri,
+			&i.ReplyParentCid,
+			pq.Array(&i.Langs),
+			pq.Array(&i.Tags),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeadLetters = `-- name: ListDeadLetters :many
+SELECT id, did, time_us, collection, operation, rkey, error, attempts, event, first_failed_at, last_failed_at FROM dead_letters
+ORDER BY id
+LIMIT $1 OFFSET $2
+`
+
+type ListDeadLettersParams struct {
+	RowLimit  int32
+	RowOffset int32
+}
+
+// oldest first
+func (q *Queries) ListDeadLetters(ctx context.Context, arg ListDeadLettersParams) ([]DeadLetter, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadLetters, arg.RowLimit, arg.RowOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeadLetter
+	for rows.Next() {
+		var i DeadLetter
+		if err := rows.Scan(
+			&i.ID,
+			&i.Did,
+			&i.TimeUs,
+			&i.Collection,
+			&i.Operation,
+			&i.Rkey,
+			&i.Error,
+			&i.Attempts,
+			&i.Event,
+			&i.FirstFailedAt,
+			&i.LastFailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPostInteractionKeys = `-- name: ListPostInteractionKeys :many
+SELECT did, kind, rkey FROM post_interactions
+WHERE (did, kind, rkey) > ($1::text, $2::text, $3::text)
+ORDER BY did, kind, rkey
+LIMIT $4
+`
+
+type ListPostInteractionKeysParams struct {
+	AfterDid  string
+	AfterKind string
+	AfterRkey string
+	RowLimit  int32
+}
+
+type ListPostInteractionKeysRow struct {
+	Did  string
+	Kind string
+	Rkey string
+}
+
+// the keys of counted likes and reposts in key order, a page after the given key at a time
+func (q *Queries) ListPostInteractionKeys(ctx context.Context, arg ListPostInteractionKeysParams) ([]ListPostInteractionKeysRow, error) {
+	rows, err := q.db.QueryContext(ctx, listPostInteractionKeys,
+		arg.AfterDid,
+		arg.AfterKind,
+		arg.AfterRkey,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPostInteractionKeysRow
+	for rows.Next() {
+		var i ListPostInteractionKeysRow
+		if err := rows.Scan(&i.Did, &i.Kind, &i.Rkey); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPostKeys = `-- name: ListPostKeys :many
+SELECT id, creator_did, post_id FROM posts WHERE id > $1 ORDER BY id LIMIT $2
+`
+
+type ListPostKeysParams struct {
+	AfterID  int32
+	RowLimit int32
+}
+
+type ListPostKeysRow struct {
+	ID         int32
+	CreatorDid string
+	PostID     string
+}
+
+// the natural keys of stored posts in id order, a page after after_id at a time
+func (q *Queries) ListPostKeys(ctx context.Context, arg ListPostKeysParams) ([]ListPostKeysRow, error) {
+	rows, err := q.db.QueryContext(ctx, listPostKeys, arg.AfterID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPostKeysRow
+	for rows.Next() {
+		var i ListPostKeysRow
+		if err := rows.Scan(&i.ID, &i.CreatorDid, &i.PostID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeDeletedAccountPosts = `-- name: PurgeDeletedAccountPosts :one
+WITH doomed_posts AS (
+    SELECT p.id
+    FROM posts p
+    JOIN accounts a ON a.did = p.creator_did
+    WHERE NOT a.active AND a.status = 'deleted'
+    LIMIT $1
+),
+deleted_posts AS (
+    DELETE FROM posts
+    WHERE id IN (SELECT id FROM doomed_posts)
+    RETURNING id
+)
+SELECT count(*) FROM deleted_posts
+`
+
+// hard deletes up to row_limit posts of deleted accounts and counts them. Tags no other post uses are left to DeleteOrphanedTags.
+func (q *Queries) PurgeDeletedAccountPosts(ctx context.Context, rowLimit int32) (int64, error) {
+	row := q.db.QueryRowContext(ctx, purgeDeletedAccountPosts, rowLimit)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const removePostInteraction = `-- name: RemovePostInteraction :execrows
+WITH removed AS (
+    DELETE FROM post_interactions
+    WHERE did = $1 AND kind = $2 AND rkey = $3
+    RETURNING post_id, kind
+)
+UPDATE post_engagement e
+SET likes = e.likes - (r.kind = 'like')::int,
+    reposts = e.reposts - (r.kind = 'repost')::int
+FROM removed r
+WHERE e.post_id = r.post_id
+`
+
+type RemovePostInteractionParams struct {
+	Did  string
+	Kind string
+	Rkey string
+}
+
+// uncounts a deleted like or repost, one that was never counted changes nothing
+func (q *Queries) RemovePostInteraction(ctx context.Context, arg RemovePostInteractionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removePostInteraction, arg.Did, arg.Kind, arg.Rkey)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const saveAccountHandle = `-- name: SaveAccountHandle :exec
+INSERT INTO accounts (did, handle, handle_time_us)
+VALUES ($1, $2, $3)
+ON CONFLICT (did) DO UPDATE
+SET handle = EXCLUDED.handle,
+    handle_time_us = EXCLUDED.handle_time_us,
+    updated_at = CURRENT_TIMESTAMP
+WHERE accounts.handle_time_us <= EXCLUDED.handle_time_us
+`
+
+type SaveAccountHandleParams struct {
+	Did    string
+	Handle sql.NullString
+	TimeUs int64
+}
+
+// records an identity event's handle, NULL when the account has none, unless a later event was recorded already
+func (q *Queries) SaveAccountHandle(ctx context.Context, arg SaveAccountHandleParams) error {
+	_, err := q.db.ExecContext(ctx, saveAccountHandle, arg.Did, arg.Handle, arg.TimeUs)
+	return err
+}
+
+const saveAccountStatus = `-- name: SaveAccountStatus :exec
+INSERT INTO accounts (did, active, status, status_time_us)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (did) DO UPDATE
+SET active = EXCLUDED.active,
+    status = EXCLUDED.status,
+    status_time_us = EXCLUDED.status_time_us,
+    updated_at = CURRENT_TIMESTAMP
+WHERE accounts.status_time_us <= EXCLUDED.status_time_us
+`
+
+type SaveAccountStatusParams struct {
+	Did    string
+	Active bool
+	Status string
+	TimeUs int64
+}
+
+// records an account event's status, unless a later event was recorded already
+func (q *Queries) SaveAccountStatus(ctx context.Context, arg SaveAccountStatusParams) error {
+	_, err := q.db.ExecContext(ctx, saveAccountStatus,
+		arg.Did,
+		arg.Active,
+		arg.Status,
+		arg.TimeUs,
+	)
+	return err
+}
+
+const saveDeadLetter = `-- name: SaveDeadLetter :exec
+INSERT INTO dead_letters (did, time_us, collection, operation, rkey, error, event)
+VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb)
+ON CONFLICT (did, time_us) DO UPDATE
+SET error = EXCLUDED.error,
+    attempts = dead_letters.attempts + 1,
+    last_failed_at = CURRENT_TIMESTAMP
+`
+
+type SaveDeadLetterParams struct {
+	Did        string
+	TimeUs     int64
+	Collection string
+	Operation  string
+	Rkey       string
+	Error      string
+	Event      json.RawMessage
+}
+
+// records an event that failed processing, counting another attempt if it failed before
+func (q *Queries) SaveDeadLetter(ctx context.Context, arg SaveDeadLetterParams) error {
+	_, err := q.db.ExecContext(ctx, saveDeadLetter,
+		arg.Did,
+		arg.TimeUs,
+		arg.Collection,
+		arg.Operation,
+		arg.Rkey,
+		arg.Error,
+		arg.Event,
+	)
+	return err
+}
+
+const saveJetstreamCursor = `-- name: SaveJetstreamCursor :exec
+INSERT INTO jetstream_cursors (name, time_us, updated_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP)
+ON CONFLICT (name) DO UPDATE
+SET time_us = EXCLUDED.time_us,
+    updated_at = EXCLUDED.updated_at
+`
+
+type SaveJetstreamCursorParams struct {
+	Name   string
+	TimeUs int64
+}
+
+func (q *Queries) SaveJetstreamCursor(ctx context.Context, arg SaveJetstreamCursorParams) error {
+	_, err := q.db.ExecContext(ctx, saveJetstreamCursor, arg.Name, arg.TimeUs)
+	return err
+}
+
+const upsertPostWithTags = `-- name: UpsertPostWithTags :exec
+WITH target_post AS (
+    INSERT INTO posts (post_id, creator_did, created_at, text, reply_root_uri, reply_root_cid, reply_parent_uri, reply_parent_cid, langs)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    ON CONFLICT (creator_did, post_id) DO UPDATE
+    SET text = EXCLUDED.text,
+        created_at = EXCLUDED.created_at,
+        langs = EXCLUDED.langs
+    RETURNING id
+),
+all_tags AS (
+    INSERT INTO tags (name)
+    SELECT DISTINCT name FROM unnest($10::text[]) AS t(name)
+    ORDER BY name
+    ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+    RETURNING id AS tag_id, name
+),
+removed_post_tags AS (
+    DELETE FROM post_tags
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND tag_id NOT IN (SELECT tag_id FROM all_tags)
+),
+removed_mentions AS (
+    DELETE FROM post_mentions
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND did <> ALL($11::text[])
+),
+inserted_mentions AS (
+    INSERT INTO post_mentions (post_id, did)
+    SELECT target_post.id, m.did
+    FROM target_post, unnest($11::text[]) AS m(did)
+    ON CONFLICT (post_id, did) DO NOTHING
+),
+removed_links AS (
+    DELETE FROM post_links
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND url <> ALL($12::text[])
+),
+inserted_links AS (
+    INSERT INTO post_links (post_id, url, domain)
+    SELECT target_post.id, l.url, l.domain
+    FROM target_post, unnest($12::text[], $13::text[]) AS l(url, domain)
+    ON CONFLICT (post_id, url) DO NOTHING
+),
+removed_embed AS (
+    DELETE FROM post_embeds
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND $14::text IS NULL
+),
+upserted_embed AS (
+    INSERT INTO post_embeds (post_id, embed_type, external_uri, external_title, quoted_uri, quoted_cid)
+    SELECT target_post.id, $14::text, $15::text, $16::text, $17::text, $18::text
+    FROM target_post
+    WHERE $14::text IS NOT NULL
+    ON CONFLICT (post_id) DO UPDATE
+    SET embed_type = EXCLUDED.embed_type,
+        external_uri = EXCLUDED.external_uri,
+        external_title = EXCLUDED.external_title,
+        quoted_uri = EXCLUDED.quoted_uri,
+        quoted_cid = EXCLUDED.quoted_cid
+),
+removed_media AS (
+    DELETE FROM post_media
+    WHERE post_id IN (SELECT id FROM target_post)
+      AND position > COALESCE(cardinality($19::text[]), 0)
+),
+upserted_media AS (
+    INSERT INTO post_media (post_id, position, kind, blob_cid, mime_type, alt)
+    SELECT target_post.id, m.position, m.kind, m.blob_cid, m.mime_type, m.alt
+    FROM target_post, unnest($19::text[], $20::text[], $21::text[], $22::text[]) WITH ORDINALITY AS m(kind, blob_cid, mime_type, alt, position)
+    ON CONFLICT (post_id, position) DO UPDATE
+    SET kind = EXCLUDED.kind,
+        blob_cid = EXCLUDED.blob_cid,
+        mime_type = EXCLUDED.mime_type,
+        alt = EXCLUDED.alt
+)
+INSERT INTO post_tags (post_id, tag_id, source)
+SELECT target_post.id, all_tags.tag_id, COALESCE(s.source, 'facet')
+FROM unnest($10::text[], $23::text[]) AS s(name, source)
+JOIN all_tags ON all_tags.name = s.name
+JOIN target_post ON true
+ON CONFLICT (post_id, tag_id) DO UPDATE
+SET source = EXCLUDED.source
+`
+
+type UpsertPostWithTagsParams struct {
+	PostID         string
+	CreatorDid     string
+	CreatedAt      time.Time
+	Text           string
+	ReplyRootUri   sql.NullString
+	ReplyRootCid   sql.NullString
+	ReplyParentUri sql.NullString
+	ReplyParentCid sql.NullString
+	Langs          []string
+	Tags           []string
+	Mentions       []string
+	LinkUrls       []string
+	LinkDomains    []string
+	EmbedType      sql.NullString
+	ExternalUri    sql.NullString
+	ExternalTitle  sql.NullString
+	QuotedUri      sql.NullString
+	QuotedCid      sql.NullString
+	MediaKinds     []string
+	MediaCids      []string
+	MediaMimeTypes []string
+	MediaAlts      []string
+	TagSources     []string
+}
+
+// replaces the text, created_at, languages, tags, mentions, links and embed of a stored post, inserting it if it is not stored yet.
+// A post's reply references can't change, so they are only written on insert. Tags the edit removes that no other post uses are left to DeleteOrphanedTags.
+// Tags are upserted as in CreatePostWithTags.
+func (q *Queries) UpsertPostWithTags(ctx context.Context, arg UpsertPostWithTagsParams) error {
+	_, err := q.db.ExecContext(ctx, upsertPostWithTags,
+		arg.PostID,
+		arg.CreatorDid,
+		arg.CreatedAt,
+		arg.Text,
+		arg.ReplyRootUri,
+		arg.ReplyRootCid,
+		arg.ReplyParentUri,
+		arg.ReplyParentCid,
+		pq.Array(arg.Langs),
+		pq.Array(arg.Tags),
+		pq.Array(arg.Mentions),
+		pq.Array(arg.LinkUrls),
+		pq.Array(arg.LinkDomains),
+		arg.EmbedType,
+		arg.ExternalUri,
+		arg.ExternalTitle,
+		arg.QuotedUri,
+		arg.QuotedCid,
+		pq.Array(arg.MediaKinds),
+		pq.Array(arg.MediaCids),
+		pq.Array(arg.MediaMimeTypes),
+		pq.Array(arg.MediaAlts),
+		pq.Array(arg.TagSources),
+	)
+	return err
+}
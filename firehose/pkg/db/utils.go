@@ -4,103 +4,51 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"net/url"
-	"os"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
-)
-
-// GetPostgresURL loads environment variables and constructs a PostgreSQL connection URL
-func GetPostgresURL() string {
-	// Load .env file if it exists
-	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found: %v", err)
-	}
-
-	// Required environment variables
-	requiredVars := []string{
-		"DB_USER",
-		"DB_PASSWORD",
-		"DB_HOST",
-		"DB_PORT",
-		"DB_NAME",
-	}
+	"firehose/pkg/config"
 
-	// Check for missing required variables
-	missingVars := []string{}
-	for _, v := range requiredVars {
-		if os.Getenv(v) == "" {
-			missingVars = append(missingVars, v)
-		}
-	}
+	"github.com/lib/pq"
+)
 
-	if len(missingVars) > 0 {
-		log.Fatalf("Missing required environment variables: %v", missingVars)
+// DatabaseExists checks if the configured database exists, connecting to the server's postgres database
+func DatabaseExists(cfg config.Database) (bool, error) {
+	connStr, err := cfg.AdminConnString()
+	if err != nil {
+		return false, err
 	}
-
-	// Encode the password to handle special characters
-	encodedPassword := url.QueryEscape(os.Getenv("DB_PASSWORD"))
-
-	// Construct PostgreSQL connection URL
-	postgresURL := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=public",
-		os.Getenv("DB_USER"),
-		encodedPassword,
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"),
-	)
-
-	// Set the environment variable for other parts of the application
-	// TODO evaluate if this is needed still needed
-	os.Setenv("POSTGRESQL_URL", postgresURL)
-
-	return postgresURL
-}
-
-// DatabaseExists checks if the specified database exists
-func DatabaseExists(connectionString string) bool {
-	db, err := sql.Open("postgres", connectionString)
+	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		log.Printf("Failed to connect to database: %v", err)
-		return false
+		return false, fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer db.Close()
 
 	var exists bool
-	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", os.Getenv("DB_NAME")).Scan(&exists)
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", cfg.DatabaseName()).Scan(&exists)
 	if err != nil {
-		log.Printf("Failed to check database existence: %v", err)
-		return false
+		return false, fmt.Errorf("failed to check database existence: %w", err)
 	}
 
-	return exists
+	return exists, nil
 }
 
-// CreateDatabase creates a new database using the admin connection string
-func CreateDatabase() bool {
-	adminConnStr := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/postgres?sslmode=disable",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-	)
+// CreateDatabase creates the configured database using the admin connection string
+func CreateDatabase(cfg config.Database) error {
+	adminConnStr, err := cfg.AdminConnString()
+	if err != nil {
+		return err
+	}
 
 	db, err := sql.Open("postgres", adminConnStr)
 	if err != nil {
-		log.Printf("Failed to connect to PostgreSQL as admin: %v", err)
-		return false
+		return fmt.Errorf("failed to connect to PostgreSQL as admin: %w", err)
 	}
 	defer db.Close()
 
-	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s", os.Getenv("DB_NAME")))
-	if err != nil {
-		log.Printf("Failed to create database: %v", err)
-		return false
+	name := cfg.DatabaseName()
+	if _, err := db.Exec("CREATE DATABASE " + pq.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
 	}
 
-	log.Printf("Database %s created successfully.", os.Getenv("DB_NAME"))
-	return true
+	log.Printf("Database %s created successfully.", name)
+	return nil
 }
@@ -0,0 +1,148 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	EmbedTypeImages          = "app.bsky.embed.images"
+	EmbedTypeExternal        = "app.bsky.embed.external"
+	EmbedTypeRecord          = "app.bsky.embed.record"
+	EmbedTypeRecordWithMedia = "app.bsky.embed.recordWithMedia"
+	EmbedTypeVideo           = "app.bsky.embed.video"
+)
+
+// kinds of media attached to a post
+const (
+	MediaKindImage = "image"
+	MediaKindVideo = "video"
+)
+
+// Embed is the union of embeds a post can carry, only the fields of its Type are set
+type Embed struct {
+	Type string `json:"$type"`
+	// app.bsky.embed.images
+	Images []EmbedImage `json:"images,omitempty"`
+	// app.bsky.embed.external
+	External *EmbedExternal `json:"external,omitempty"`
+	// app.bsky.embed.record, and the quoted half of app.bsky.embed.recordWithMedia
+	Record *CIDURI `json:"-"`
+	// the media half of app.bsky.embed.recordWithMedia
+	Media *Embed `json:"media,omitempty"`
+	// app.bsky.embed.video
+	Video *Blob  `json:"video,omitempty"`
+	Alt   string `json:"alt,omitempty"`
+}
+
+type EmbedImage struct {
+	Alt   string `json:"alt"`
+	Image Blob   `json:"image"`
+}
+
+type EmbedExternal struct {
+	URI         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Thumb       *Blob  `json:"thumb,omitempty"`
+}
+
+type Blob struct {
+	Ref      Ref    `json:"ref"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+}
+
+// Media is one image or video attached to a post
+type Media struct {
+	Kind     string
+	CID      string
+	MimeType string
+	Alt      string
+}
+
+// UnmarshalJSON decodes the record field, which is a strong ref in app.bsky.embed.record
+// but a whole app.bsky.embed.record in app.bsky.embed.recordWithMedia
+func (e *Embed) UnmarshalJSON(data []byte) error {
+	type plainEmbed Embed
+	var raw struct {
+		plainEmbed
+		Record json.RawMessage `json:"record,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = Embed(raw.plainEmbed)
+
+	if len(raw.Record) == 0 {
+		return nil
+	}
+	switch e.Type {
+	case EmbedTypeRecord:
+		e.Record = &CIDURI{}
+		if err := json.Unmarshal(raw.Record, e.Record); err != nil {
+			return fmt.Errorf("failed to decode quoted record: %w", err)
+		}
+	case EmbedTypeRecordWithMedia:
+		var quoted Embed
+		if err := json.Unmarshal(raw.Record, &quoted); err != nil {
+			return fmt.Errorf("failed to decode quoted record: %w", err)
+		}
+		e.Record = quoted.Record
+	}
+	return nil
+}
+
+// MediaItems returns the images or video attached to the post, in order
+func (e *Embed) MediaItems() []Media {
+	if e == nil {
+		return nil
+	}
+
+	switch e.Type {
+	case EmbedTypeImages:
+		media := make([]Media, 0, len(e.Images))
+		for _, image := range e.Images {
+			if image.Image.Ref.Link == "" {
+				continue
+			}
+			media = append(media, Media{
+				Kind:     MediaKindImage,
+				CID:      image.Image.Ref.Link,
+				MimeType: image.Image.MimeType,
+				Alt:      image.Alt,
+			})
+		}
+		return media
+	case EmbedTypeVideo:
+		if e.Video == nil || e.Video.Ref.Link == "" {
+			return nil
+		}
+		return []Media{{Kind: MediaKindVideo, CID: e.Video.Ref.Link, MimeType: e.Video.MimeType, Alt: e.Alt}}
+	case EmbedTypeRecordWithMedia:
+		return e.Media.MediaItems()
+	}
+	return nil
+}
+
+// ExternalLink returns the link card attached to the post, if any
+func (e *Embed) ExternalLink() *EmbedExternal {
+	if e == nil {
+		return nil
+	}
+	if e.Type == EmbedTypeRecordWithMedia {
+		return e.Media.ExternalLink()
+	}
+	if e.Type == EmbedTypeExternal {
+		return e.External
+	}
+	return nil
+}
+
+// QuotedPost returns the record the post quotes, if any
+func (e *Embed) QuotedPost() *CIDURI {
+	if e == nil || (e.Type != EmbedTypeRecord && e.Type != EmbedTypeRecordWithMedia) {
+		return nil
+	}
+	return e.Record
+}
@@ -0,0 +1,92 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEmbedsFromSamples(t *testing.T) {
+	// this sample holds bare post records rather than events
+	f, err := os.Open(filepath.Join("..", "..", "db", "test", "data", "samples", "sample-post-commits.json"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	types := make(map[string]int)
+	var media, alts, videos, externals, quotes int
+	decoder := json.NewDecoder(f)
+	for {
+		var post PostCommitRecord
+		if err := decoder.Decode(&post); errors.Is(err, io.EOF) {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		if post.Embed == nil {
+			continue
+		}
+		types[post.Embed.Type]++
+		for _, item := range post.Embed.MediaItems() {
+			media++
+			assert.NotEmpty(t, item.CID)
+			if item.Alt != "" {
+				alts++
+			}
+			if item.Kind == MediaKindVideo {
+				videos++
+			}
+		}
+		if external := post.Embed.ExternalLink(); external != nil {
+			externals++
+			assert.NotEmpty(t, external.URI)
+		}
+		if quoted := post.Embed.QuotedPost(); quoted != nil {
+			quotes++
+			_, _, err := ParsePostURI(quoted.URI)
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, 44, types[EmbedTypeImages])
+	assert.Equal(t, 38, types[EmbedTypeExternal])
+	assert.Equal(t, 23, types[EmbedTypeRecord])
+	assert.Equal(t, 3, types[EmbedTypeRecordWithMedia])
+	assert.Equal(t, 1, types[EmbedTypeVideo])
+	assert.Equal(t, 63, media)
+	assert.Equal(t, 9, alts)
+	assert.Equal(t, 1, videos)
+	// one recordWithMedia carries a link card as its media
+	assert.Equal(t, 39, externals)
+	assert.Equal(t, 26, quotes)
+}
+
+func TestRecordWithMediaEmbed(t *testing.T) {
+	raw := `{
+		"$type": "app.bsky.embed.recordWithMedia",
+		"media": {
+			"$type": "app.bsky.embed.video",
+			"alt": "a clip",
+			"video": {"$type": "blob", "ref": {"$link": "bafkreivideo"}, "mimeType": "video/mp4", "size": 10}
+		},
+		"record": {
+			"$type": "app.bsky.embed.record",
+			"record": {"cid": "bafyreiquoted", "uri": "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d"}
+		}
+	}`
+	var embed Embed
+	require.NoError(t, json.Unmarshal([]byte(raw), &embed))
+
+	assert.Equal(t, []Media{{Kind: MediaKindVideo, CID: "bafkreivideo", MimeType: "video/mp4", Alt: "a clip"}}, embed.MediaItems())
+	assert.Equal(t, &CIDURI{CID: "bafyreiquoted", URI: "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d"}, embed.QuotedPost())
+	assert.Nil(t, embed.ExternalLink())
+
+	var empty *Embed
+	assert.Nil(t, empty.MediaItems())
+	assert.Nil(t, empty.QuotedPost())
+}
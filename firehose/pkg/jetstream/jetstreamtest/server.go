@@ -0,0 +1,322 @@
+// Package jetstreamtest serves the Jetstream websocket protocol from fixture events,
+// so ingestion can be tested end to end without the network. Faults can be injected
+// per connection to exercise reconnects.
+package jetstreamtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"firehose/pkg/replay"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// SubscribePath is where the server accepts subscriptions, as Jetstream does
+const SubscribePath = "/subscribe"
+
+// malformedFrame is sent in place of an event by Fault.MalformedAt
+var malformedFrame = []byte(`{"did":"did:plc:malformed","time_us":`)
+
+// Fault disturbs a single connection
+type Fault struct {
+	// Refuse the websocket handshake with a 503
+	Reject bool
+	// Drop the connection, without a close frame, once this many events were sent. 0 never does.
+	DisconnectAfter int
+	// Wait this long before sending each event
+	Delay time.Duration
+	// Send a frame that isn't valid JSON in place of this event, counting from 1. 0 never does.
+	MalformedAt int
+}
+
+// Connection records a subscription the server received
+type Connection struct {
+	// nil when the client asked for the live stream
+	Cursor            *int64
+	WantedCollections []string
+	WantedDids        []string
+	Compressed        bool
+	Rejected          bool
+	ConnectedAt       time.Time
+	// events sent so far, not counting a malformed frame
+	Sent int
+}
+
+// Server is a Jetstream endpoint serving fixture events. Events given to NewServer are its
+// history, replayed to clients that pass a cursor, Publish adds live events.
+type Server struct {
+	// URL is the websocket URL to subscribe to, ws://127.0.0.1:<port>/subscribe
+	URL string
+
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+	encoder  *zstd.Encoder
+
+	mu     sync.Mutex
+	events []*models.Event
+	faults []Fault
+	conns  []*Connection
+	// closed and replaced whenever events are published
+	published chan struct{}
+	closed    chan struct{}
+}
+
+// NewServer starts a server with events as its history. Close it when done.
+func NewServer(events []*models.Event) *Server {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(models.ZSTDDictionary))
+	if err != nil {
+		panic("jetstreamtest: failed to create zstd encoder: " + err.Error())
+	}
+
+	history := append([]*models.Event(nil), events...)
+	sort.SliceStable(history, func(i, j int) bool { return history[i].TimeUS < history[j].TimeUS })
+
+	s := &Server{
+		encoder:   encoder,
+		events:    history,
+		published: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(SubscribePath, s.handleSubscribe)
+	s.server = httptest.NewServer(mux)
+	s.URL = "ws" + strings.TrimPrefix(s.server.URL, "http") + SubscribePath
+	return s
+}
+
+// LoadEvents reads fixture events in any format the replay package accepts, failing the test on error
+func LoadEvents(t testing.TB, paths ...string) []*models.Event {
+	t.Helper()
+
+	var events []*models.Event
+	for _, path := range paths {
+		err := replay.Read(path, replay.Options{}, func(evt *models.Event, err error) error {
+			if err != nil {
+				return err
+			}
+			events = append(events, evt)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to load fixture events: %v", err)
+		}
+	}
+	return events
+}
+
+// Publish sends events to every connected client, after whatever they're still being replayed
+func (s *Server) Publish(events ...*models.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	close(s.published)
+	s.published = make(chan struct{})
+}
+
+// InjectFaults queues faults for the next connections, one each in order.
+// Connections after the queue runs out are served normally.
+func (s *Server) InjectFaults(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// Connections returns the subscriptions received so far, including rejected ones
+func (s *Server) Connections() []Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]Connection, len(s.conns))
+	for i, conn := range s.conns {
+		conns[i] = *conn
+	}
+	return conns
+}
+
+// WaitForConnections waits until n subscriptions have been received, reporting whether they were in time
+func (s *Server) WaitForConnections(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if len(s.Connections()) >= n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return len(s.Connections()) >= n
+}
+
+// Close drops every connection and stops the server
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	s.mu.Unlock()
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	conn := &Connection{
+		WantedCollections: query["wantedCollections"],
+		WantedDids:        query["wantedDids"],
+		Compressed:        strings.Contains(r.Header.Get("Socket-Encoding"), "zstd") || query.Get("compress") == "true",
+		ConnectedAt:       time.Now(),
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor: "+value, http.StatusBadRequest)
+			return
+		}
+		// like Jetstream, a cursor in the future is a live tail
+		if cursor <= time.Now().UnixMicro() {
+			conn.Cursor = &cursor
+		}
+	}
+
+	s.mu.Lock()
+	var fault Fault
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	conn.Rejected = fault.Reject
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	if fault.Reject {
+		http.Error(w, "jetstreamtest: connection rejected", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	// the client never sends anything we need, reading just notices it going away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	s.stream(ws, conn, fault, gone)
+}
+
+// stream sends the connection its events until the client goes away, the server closes or a fault ends it
+func (s *Server) stream(ws *websocket.Conn, conn *Connection, fault Fault, gone <-chan struct{}) {
+	s.mu.Lock()
+	next := len(s.events)
+	if conn.Cursor != nil {
+		next = sort.Search(len(s.events), func(i int) bool { return s.events[i].TimeUS >= *conn.Cursor })
+	}
+	s.mu.Unlock()
+
+	frames := 0
+	for {
+		s.mu.Lock()
+		published := s.published
+		var evt *models.Event
+		if next < len(s.events) {
+			evt = s.events[next]
+			next++
+		}
+		s.mu.Unlock()
+
+		if evt == nil {
+			select {
+			case <-published:
+				continue
+			case <-gone:
+				return
+			case <-s.closed:
+				return
+			}
+		}
+		if !wanted(conn, evt) {
+			continue
+		}
+
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-gone:
+				return
+			case <-s.closed:
+				return
+			}
+		}
+
+		frames++
+		frame := malformedFrame
+		if frames != fault.MalformedAt {
+			var err error
+			if frame, err = json.Marshal(evt); err != nil {
+				return
+			}
+		}
+		if conn.Compressed {
+			frame = s.encoder.EncodeAll(frame, nil)
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			return
+		}
+		if frames != fault.MalformedAt {
+			s.mu.Lock()
+			conn.Sent++
+			sent := conn.Sent
+			s.mu.Unlock()
+			if fault.DisconnectAfter > 0 && sent >= fault.DisconnectAfter {
+				return
+			}
+		}
+	}
+}
+
+// wanted applies the wantedCollections and wantedDids filters. As in Jetstream, a collection
+// ending in ".*" matches by prefix and events that aren't commits pass the collection filter.
+func wanted(conn *Connection, evt *models.Event) bool {
+	if len(conn.WantedDids) > 0 && !contains(conn.WantedDids, evt.Did) {
+		return false
+	}
+	if len(conn.WantedCollections) == 0 || evt.Commit == nil {
+		return true
+	}
+	for _, collection := range conn.WantedCollections {
+		if prefix, ok := strings.CutSuffix(collection, "*"); ok && strings.HasSuffix(prefix, ".") {
+			if strings.HasPrefix(evt.Commit.Collection, prefix) {
+				return true
+			}
+		} else if collection == evt.Commit.Collection {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
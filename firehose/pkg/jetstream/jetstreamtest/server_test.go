@@ -0,0 +1,206 @@
+package jetstreamtest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var samplesDir = filepath.Join("..", "..", "..", "db", "test", "data", "samples")
+
+// collector is a scheduler that keeps every event it's given
+type collector struct {
+	mu     sync.Mutex
+	events []*models.Event
+}
+
+func (c *collector) AddWork(ctx context.Context, repo string, evt *models.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, evt)
+	return nil
+}
+
+func (c *collector) Shutdown() {}
+
+func (c *collector) received() []*models.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*models.Event(nil), c.events...)
+}
+
+// subscribe connects a jetstream client to the server in the background
+func subscribe(t *testing.T, s *Server, cursor *int64, configure func(*client.ClientConfig)) (*collector, <-chan error) {
+	t.Helper()
+
+	config := client.DefaultClientConfig()
+	config.WebsocketURL = s.URL
+	if configure != nil {
+		configure(config)
+	}
+	events := &collector{}
+	c, err := client.NewClient(config, slog.New(slog.NewTextHandler(io.Discard, nil)), events)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.ConnectAndRead(ctx, cursor) }()
+	t.Cleanup(cancel)
+	return events, done
+}
+
+func waitForEvents(t *testing.T, c *collector, n int) []*models.Event {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(c.received()) >= n }, 5*time.Second, 5*time.Millisecond)
+	return c.received()
+}
+
+func waitForError(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection didn't end")
+		return nil
+	}
+}
+
+func TestReplaysFromCursor(t *testing.T) {
+	events := LoadEvents(t, filepath.Join(samplesDir, "jetstream-samples-with-tags.json"))
+	s := NewServer(events)
+	defer s.Close()
+
+	for _, compress := range []bool{true, false} {
+		cursor := events[100].TimeUS
+		received, _ := subscribe(t, s, &cursor, func(config *client.ClientConfig) { config.Compress = compress })
+		got := waitForEvents(t, received, len(events)-100)
+		assert.Len(t, got, len(events)-100)
+		assert.Equal(t, events[100].TimeUS, got[0].TimeUS)
+		assert.JSONEq(t, string(events[100].Commit.Record), string(got[0].Commit.Record))
+	}
+
+	conns := s.Connections()
+	require.Len(t, conns, 2)
+	assert.True(t, conns[0].Compressed)
+	assert.False(t, conns[1].Compressed)
+	require.NotNil(t, conns[0].Cursor)
+	assert.Equal(t, events[100].TimeUS, *conns[0].Cursor)
+}
+
+func TestLiveTailWithoutCursor(t *testing.T) {
+	events := LoadEvents(t, filepath.Join(samplesDir, "jetstream-samples-with-tags.json"))
+	s := NewServer(events[:10])
+	defer s.Close()
+
+	received, _ := subscribe(t, s, nil, nil)
+	require.True(t, s.WaitForConnections(1, 5*time.Second))
+	// history isn't replayed without a cursor, published events are sent
+	s.Publish(events[10:13]...)
+	got := waitForEvents(t, received, 3)
+	require.Len(t, got, 3)
+	assert.Equal(t, events[10].TimeUS, got[0].TimeUS)
+	assert.Nil(t, s.Connections()[0].Cursor)
+}
+
+func TestWantedCollections(t *testing.T) {
+	like := &models.Event{Did: "did:plc:rayleightestlike", TimeUS: 2, Kind: models.EventKindCommit,
+		Commit: &models.Commit{Operation: models.CommitOperationCreate, Collection: "app.bsky.feed.like", RKey: "3ldglike0001"}}
+	follow := &models.Event{Did: "did:plc:rayleightestfollow", TimeUS: 3, Kind: models.EventKindCommit,
+		Commit: &models.Commit{Operation: models.CommitOperationCreate, Collection: "app.bsky.graph.follow", RKey: "3ldgfollow001"}}
+	identity := &models.Event{Did: "did:plc:rayleightestidentity", TimeUS: 4, Kind: models.EventKindIdentity}
+	post := LoadEvents(t, filepath.Join(samplesDir, "jetstream-sample.json"))[0]
+	post.TimeUS = 1
+
+	tests := []struct {
+		name   string
+		wanted []string
+		want   []int64
+	}{
+		{"all", nil, []int64{1, 2, 3, 4}},
+		{"posts", []string{jetstream.PostCollection}, []int64{1, 4}},
+		{"prefix", []string{"app.bsky.feed.*"}, []int64{1, 2, 4}},
+		{"several", []string{jetstream.PostCollection, "app.bsky.graph.follow"}, []int64{1, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer([]*models.Event{post, like, follow, identity})
+			defer s.Close()
+
+			cursor := int64(1)
+			received, _ := subscribe(t, s, &cursor, func(config *client.ClientConfig) { config.WantedCollections = tt.wanted })
+			var got []int64
+			for _, evt := range waitForEvents(t, received, len(tt.want)) {
+				got = append(got, evt.TimeUS)
+			}
+			// nothing else arrives
+			time.Sleep(20 * time.Millisecond)
+			assert.Len(t, received.received(), len(tt.want))
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wanted, s.Connections()[0].WantedCollections)
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	events := LoadEvents(t, filepath.Join(samplesDir, "jetstream-samples-with-tags.json"))
+	cursor := events[0].TimeUS
+
+	t.Run("disconnect", func(t *testing.T) {
+		s := NewServer(events)
+		defer s.Close()
+		s.InjectFaults(Fault{DisconnectAfter: 5})
+
+		received, done := subscribe(t, s, &cursor, nil)
+		assert.Error(t, waitForError(t, done))
+		assert.Len(t, received.received(), 5)
+		assert.Equal(t, 5, s.Connections()[0].Sent)
+	})
+
+	t.Run("malformed frame", func(t *testing.T) {
+		s := NewServer(events)
+		defer s.Close()
+		s.InjectFaults(Fault{MalformedAt: 3})
+
+		received, done := subscribe(t, s, &cursor, nil)
+		assert.ErrorContains(t, waitForError(t, done), "failed to unmarshal event")
+		assert.Len(t, received.received(), 2)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		s := NewServer(events)
+		defer s.Close()
+		s.InjectFaults(Fault{Reject: true})
+
+		_, done := subscribe(t, s, &cursor, nil)
+		assert.Error(t, waitForError(t, done))
+		require.Len(t, s.Connections(), 1)
+		assert.True(t, s.Connections()[0].Rejected)
+
+		// the fault only applied to the first connection
+		received, _ := subscribe(t, s, &cursor, nil)
+		waitForEvents(t, received, len(events))
+	})
+
+	t.Run("slow frames", func(t *testing.T) {
+		s := NewServer(events)
+		defer s.Close()
+		s.InjectFaults(Fault{Delay: 20 * time.Millisecond})
+
+		started := time.Now()
+		received, _ := subscribe(t, s, &cursor, nil)
+		waitForEvents(t, received, 5)
+		assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+	})
+}
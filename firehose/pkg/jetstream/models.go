@@ -3,19 +3,31 @@ package jetstream
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/bluesky-social/jetstream/pkg/models"
 )
 
 type PostCommitRecord struct {
-	Type      string    `json:"$type"`
-	CreatedAt time.Time `json:"createdAt"`
-	Facets    []Facet   `json:"facets"`
-	Langs     []string  `json:"langs"`
-	Reply     *Reply    `json:"reply,omitempty"` // Optional field
-	Text      string    `json:"text"`
-	Tags      []string  // this is a calculated field derived from the Facets
+	Type       string    `json:"$type"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Embed      *Embed    `json:"embed,omitempty"`
+	Facets     []Facet   `json:"facets"`
+	Langs      []string  `json:"langs"`
+	Reply      *Reply    `json:"reply,omitempty"` // Optional field
+	Text       string    `json:"text"`
+	Tags       []string  // this is a calculated field derived from the Facets
+	TagSources []string  // TagSourceFacet or TagSourceText for each of the Tags
+	Mentions   []string  // DIDs mentioned, derived from the Facets
+	Links      []Link    // derived from the Facets
+}
+
+// Link is a URL from a link facet along with the domain it points at
+type Link struct {
+	URL    string
+	Domain string
 }
 
 type Ref struct {
@@ -31,8 +43,15 @@ type Feature struct {
 	Type string `json:"$type"`
 	Tag  string `json:"tag,omitempty"`
 	URI  string `json:"uri,omitempty"`
+	DID  string `json:"did,omitempty"`
 }
 
+const (
+	FeatureTypeTag     = "app.bsky.richtext.facet#tag"
+	FeatureTypeLink    = "app.bsky.richtext.facet#link"
+	FeatureTypeMention = "app.bsky.richtext.facet#mention"
+)
+
 type Index struct {
 	ByteEnd   int `json:"byteEnd"`
 	ByteStart int `json:"byteStart"`
@@ -48,18 +67,120 @@ type CIDURI struct {
 	URI string `json:"uri"`
 }
 
+const (
+	PostCollection   = "app.bsky.feed.post"
+	LikeCollection   = "app.bsky.feed.like"
+	RepostCollection = "app.bsky.feed.repost"
+)
+
+// InteractionRecord is a like or a repost, both point at the post they're about
+type InteractionRecord struct {
+	Type      string    `json:"$type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Subject   CIDURI    `json:"subject"`
+}
+
+// PostURI builds the AT-URI of a post from its creator and record key
+func PostURI(did string, rkey string) string {
+	return "at://" + did + "/" + PostCollection + "/" + rkey
+}
+
+// ParsePostURI splits a post's AT-URI back into its creator and record key
+func ParsePostURI(uri string) (did string, rkey string, err error) {
+	parts := strings.Split(strings.TrimPrefix(uri, "at://"), "/")
+	if !strings.HasPrefix(uri, "at://") || len(parts) != 3 || parts[0] == "" || parts[1] != PostCollection || parts[2] == "" {
+		return "", "", fmt.Errorf("not a post AT-URI: %q", uri)
+	}
+	return parts[0], parts[2], nil
+}
+
+// ExtractTags returns the normalized hashtags in the facets, each once
 func ExtractTags(facets []Facet) []string {
 	tags := make([]string, 0)
 
 	for _, facet := range facets {
 		for _, feature := range facet.Features {
-			if feature.Type == "app.bsky.richtext.facet#tag" && feature.Tag != "" {
+			if feature.Type == FeatureTypeTag && feature.Tag != "" {
 				tags = append(tags, feature.Tag)
 			}
 		}
 	}
 
-	return tags
+	return NormalizeTags(tags)
+}
+
+// NormalizeLangs lowercases and dedups the languages a post declares so they can be matched exactly.
+// Languages are BCP-47 tags, anything blank or containing a comma is dropped.
+func NormalizeLangs(langs []string) []string {
+	normalized := make([]string, 0, len(langs))
+	seen := make(map[string]bool, len(langs))
+
+	for _, lang := range langs {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || strings.Contains(lang, ",") || seen[lang] {
+			continue
+		}
+		seen[lang] = true
+		normalized = append(normalized, lang)
+	}
+
+	return normalized
+}
+
+// ExtractMentions returns the DIDs mentioned in the facets, each once
+func ExtractMentions(facets []Facet) []string {
+	mentions := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, facet := range facets {
+		for _, feature := range facet.Features {
+			if feature.Type == FeatureTypeMention && strings.HasPrefix(feature.DID, "did:") && !seen[feature.DID] {
+				seen[feature.DID] = true
+				mentions = append(mentions, feature.DID)
+			}
+		}
+	}
+
+	return mentions
+}
+
+// maxLinkLength keeps URLs well inside what a Postgres btree index entry can hold
+const maxLinkLength = 2048
+
+// ExtractLinks returns the web links in the facets, each once. Links without a host or longer than 2048 bytes are skipped.
+func ExtractLinks(facets []Facet) []Link {
+	links := make([]Link, 0)
+	seen := make(map[string]bool)
+
+	for _, facet := range facets {
+		for _, feature := range facet.Features {
+			if feature.Type != FeatureTypeLink || len(feature.URI) > maxLinkLength || seen[feature.URI] {
+				continue
+			}
+			domain := LinkDomain(feature.URI)
+			if domain == "" {
+				continue
+			}
+			seen[feature.URI] = true
+			links = append(links, Link{URL: feature.URI, Domain: domain})
+		}
+	}
+
+	return links
+}
+
+// LinkDomain returns the normalized host of an http(s) URL without its port, or "" if it isn't one
+func LinkDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return NormalizeDomain(u.Hostname())
+}
+
+// NormalizeDomain lowercases a domain and drops a leading "www." so it matches stored link domains
+func NormalizeDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
 }
 
 func ExtractPost(evt *models.Event) (*PostCommitRecord, error) {
@@ -72,6 +193,24 @@ func ExtractPost(evt *models.Event) (*PostCommitRecord, error) {
 	}
 
 	post.Tags = ExtractTags(post.Facets)
+	post.TagSources = make([]string, len(post.Tags))
+	for i := range post.TagSources {
+		post.TagSources[i] = TagSourceFacet
+	}
+	post.Mentions = ExtractMentions(post.Facets)
+	post.Links = ExtractLinks(post.Facets)
 	return &post, nil
 
 }
+
+// ExtractInteraction decodes the record of a like or repost commit
+func ExtractInteraction(evt *models.Event) (*InteractionRecord, error) {
+	var interaction InteractionRecord
+	if err := json.Unmarshal(evt.Commit.Record, &interaction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s commit data: %w", evt.Commit.Collection, err)
+	}
+	if interaction.Subject.URI == "" {
+		return nil, fmt.Errorf("%s commit has no subject", evt.Commit.Collection)
+	}
+	return &interaction, nil
+}
@@ -0,0 +1,113 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadSamplePosts decodes every post in a sample file, which may hold JSONL or pretty-printed events
+func loadSamplePosts(t *testing.T, name string) []*PostCommitRecord {
+	t.Helper()
+	f, err := os.Open(filepath.Join("..", "..", "db", "test", "data", "samples", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var posts []*PostCommitRecord
+	decoder := json.NewDecoder(f)
+	for {
+		var evt models.Event
+		if err := decoder.Decode(&evt); errors.Is(err, io.EOF) {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		post, err := ExtractPost(&evt)
+		require.NoError(t, err)
+		posts = append(posts, post)
+	}
+	return posts
+}
+
+func TestNormalizeLangs(t *testing.T) {
+	assert.Equal(t, []string{"en", "en-gb", "ja"}, NormalizeLangs([]string{"en", "EN", " en-GB ", "", "ja", "a,b"}))
+	assert.Equal(t, []string{}, NormalizeLangs(nil))
+}
+
+func TestPostURIRoundTrip(t *testing.T) {
+	uri := PostURI("did:plc:umdlujsvvpv2rg5ztugglbdr", "3ldgcjgw4bi2y")
+	assert.Equal(t, "at://did:plc:umdlujsvvpv2rg5ztugglbdr/app.bsky.feed.post/3ldgcjgw4bi2y", uri)
+
+	did, rkey, err := ParsePostURI(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "did:plc:umdlujsvvpv2rg5ztugglbdr", did)
+	assert.Equal(t, "3ldgcjgw4bi2y", rkey)
+
+	for _, bad := range []string{"", "did:plc:a/app.bsky.feed.post/x", "at://did:plc:a/app.bsky.feed.like/x", "at://did:plc:a/app.bsky.feed.post/"} {
+		_, _, err := ParsePostURI(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestExtractInteraction(t *testing.T) {
+	commit := func(record string) *models.Event {
+		return &models.Event{Commit: &models.Commit{Collection: RepostCollection, Record: json.RawMessage(record)}}
+	}
+
+	repost, err := ExtractInteraction(commit(`{"$type":"app.bsky.feed.repost","createdAt":"2024-12-16T13:00:04.000Z",` +
+		`"subject":{"cid":"bafyreisubject","uri":"at://did:plc:a/app.bsky.feed.post/3ldgeng0post2"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "at://did:plc:a/app.bsky.feed.post/3ldgeng0post2", repost.Subject.URI)
+	assert.Equal(t, 4, repost.CreatedAt.Second())
+
+	_, err = ExtractInteraction(commit(`{"$type":"app.bsky.feed.repost","createdAt":"2024-12-16T13:00:04.000Z"}`))
+	assert.Error(t, err)
+	_, err = ExtractInteraction(commit(`not json`))
+	assert.Error(t, err)
+}
+
+func TestExtractMentionsAndLinks(t *testing.T) {
+	facets := []Facet{
+		{Features: []Feature{{Type: FeatureTypeMention, DID: "did:plc:a"}}},
+		{Features: []Feature{{Type: FeatureTypeLink, URI: "https://www.Example.com/page"}}},
+		{Features: []Feature{{Type: FeatureTypeMention, DID: "did:plc:a"}, {Type: FeatureTypeMention, DID: "not-a-did"}}},
+		{Features: []Feature{{Type: FeatureTypeLink, URI: "mailto:someone@example.com"}}},
+		{Features: []Feature{{Type: FeatureTypeTag, Tag: "art"}}},
+	}
+
+	assert.Equal(t, []string{"did:plc:a"}, ExtractMentions(facets))
+	assert.Equal(t, []Link{{URL: "https://www.Example.com/page", Domain: "example.com"}}, ExtractLinks(facets))
+}
+
+func TestLinkDomain(t *testing.T) {
+	assert.Equal(t, "example.com", LinkDomain("https://www.example.com/path?q=1"))
+	assert.Equal(t, "blog.example.com", LinkDomain("http://Blog.Example.com:8080/"))
+	assert.Equal(t, "", LinkDomain("example.com"))
+	assert.Equal(t, "example.com", NormalizeDomain(" WWW.Example.com"))
+	assert.Equal(t, "", LinkDomain("ftp://example.com/file"))
+	assert.Equal(t, "", LinkDomain("https://"))
+}
+
+func TestExtractFacetsFromSamples(t *testing.T) {
+	var mentions, links int
+	domains := make(map[string]int)
+	for _, post := range loadSamplePosts(t, "jetstream-samples-with-tags.json") {
+		mentions += len(post.Mentions)
+		links += len(post.Links)
+		for _, link := range post.Links {
+			domains[link.Domain]++
+		}
+	}
+
+	assert.Equal(t, 12, mentions)
+	assert.Equal(t, 51, links)
+	assert.Equal(t, 6, domains["mixi.social"])
+	assert.Equal(t, 3, domains["youtube.com"])
+}
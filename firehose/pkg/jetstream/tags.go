@@ -0,0 +1,118 @@
+package jetstream
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxTagLength is the longest tag kept, in characters, matching the VARCHAR(64) of tags.name
+const MaxTagLength = 64
+
+// NormalizeTag returns the canonical form of a hashtag so that "#Art", "art" and "ＡＲＴ" are stored as one tag.
+// The tag is NFKC normalized and case folded, then trimmed and stripped of a leading '#'.
+// Blank tags and tags longer than MaxTagLength come back as "", a truncated tag wouldn't be the one the author wrote.
+func NormalizeTag(tag string) string {
+	// NFKC again after folding, as folding can undo the normalization
+	tag = norm.NFKC.String(cases.Fold().String(norm.NFKC.String(tag)))
+	tag = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return ""
+	}
+	return tag
+}
+
+// NormalizeTags normalizes every tag, dropping the blank, overlong and duplicate ones, and keeps the order they were written in
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+// where a post's tag came from
+const (
+	TagSourceFacet = "facet"
+	TagSourceText  = "text"
+)
+
+// ExtractTextTags finds the #hashtags written in a post's text the way the Bluesky app detects them:
+// a '#' or '＃' at the start of the text or after whitespace, followed by anything up to the next space,
+// as long as it holds at least one character that isn't an ASCII digit or punctuation.
+// Trailing punctuation isn't part of the tag. The tags come back normalized, each once.
+func ExtractTextTags(text string) []string {
+	tags := make([]string, 0)
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '＃' {
+			continue
+		}
+		if i > 0 && !unicode.IsSpace(runes[i-1]) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && !endsTextTag(runes[end]) {
+			end++
+		}
+		body := runes[i+1 : end]
+		i = end - 1
+
+		// "#️⃣" is the keycap emoji, not a tag
+		if len(body) == 0 || body[0] == '\ufe0f' || !hasTagLetter(body) {
+			continue
+		}
+		tags = append(tags, strings.TrimRightFunc(string(body), unicode.IsPunct))
+	}
+
+	return NormalizeTags(tags)
+}
+
+// endsTextTag reports whether r ends a hashtag, which runs up to whitespace or an invisible separator
+func endsTextTag(r rune) bool {
+	switch r {
+	case '\u00ad', '\u2060', '\u200a', '\u200b', '\u200c', '\u200d', '\u20e2':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// hasTagLetter reports whether a hashtag is more than numbers and punctuation
+func hasTagLetter(body []rune) bool {
+	for _, r := range body {
+		if (r < '0' || r > '9') && !unicode.IsPunct(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTextTags merges the hashtags written in the post's text into its facet tags,
+// marking the ones only found in the text with TagSourceText
+func (p *PostCommitRecord) AddTextTags() {
+	seen := make(map[string]bool, len(p.Tags))
+	for _, tag := range p.Tags {
+		seen[tag] = true
+	}
+	for _, tag := range ExtractTextTags(p.Text) {
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		p.Tags = append(p.Tags, tag)
+		p.TagSources = append(p.TagSources, TagSourceText)
+	}
+}
@@ -0,0 +1,161 @@
+package jetstream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"art", "art"},
+		{"Art", "art"},
+		{"#Art", "art"},
+		{"  #art  ", "art"},
+		{"# art", "art"},
+		// fullwidth letters and hash sign
+		{"ＡＲＴ", "art"},
+		{"＃ＡＲＴ", "art"},
+		// case folding rather than lowercasing
+		{"Straße", "strasse"},
+		{"ΣΊΣΥΦΟΣ", "σίσυφοσ"},
+		{"ｶﾀｶﾅ", "カタカナ"},
+		{"日本語", "日本語"},
+		{"", ""},
+		{"#", ""},
+		{" ", ""},
+		{strings.Repeat("a", MaxTagLength), strings.Repeat("a", MaxTagLength)},
+		{strings.Repeat("a", MaxTagLength+1), ""},
+		// the limit counts characters, not bytes
+		{strings.Repeat("あ", MaxTagLength), strings.Repeat("あ", MaxTagLength)},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NormalizeTag(tt.tag), "NormalizeTag(%q)", tt.tag)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags := NormalizeTags([]string{"Art", "#art", "ＡＲＴ", "sketch", "", strings.Repeat("x", 100), "Sketch"})
+	assert.Equal(t, []string{"art", "sketch"}, tags)
+}
+
+func TestExtractedTagsAreNormalized(t *testing.T) {
+	for _, post := range loadSamplePosts(t, "jetstream-samples-with-tags.json") {
+		seen := make(map[string]bool)
+		for _, tag := range post.Tags {
+			assert.Equal(t, NormalizeTag(tag), tag)
+			assert.False(t, seen[tag], "duplicate tag %q", tag)
+			seen[tag] = true
+		}
+	}
+}
+
+func TestExtractTextTags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"#art", []string{"art"}},
+		{"sketching #Art today", []string{"art"}},
+		{"line one\n#art", []string{"art"}},
+		{"＃Art", []string{"art"}},
+		{"#日本語 #ß", []string{"日本語", "ss"}},
+		// trailing punctuation is dropped, inner punctuation kept
+		{"done #art. and #wip!?", []string{"art", "wip"}},
+		{"#kexp's #c++", []string{"kexp's", "c++"}},
+		// numbers alone aren't tags, numbers with letters are
+		{"#1 #2024 #1st", []string{"1st"}},
+		{"#123!", []string{}},
+		// only after whitespace or at the start
+		{"a#b (#art) https://example.com/#anchor", []string{}},
+		{"# art #", []string{}},
+		// the keycap emoji and invisible separators
+		{"#\ufe0f\u20e3 #a\u200bb", []string{"a"}},
+		{"#art #ART #art", []string{"art"}},
+		{"#" + strings.Repeat("a", MaxTagLength+1), []string{}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ExtractTextTags(tt.text), "ExtractTextTags(%q)", tt.text)
+	}
+}
+
+func TestAddTextTags(t *testing.T) {
+	post := &PostCommitRecord{
+		Text:       "#Art and #sketch",
+		Tags:       []string{"art"},
+		TagSources: []string{TagSourceFacet},
+	}
+	post.AddTextTags()
+	assert.Equal(t, []string{"art", "sketch"}, post.Tags)
+	assert.Equal(t, []string{TagSourceFacet, TagSourceText}, post.TagSources)
+}
+
+func TestTextTagsMatchFacetTagsInSamples(t *testing.T) {
+	// the app writes a tag facet for every hashtag it detects in the text
+	for _, post := range loadSamplePosts(t, "jetstream-samples-with-tags.json") {
+		assert.ElementsMatch(t, post.Tags, ExtractTextTags(post.Text), "post text %q", post.Text)
+	}
+
+	// other clients don't all follow the app's rules
+	f, err := os.Open(filepath.Join("..", "..", "db", "test", "data", "samples", "sample-post-commits.json"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var facetTags, textTags, textOnly int
+	decoder := json.NewDecoder(f)
+	for {
+		var post PostCommitRecord
+		if err := decoder.Decode(&post); errors.Is(err, io.EOF) {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		tags := ExtractTags(post.Facets)
+		facetTags += len(tags)
+		for _, tag := range ExtractTextTags(post.Text) {
+			textTags++
+			if !slices.Contains(tags, tag) {
+				textOnly++
+			}
+		}
+	}
+	assert.Equal(t, 63, facetTags)
+	assert.Equal(t, 63, textTags)
+	// facets ending "#KEXP's" before the apostrophe and "#WynonnaEarp🍩" before the emoji
+	assert.Equal(t, 5, textOnly)
+}
+
+func TestTextTagsInSqliteDump(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "db", "test", "data", "samples", "posts.txt"))
+	require.NoError(t, err)
+
+	// the dump's data column holds the post record
+	var posts int
+	for _, line := range strings.Split(string(data), "\n") {
+		_, record, found := strings.Cut(line, `|{"$type":"app.bsky.feed.post"`)
+		if !found {
+			continue
+		}
+		var post PostCommitRecord
+		require.NoError(t, json.Unmarshal([]byte(`{"$type":"app.bsky.feed.post"`+record), &post))
+		posts++
+
+		// a "facet#link" type and a URL, neither of them hashtags
+		assert.Empty(t, ExtractTextTags(post.Text))
+		assert.Empty(t, ExtractTags(post.Facets))
+	}
+	assert.Equal(t, 1, posts)
+}
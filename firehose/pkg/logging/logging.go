@@ -0,0 +1,83 @@
+// Package logging builds the structured loggers the firehose commands write,
+// as text or JSON lines to stderr or to a log file rotated by size and age.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config says where logs go and what they look like
+type Config struct {
+	// log file, empty writes to stderr
+	Path string
+	// FormatText or FormatJSON
+	Format string
+	// debug, info, warn or error
+	Level string
+	// the file is rotated once it grows past MaxSizeMB or has been written to for MaxAge, 0 turns either off
+	MaxSizeMB int64
+	MaxAge    time.Duration
+	// rotated files kept, 0 keeps them all
+	MaxBackups int
+}
+
+// RegisterFlags adds the -log flags to a command's flag set, returning the config they fill in once parsed
+func RegisterFlags(fs *flag.FlagSet, defaultPath string) *Config {
+	cfg := &Config{}
+	fs.StringVar(&cfg.Path, "log", defaultPath, "Path to the log file, empty to log to stderr")
+	fs.StringVar(&cfg.Format, "log-format", FormatText, "Log format, text or json")
+	fs.StringVar(&cfg.Level, "log-level", "info", "Lowest level logged: debug, info, warn or error")
+	fs.Int64Var(&cfg.MaxSizeMB, "log-max-size-mb", 100, "Size in MB at which the log file is rotated, 0 to never rotate by size")
+	fs.DurationVar(&cfg.MaxAge, "log-max-age", 24*time.Hour, "Age at which the log file is rotated, 0 to never rotate by age")
+	fs.IntVar(&cfg.MaxBackups, "log-max-backups", 7, "Rotated log files kept, 0 keeps them all")
+	return cfg
+}
+
+// New builds a logger from the config. Closing the returned closer closes the log file, if there is one.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q, want debug, info, warn or error", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if cfg.Path != "" {
+		file, err := OpenRotatingFile(cfg.Path, cfg.MaxSizeMB*1024*1024, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+	}
+
+	switch strings.ToLower(cfg.Format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(out, opts)), out, nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(out, opts)), out, nil
+	}
+	out.Close()
+	return nil, nil, fmt.Errorf("invalid log format %q, want %s or %s", cfg.Format, FormatText, FormatJSON)
+}
+
+// Discard is a logger that drops everything, for tests and callers that don't want logs
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
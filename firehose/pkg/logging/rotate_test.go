@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a now func that only moves when told to
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func openTestFile(t *testing.T, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, *testClock, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "logs", "guzzle.log")
+	f, err := OpenRotatingFile(path, maxSize, maxAge, maxBackups)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	clock := &testClock{t: time.Date(2024, 12, 16, 12, 0, 0, 0, time.UTC)}
+	f.now = clock.now
+	f.startedAt = clock.now()
+	return f, clock, path
+}
+
+func readBackups(t *testing.T, f *RotatingFile) []string {
+	t.Helper()
+	backups, err := f.backups()
+	require.NoError(t, err)
+	var contents []string
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup)
+		require.NoError(t, err)
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	f, clock, path := openTestFile(t, 10, 0, 0)
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+		clock.advance(time.Millisecond)
+	}
+
+	// the third line didn't fit, lines aren't split across files
+	assert.Equal(t, []string{"aaaa\nbbbb\n"}, readBackups(t, f))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "cccc\n", string(current))
+}
+
+func TestRotatingFileRotatesByAge(t *testing.T) {
+	f, clock, path := openTestFile(t, 0, time.Hour, 0)
+
+	_, err := f.Write([]byte("old\n"))
+	require.NoError(t, err)
+	clock.advance(59 * time.Minute)
+	_, err = f.Write([]byte("still fresh\n"))
+	require.NoError(t, err)
+	assert.Empty(t, readBackups(t, f))
+
+	clock.advance(time.Minute)
+	_, err = f.Write([]byte("new\n"))
+	require.NoError(t, err)
+
+	backups, err := f.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "guzzle-20241216T130000.000.log"), backups[0])
+	assert.Equal(t, []string{"old\nstill fresh\n"}, readBackups(t, f))
+}
+
+func TestRotatingFileKeepsMaxBackups(t *testing.T) {
+	f, clock, path := openTestFile(t, 1, 0, 2)
+	// not a backup, so never pruned
+	other := filepath.Join(filepath.Dir(path), "guzzle-notes.log")
+	require.NoError(t, os.WriteFile(other, []byte("keep me"), 0644))
+
+	for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+		clock.advance(time.Second)
+	}
+
+	assert.Equal(t, []string{"3\n", "4\n"}, readBackups(t, f))
+	assert.FileExists(t, other)
+}
+
+func TestRotatingFileAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guzzle.log")
+	require.NoError(t, os.WriteFile(path, []byte("from the last run\n"), 0644))
+
+	f, err := OpenRotatingFile(path, 1024, 0, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("this run\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "from the last run\nthis run\n", string(data))
+
+	_, err = f.Write([]byte("after close\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guzzle.log")
+	logger, closer, err := New(Config{Path: path, Format: FormatJSON, Level: "warn"})
+	require.NoError(t, err)
+
+	logger.Info("dropped")
+	logger.Warn("kept", "did", "did:plc:a")
+	require.NoError(t, closer.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"level":"WARN"`)
+	assert.Contains(t, lines[0], `"did":"did:plc:a"`)
+
+	_, _, err = New(Config{Format: "xml", Level: "info"})
+	assert.ErrorContains(t, err, "invalid log format")
+	_, _, err = New(Config{Format: FormatText, Level: "loud"})
+	assert.ErrorContains(t, err, "invalid log level")
+}
@@ -0,0 +1,275 @@
+// Package replay reads events back out of files so they can be fed through guzzle again:
+// the sample files in db/test/data/samples, JSONL dumps and the event archive
+package replay
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"firehose/pkg/archive"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultDID is credited with bare post records, which don't say who wrote them
+const DefaultDID = "did:web:replay.invalid"
+
+// layout of created_at in rows exported from the posts table
+const rowTimeLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+// Options selects which events are read
+type Options struct {
+	// Events before FromUS or after ToUS are skipped, 0 leaves either end open
+	FromUS int64
+	ToUS   int64
+	// DID bare post records are credited to, DefaultDID when empty
+	DID string
+}
+
+// DecodeError is a value in a file that isn't an event in any known format.
+// Read reports it to the callback so the rest of the file can still be read.
+type DecodeError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at byte %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Read calls fn with each event in path, in file order. path can be
+//   - an event archive directory, read through its index
+//   - a .zst file, such as an archive segment, holding any of the formats below
+//   - a file of JSON values, one per line, pretty printed or comma separated as in an array:
+//     jetstream events, rows exported from the posts table with the record in "data",
+//     or bare app.bsky.feed.post records, which become creates by opts.DID
+//
+// A value that can't be decoded is passed to fn as a *DecodeError with a nil event,
+// returning the error from fn stops reading, returning nil skips the value.
+func Read(path string, opts Options, fn func(*models.Event, error) error) error {
+	if opts.DID == "" {
+		opts.DID = DefaultDID
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return archive.Read(path, opts.FromUS, opts.ToUS, func(evt *models.Event) error {
+			return fn(evt, nil)
+		})
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if filepath.Ext(path) == ".zst" {
+		decoder, err := zstd.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+
+	scanner := newValueScanner(r)
+	for scanner.Scan() {
+		evt, err := decodeEvent(scanner.Value(), opts.DID)
+		if err != nil {
+			if err := fn(nil, &DecodeError{Path: path, Offset: scanner.Offset(), Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		if (opts.FromUS > 0 && evt.TimeUS < opts.FromUS) || (opts.ToUS > 0 && evt.TimeUS > opts.ToUS) {
+			continue
+		}
+		if err := fn(evt, nil); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &DecodeError{Path: path, Offset: scanner.Offset(), Err: err}
+	}
+	return nil
+}
+
+// probe holds the fields that tell the formats apart
+type probe struct {
+	Kind      string  `json:"kind"`
+	Type      string  `json:"$type"`
+	Did       string  `json:"did"`
+	PostID    string  `json:"post_id"`
+	CreatedAt string  `json:"created_at"`
+	Data      *string `json:"data"`
+}
+
+// decodeEvent turns a JSON value in any of the accepted formats into an event
+func decodeEvent(value []byte, did string) (*models.Event, error) {
+	var p probe
+	if err := json.Unmarshal(value, &p); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.Kind != "":
+		var evt models.Event
+		if err := json.Unmarshal(value, &evt); err != nil {
+			return nil, err
+		}
+		return &evt, nil
+	case p.Data != nil && p.Did != "" && p.PostID != "":
+		record := json.RawMessage(*p.Data)
+		timeUS, err := recordTime(record)
+		// the row's own timestamp when the record doesn't have a usable one
+		if createdAt, rowErr := time.Parse(rowTimeLayout, p.CreatedAt); err != nil && rowErr == nil {
+			timeUS, err = createdAt.UnixMicro(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return createEvent(p.Did, p.PostID, timeUS, record), nil
+	case p.Type == jetstream.PostCollection:
+		timeUS, err := recordTime(value)
+		if err != nil {
+			return nil, err
+		}
+		// the same record always gets the same key, so replaying a file twice doesn't duplicate it
+		sum := sha256.Sum256(value)
+		rkey := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:8]))
+		return createEvent(did, rkey, timeUS, json.RawMessage(value)), nil
+	}
+	return nil, errors.New("not a jetstream event, exported post row or post record")
+}
+
+// recordTime is the time_us a post record would have been sent at, going by its createdAt
+func recordTime(record []byte) (int64, error) {
+	var post struct {
+		CreatedAt time.Time `json:"createdAt"`
+	}
+	if err := json.Unmarshal(record, &post); err != nil {
+		return 0, fmt.Errorf("invalid post record: %w", err)
+	}
+	if post.CreatedAt.IsZero() {
+		return 0, errors.New("post record has no createdAt")
+	}
+	return post.CreatedAt.UnixMicro(), nil
+}
+
+func createEvent(did string, rkey string, timeUS int64, record json.RawMessage) *models.Event {
+	return &models.Event{
+		Did:    did,
+		TimeUS: timeUS,
+		Kind:   models.EventKindCommit,
+		Commit: &models.Commit{
+			Operation:  models.CommitOperationCreate,
+			Collection: jetstream.PostCollection,
+			RKey:       rkey,
+			Record:     record,
+		},
+	}
+}
+
+// valueScanner splits a stream into top level JSON objects. Whitespace, commas and array brackets
+// between them are skipped, which covers JSONL, pretty printed values and arrays alike.
+type valueScanner struct {
+	r      *bufio.Reader
+	value  []byte
+	offset int64
+	start  int64
+	err    error
+}
+
+func newValueScanner(r io.Reader) *valueScanner {
+	return &valueScanner{r: bufio.NewReader(r)}
+}
+
+// Value returns the last object scanned, it isn't reused by the next Scan
+func (s *valueScanner) Value() []byte {
+	return s.value
+}
+
+// Offset returns the byte offset the last object started at
+func (s *valueScanner) Offset() int64 {
+	return s.start
+}
+
+func (s *valueScanner) Err() error {
+	return s.err
+}
+
+func (s *valueScanner) Scan() bool {
+	// a fresh buffer each time, decoded events keep their record as a slice of it
+	s.value = nil
+	for {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.offset++
+		if b == '{' {
+			break
+		}
+		if !strings.ContainsRune(" \t\r\n,[]", rune(b)) {
+			s.start = s.offset - 1
+			s.err = fmt.Errorf("unexpected %q between values", b)
+			return false
+		}
+	}
+
+	s.start = s.offset - 1
+	s.value = append(s.value, '{')
+	depth, inString, escaped := 1, false, false
+	for depth > 0 {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			s.err = errors.New("file ends inside a value")
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.offset++
+		s.value = append(s.value, b)
+
+		switch {
+		case escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case b == '"':
+			inString = !inString
+		case !inString && (b == '{' || b == '['):
+			depth++
+		case !inString && (b == '}' || b == ']'):
+			depth--
+		}
+	}
+	return true
+}
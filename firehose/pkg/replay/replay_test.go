@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"firehose/pkg/archive"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var samplesDir = filepath.Join("..", "..", "db", "test", "data", "samples")
+
+// readFile collects the events and decode errors Read finds in path
+func readFile(t *testing.T, path string, opts Options) ([]*models.Event, []error) {
+	t.Helper()
+	var events []*models.Event
+	var decodeErrs []error
+	err := Read(path, opts, func(evt *models.Event, err error) error {
+		if err != nil {
+			decodeErrs = append(decodeErrs, err)
+			return nil
+		}
+		events = append(events, evt)
+		return nil
+	})
+	require.NoError(t, err)
+	return events, decodeErrs
+}
+
+func TestReadSampleFormats(t *testing.T) {
+	tests := []struct {
+		file   string
+		events int
+		// every event was synthesized from a record, by DID when it's set
+		did string
+	}{
+		// JSONL jetstream events
+		{file: "jetstream-samples-with-tags.json", events: 143},
+		// pretty printed jetstream events separated by commas
+		{file: "jetstream-samples-with-tags-tiny.json", events: 2},
+		{file: "jetstream-sample.json", events: 1},
+		// rows exported from the posts table, comma separated
+		{file: "guzzle-sample-with-tags-tiny.json", events: 10},
+		// bare post records
+		{file: "sample-post-commits.json", events: 302, did: DefaultDID},
+		{file: "jetstream-sample-2.json", events: 1, did: DefaultDID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			events, decodeErrs := readFile(t, filepath.Join(samplesDir, tt.file), Options{})
+			assert.Empty(t, decodeErrs)
+			require.Len(t, events, tt.events)
+			for _, evt := range events {
+				assert.NotEmpty(t, evt.Did)
+				assert.Positive(t, evt.TimeUS)
+				require.NotNil(t, evt.Commit)
+				assert.NotEmpty(t, evt.Commit.RKey)
+				if tt.did != "" {
+					assert.Equal(t, tt.did, evt.Did)
+				}
+				if evt.Commit.Collection == jetstream.PostCollection && evt.Commit.Operation == models.CommitOperationCreate {
+					_, err := jetstream.ExtractPost(evt)
+					assert.NoError(t, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadExportedRows(t *testing.T) {
+	events, _ := readFile(t, filepath.Join(samplesDir, "guzzle-sample-with-tags-tiny.json"), Options{})
+	require.NotEmpty(t, events)
+
+	evt := events[0]
+	assert.Equal(t, "did:plc:xo5agehbphpv6ax6exgrc5jd", evt.Did)
+	assert.Equal(t, "3ld6mupsxjc2q", evt.Commit.RKey)
+	assert.Equal(t, models.CommitOperationCreate, evt.Commit.Operation)
+	post, err := jetstream.ExtractPost(evt)
+	require.NoError(t, err)
+	assert.Equal(t, post.CreatedAt.UnixMicro(), evt.TimeUS)
+	assert.Contains(t, post.Tags, "cyberpunk")
+}
+
+func TestBareRecordsGetStableKeys(t *testing.T) {
+	path := filepath.Join(samplesDir, "sample-post-commits.json")
+	first, _ := readFile(t, path, Options{DID: "did:plc:rayleightestreplay"})
+	second, _ := readFile(t, path, Options{})
+
+	keys := map[string]bool{}
+	for i := range first {
+		assert.Equal(t, "did:plc:rayleightestreplay", first[i].Did)
+		assert.Equal(t, first[i].Commit.RKey, second[i].Commit.RKey)
+		keys[first[i].Commit.RKey] = true
+	}
+	// the sample holds a few identical records
+	assert.Greater(t, len(keys), len(first)*9/10)
+}
+
+func TestReadTimeRange(t *testing.T) {
+	path := filepath.Join(samplesDir, "jetstream-samples-with-tags.json")
+	all, _ := readFile(t, path, Options{})
+	from, to := all[10].TimeUS, all[20].TimeUS
+
+	events, _ := readFile(t, path, Options{FromUS: from, ToUS: to})
+	require.NotEmpty(t, events)
+	for _, evt := range events {
+		assert.GreaterOrEqual(t, evt.TimeUS, from)
+		assert.LessOrEqual(t, evt.TimeUS, to)
+	}
+}
+
+func TestReadReportsMalformedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mixed.json")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join([]string{
+		`{"did":"did:plc:rayleightesta","time_us":1,"kind":"identity"}`,
+		`{"hello":"world"}`,
+		`{"$type":"app.bsky.feed.post","text":"no createdAt"}`,
+		`{"did":"did:plc:rayleightestb","time_us":2,"kind":"account"}`,
+	}, "\n")), 0644))
+
+	events, decodeErrs := readFile(t, path, Options{})
+	assert.Len(t, events, 2)
+	require.Len(t, decodeErrs, 2)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(decodeErrs[0], &decodeErr))
+	assert.Equal(t, path, decodeErr.Path)
+	assert.Positive(t, decodeErr.Offset)
+
+	// a value that never closes can't be skipped, the rest of the file is unreadable
+	err := Read(filepath.Join(samplesDir, "post-with-tag.json"), Options{}, func(*models.Event, error) error { return nil })
+	assert.ErrorAs(t, err, &decodeErr)
+}
+
+func TestReadArchives(t *testing.T) {
+	path := filepath.Join(samplesDir, "jetstream-samples-with-tags.json")
+	events, _ := readFile(t, path, Options{})
+
+	dir := t.TempDir()
+	w, err := archive.Open(archive.Config{Dir: dir, SegmentSize: 16 * 1024})
+	require.NoError(t, err)
+	for _, evt := range events {
+		require.NoError(t, w.Write(evt))
+	}
+	require.NoError(t, w.Close())
+
+	// the whole archive through its index
+	archived, _ := readFile(t, dir, Options{FromUS: events[50].TimeUS})
+	require.NotEmpty(t, archived)
+	assert.Equal(t, events[50].TimeUS, archived[0].TimeUS)
+	assert.Equal(t, events[len(events)-1].TimeUS, archived[len(archived)-1].TimeUS)
+
+	// a single segment
+	segment := w.Index().Segments[0]
+	segmentEvents, decodeErrs := readFile(t, filepath.Join(dir, segment.File), Options{})
+	assert.Empty(t, decodeErrs)
+	assert.Len(t, segmentEvents, int(segment.Events))
+
+	// any of the sample formats compressed
+	raw, err := os.ReadFile(filepath.Join(samplesDir, "guzzle-sample-with-tags-tiny.json"))
+	require.NoError(t, err)
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	compressed := filepath.Join(t.TempDir(), "rows.json.zst")
+	require.NoError(t, os.WriteFile(compressed, encoder.EncodeAll(raw, nil), 0644))
+	rows, _ := readFile(t, compressed, Options{})
+	assert.Len(t, rows, 10)
+}
@@ -0,0 +1,112 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"firehose/pkg/db/query"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// posts deleted per purge query, so a large account doesn't hold locks for long
+const purgeBatchSize = 1000
+
+// saveAccountStatus records whether an account is active. The API hides the posts of inactive accounts,
+// they reappear if the account is reactivated, unless it was deleted and its posts were purged.
+func (g *Guzzle) saveAccountStatus(ctx context.Context, evt *models.Event) error {
+	if evt.Account == nil {
+		return errors.New("account event without an account")
+	}
+	g.archiveKept(evt)
+
+	status := ""
+	if evt.Account.Status != nil {
+		status = *evt.Account.Status
+	}
+	start := time.Now()
+	err := query.New(g.db).SaveAccountStatus(ctx, query.SaveAccountStatusParams{
+		Did:    evt.Did,
+		Active: evt.Account.Active,
+		Status: status,
+		TimeUs: evt.TimeUS,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(querySaveAccountStatus).Inc()
+		g.eventLogger(evt).Error("Failed to save account status", "latency", time.Since(start), "error", err)
+		return err
+	}
+	g.metrics.accountsUpdated.WithLabelValues(models.EventKindAccount).Inc()
+	g.eventLogger(evt).Info("Account status saved", "active", evt.Account.Active, "status", status)
+	return nil
+}
+
+// saveAccountHandle records an account's current handle from an identity event, refreshing the bluesky.Resolver cache
+func (g *Guzzle) saveAccountHandle(ctx context.Context, evt *models.Event) error {
+	if evt.Identity == nil {
+		return errors.New("identity event without an identity")
+	}
+	g.archiveKept(evt)
+
+	handle := sql.NullString{}
+	if evt.Identity.Handle != nil && *evt.Identity.Handle != "" {
+		handle = sql.NullString{String: *evt.Identity.Handle, Valid: true}
+	}
+	start := time.Now()
+	err := query.New(g.db).SaveAccountHandle(ctx, query.SaveAccountHandleParams{
+		Did:    evt.Did,
+		Handle: handle,
+		TimeUs: evt.TimeUS,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(querySaveAccountHandle).Inc()
+		g.eventLogger(evt).Error("Failed to save account handle", "latency", time.Since(start), "error", err)
+		return err
+	}
+	g.metrics.accountsUpdated.WithLabelValues(models.EventKindIdentity).Inc()
+	g.eventLogger(evt).Debug("Account handle saved", "handle", handle.String)
+	return nil
+}
+
+// PurgeDeletedAccounts hard deletes every stored post of a deleted account, returning how many went
+func (g *Guzzle) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		purged, err := query.New(g.db).PurgeDeletedAccountPosts(ctx, purgeBatchSize)
+		if err != nil {
+			g.metrics.dbErrors.WithLabelValues(queryPurgePosts).Inc()
+			return total, err
+		}
+		total += purged
+		g.metrics.postsPurged.Add(float64(purged))
+		if purged < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// purgeDeletedAccounts purges the posts of deleted accounts every interval until the context is cancelled.
+// Posts of a deleted account can still arrive after its account event, from a backfill or a slow worker,
+// so purging periodically rather than once per event catches those too.
+func (g *Guzzle) purgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := g.PurgeDeletedAccounts(ctx)
+			if err != nil && ctx.Err() == nil {
+				g.logger.Error("Failed to purge posts of deleted accounts", "purged", purged, "error", err)
+				continue
+			}
+			if purged > 0 {
+				g.logger.Info("Purged posts of deleted accounts", "purged", purged)
+			}
+		}
+	}
+}
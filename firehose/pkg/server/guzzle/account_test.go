@@ -0,0 +1,124 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"firehose/pkg/db/query"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchAccountTag returns the DIDs of the posts the API's tag search finds in the fixture's tag
+func searchAccountTag(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	rows, err := query.New(db).GetRecentRootPostsByTags(context.Background(), query.GetRecentRootPostsByTagsParams{
+		TagNames:     []string{"rayleightestaccount"},
+		CreatedAfter: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		RowLimit:     10,
+	})
+	require.NoError(t, err)
+	var dids []string
+	for _, row := range rows {
+		dids = append(dids, row.CreatorDid)
+	}
+	return dids
+}
+
+func TestAccountEventsHidePosts(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+
+	events := loadSampleEvents(t, "jetstream-account-events.json")
+	require.Len(t, events, 7)
+	removeTestPosts(t, db, events)
+	a, b := events[0], events[1]
+
+	require.NoError(t, g.handleEvent(ctx, a))
+	require.NoError(t, g.handleEvent(ctx, b))
+	assert.ElementsMatch(t, []string{a.Did, b.Did}, searchAccountTag(t, db))
+
+	// identity events record the handle
+	require.NoError(t, g.handleEvent(ctx, events[2]))
+	account, err := query.New(db).GetAccount(ctx, a.Did)
+	require.NoError(t, err)
+	assert.True(t, account.Active)
+	assert.Equal(t, "sketcher.rayleigh.test", account.Handle.String)
+
+	// a deactivated account's posts are hidden, not deleted
+	require.NoError(t, g.handleEvent(ctx, events[3]))
+	assert.Equal(t, []string{b.Did}, searchAccountTag(t, db))
+	_, err = query.New(db).GetPost(ctx, query.GetPostParams{CreatorDid: a.Did, PostID: a.Commit.RKey})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.Equal(t, 1, countStoredPosts(t, db, a))
+
+	// an older event replayed late doesn't undo a newer one
+	stale := *events[5]
+	stale.TimeUS = events[3].TimeUS - 1
+	require.NoError(t, g.handleEvent(ctx, &stale))
+	account, err = query.New(db).GetAccount(ctx, a.Did)
+	require.NoError(t, err)
+	assert.False(t, account.Active)
+	assert.Equal(t, "deactivated", account.Status)
+
+	// reactivating shows them again
+	require.NoError(t, g.handleEvent(ctx, events[5]))
+	assert.ElementsMatch(t, []string{a.Did, b.Did}, searchAccountTag(t, db))
+	_, err = query.New(db).GetPost(ctx, query.GetPostParams{CreatorDid: a.Did, PostID: a.Commit.RKey})
+	assert.NoError(t, err)
+
+	// an identity event without a handle clears it
+	require.NoError(t, g.handleEvent(ctx, events[6]))
+	account, err = query.New(db).GetAccount(ctx, a.Did)
+	require.NoError(t, err)
+	assert.False(t, account.Handle.Valid)
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.accountsUpdated.WithLabelValues(models.EventKindIdentity)))
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+
+	events := loadSampleEvents(t, "jetstream-account-events.json")
+	removeTestPosts(t, db, events)
+	a, b := events[0], events[1]
+
+	for _, evt := range events[:5] {
+		require.NoError(t, g.handleEvent(ctx, evt))
+	}
+
+	// only the deleted account's posts go, the tag no other post uses goes with the next sweep
+	purged, err := g.PurgeDeletedAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Equal(t, 0, countStoredPosts(t, db, b))
+	assert.Equal(t, 1, countStoredPosts(t, db, a))
+	_, err = g.SweepOrphanedTags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, countTags(t, db, "rayleightestgone"))
+	assert.Equal(t, 1, countTags(t, db, "rayleightestaccount"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.postsPurged))
+
+	// a post arriving after the account was deleted is purged on the next run
+	require.NoError(t, g.handleEvent(ctx, b))
+	assert.Equal(t, 1, countStoredPosts(t, db, b))
+	_, err = g.PurgeDeletedAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, countStoredPosts(t, db, b))
+}
+
+func TestAccountEventsWithoutPayloadFail(t *testing.T) {
+	g := newTestGuzzle(nil)
+
+	// nothing reaches the (nil) db
+	assert.Error(t, g.processEvent(context.Background(), &models.Event{Did: "did:plc:a", Kind: models.EventKindAccount}))
+	assert.Error(t, g.processEvent(context.Background(), &models.Event{Did: "did:plc:a", Kind: models.EventKindIdentity}))
+}
@@ -0,0 +1,23 @@
+package guzzle
+
+import (
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// archiveEvent writes an event to the local archive. A failed write is logged and counted
+// rather than returned, the archive shouldn't hold up ingestion.
+func (g *Guzzle) archiveEvent(evt *models.Event) {
+	if err := g.archive.Write(evt); err != nil {
+		g.metrics.archiveErrors.Inc()
+		g.eventLogger(evt).Error("Failed to archive event", "error", err)
+		return
+	}
+	g.metrics.eventsArchived.Inc()
+}
+
+// archiveKept archives an event that passed the filter when only those are archived
+func (g *Guzzle) archiveKept(evt *models.Event) {
+	if g.archive != nil && g.config.ArchiveKeptOnly {
+		g.archiveEvent(evt)
+	}
+}
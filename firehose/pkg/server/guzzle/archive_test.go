@@ -0,0 +1,96 @@
+package guzzle
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"firehose/pkg/archive"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestArchive(t *testing.T, g *Guzzle) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "archive")
+	w, err := archive.Open(archive.Config{Dir: dir})
+	require.NoError(t, err)
+	g.config.ArchiveDir = dir
+	g.archive = w
+	return dir
+}
+
+func readArchive(t *testing.T, dir string) []*models.Event {
+	t.Helper()
+	var events []*models.Event
+	require.NoError(t, archive.Read(dir, 0, 0, func(evt *models.Event) error {
+		events = append(events, evt)
+		return nil
+	}))
+	return events
+}
+
+func TestArchiveEveryEvent(t *testing.T) {
+	g := newTestGuzzle(nil)
+	dir := openTestArchive(t, g)
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	scheduler := g.newScheduler().(*observedScheduler)
+	// archived as the reader hands events over, before any processing
+	scheduler.Scheduler = newDIDScheduler(1, len(events), func(context.Context, *models.Event) error { return nil }, func(int64) {})
+	for _, evt := range events {
+		require.NoError(t, scheduler.AddWork(context.Background(), evt.Did, evt))
+	}
+	scheduler.Shutdown()
+	require.NoError(t, g.archive.Close())
+
+	archived := readArchive(t, dir)
+	require.Len(t, archived, len(events))
+	for i := range events {
+		assert.Equal(t, events[i].TimeUS, archived[i].TimeUS)
+	}
+	assert.Equal(t, float64(len(events)), testutil.ToFloat64(g.metrics.eventsArchived))
+}
+
+func TestArchiveKeptOnly(t *testing.T) {
+	g := newTestGuzzle(closedDB(t))
+	g.config.ArchiveKeptOnly = true
+	dir := openTestArchive(t, g)
+	events := loadSampleEvents(t, "jetstream-samples-with-tags.json")
+
+	// the reader doesn't archive when only kept posts are
+	assert.Nil(t, g.newScheduler().(*observedScheduler).archive)
+
+	var want []int64
+	for _, evt := range events {
+		if evt.Kind != models.EventKindCommit || evt.Commit == nil || evt.Commit.Collection != jetstream.PostCollection {
+			continue
+		}
+		if evt.Commit.Operation != models.CommitOperationCreate {
+			want = append(want, evt.TimeUS)
+			continue
+		}
+		post, err := jetstream.ExtractPost(evt)
+		if err == nil && g.filter.reason(evt.Did, post) == "" {
+			want = append(want, evt.TimeUS)
+		}
+	}
+	require.NotEmpty(t, want)
+	require.Less(t, len(want), len(events))
+
+	for _, evt := range events {
+		// the database is closed, kept posts fail to save after they're archived
+		g.handleEvent(context.Background(), evt)
+	}
+	require.NoError(t, g.archive.Close())
+
+	var got []int64
+	for _, evt := range readArchive(t, dir) {
+		got = append(got, evt.TimeUS)
+	}
+	assert.Equal(t, want, got)
+}
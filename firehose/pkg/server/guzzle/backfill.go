@@ -0,0 +1,68 @@
+package guzzle
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// the backfill has caught up once the cursor is this close to now
+	backfillCaughtUpLag = 10 * time.Second
+	backfillLogInterval = 30 * time.Second
+)
+
+// startBackfill notes a run starting from a cursor in the past, warning when it's further back than jetstream keeps
+func (g *Guzzle) startBackfill(fromUS int64) {
+	from := time.UnixMicro(fromUS)
+	behind := time.Since(from)
+	g.logger.Info("Backfill starting", "from", from.Format(time.RFC3339), "behind", behind.Round(time.Second))
+
+	if behind > g.config.ReplayWindow {
+		g.logger.Warn("Cursor is older than jetstream's replay window, earlier events can't be replayed",
+			"from", from.Format(time.RFC3339), "replay_window", g.config.ReplayWindow,
+			"replayable_from", time.Now().Add(-g.config.ReplayWindow).Format(time.RFC3339))
+	}
+	if behind > backfillCaughtUpLag {
+		g.backfilling.Store(true)
+		g.metrics.backfillBehind.Set(behind.Seconds())
+	}
+}
+
+// trackBackfill reports the backfill's progress every interval until it catches up or the context is cancelled
+func (g *Guzzle) trackBackfill(ctx context.Context, fromUS int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	startedAt := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if g.reportBackfill(fromUS, startedAt) {
+				return
+			}
+		}
+	}
+}
+
+// reportBackfill logs and exposes how far behind live the cursor is, returning true once it has caught up
+func (g *Guzzle) reportBackfill(fromUS int64, startedAt time.Time) bool {
+	now := time.Now()
+	cursor := g.cursor.Load()
+	behind := now.Sub(time.UnixMicro(cursor))
+
+	if behind <= backfillCaughtUpLag {
+		g.backfilling.Store(false)
+		g.metrics.backfillBehind.Set(0)
+		g.logger.Info("Backfill caught up with the live stream", "took", now.Sub(startedAt).Round(time.Second))
+		return true
+	}
+
+	g.metrics.backfillBehind.Set(behind.Seconds())
+	done := float64(cursor-fromUS) / float64(now.UnixMicro()-fromUS) * 100
+	g.logger.Info("Backfill progress", "at", time.UnixMicro(cursor).Format(time.RFC3339),
+		"behind", behind.Round(time.Second), "percent_done", math.Round(done*10)/10)
+	return false
+}
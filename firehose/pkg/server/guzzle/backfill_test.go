@@ -0,0 +1,66 @@
+package guzzle
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartBackfillWarnsPastReplayWindow(t *testing.T) {
+	var logs bytes.Buffer
+	g := newTestGuzzle(nil)
+	g.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	g.startBackfill(time.Now().Add(-2 * time.Hour).UnixMicro())
+	assert.True(t, g.backfilling.Load())
+	assert.InDelta(t, 2*time.Hour.Seconds(), testutil.ToFloat64(g.metrics.backfillBehind), 5)
+	assert.NotContains(t, logs.String(), "level=WARN")
+
+	g.startBackfill(time.Now().Add(-48 * time.Hour).UnixMicro())
+	assert.Contains(t, logs.String(), "level=WARN")
+	assert.Contains(t, logs.String(), "replay_window=24h0m0s")
+}
+
+func TestStartBackfillNearLiveIsNotBackfilling(t *testing.T) {
+	g := newTestGuzzle(nil)
+
+	g.startBackfill(time.Now().Add(-time.Second).UnixMicro())
+	assert.False(t, g.backfilling.Load())
+	assert.Zero(t, testutil.ToFloat64(g.metrics.backfillBehind))
+}
+
+func TestReportBackfillUntilCaughtUp(t *testing.T) {
+	var logs bytes.Buffer
+	// health pings the database, a closed one just reports it unreachable
+	g := newTestGuzzle(closedDB(t))
+	g.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	from := time.Now().Add(-time.Hour).UnixMicro()
+	g.cursor.Store(from)
+	g.startBackfill(from)
+	startedAt := time.Now()
+
+	// halfway there
+	g.trackCursor(time.Now().Add(-30 * time.Minute).UnixMicro())
+	assert.False(t, g.reportBackfill(from, startedAt))
+	assert.InDelta(t, 30*time.Minute.Seconds(), testutil.ToFloat64(g.metrics.backfillBehind), 5)
+	assert.Contains(t, logs.String(), "percent_done=50")
+
+	_, status := getHealth(t, g.handleHealthz, "/healthz")
+	require.NotNil(t, status.BackfillBehindSeconds)
+	assert.InDelta(t, 30*time.Minute.Seconds(), *status.BackfillBehindSeconds, 5)
+
+	g.trackCursor(time.Now().UnixMicro())
+	assert.True(t, g.reportBackfill(from, startedAt))
+	assert.False(t, g.backfilling.Load())
+	assert.Zero(t, testutil.ToFloat64(g.metrics.backfillBehind))
+	assert.Contains(t, logs.String(), "Backfill caught up")
+
+	_, status = getHealth(t, g.handleHealthz, "/healthz")
+	assert.Nil(t, status.BackfillBehindSeconds)
+}
@@ -0,0 +1,263 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"firehose/pkg/db/query"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultFlushInterval = time.Second
+	// batches' worth of posts kept while the database is failing, past that new posts are refused
+	maxPendingBatches = 10
+)
+
+// errBatcherFull refuses a post while too many are waiting for the database, so it's dead lettered instead
+var errBatcherFull = errors.New("too many posts waiting to be written")
+
+// postBatcher buffers qualifying posts and writes them with a single CreatePostsWithTags
+// round trip once the batch is full or the flush interval passes
+type postBatcher struct {
+	db      *sql.DB
+	size    int
+	metrics *metrics
+	// keeps the event of a post the database refuses
+	deadLetter func(context.Context, *models.Event, error)
+
+	mu      sync.Mutex
+	pending []batchedPost
+	// held by a flush from taking the buffered posts until they're written or put back,
+	// so a flush started meanwhile waits for them rather than finding nothing to write
+	flushMu sync.Mutex
+}
+
+// batchedPost is a buffered post along with the event it came from
+type batchedPost struct {
+	evt    *models.Event
+	params query.CreatePostWithTagsParams
+}
+
+func newPostBatcher(db *sql.DB, size int, m *metrics, deadLetter func(context.Context, *models.Event, error)) *postBatcher {
+	return &postBatcher{
+		db:         db,
+		size:       size,
+		metrics:    m,
+		deadLetter: deadLetter,
+		pending:    make([]batchedPost, 0, size),
+	}
+}
+
+// add buffers a post, flushing if that fills the batch
+func (b *postBatcher) add(ctx context.Context, evt *models.Event, post query.CreatePostWithTagsParams) error {
+	b.mu.Lock()
+	if len(b.pending) >= b.size*maxPendingBatches {
+		b.mu.Unlock()
+		return errBatcherFull
+	}
+	b.pending = append(b.pending, batchedPost{evt: evt, params: post})
+	full := len(b.pending) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// contains reports whether a post is waiting to be written
+func (b *postBatcher) contains(creatorDid string, postID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, post := range b.pending {
+		if post.params.CreatorDid == creatorDid && post.params.PostID == postID {
+			return true
+		}
+	}
+	return false
+}
+
+// discard drops a buffered post that a later update or delete supersedes
+func (b *postBatcher) discard(creatorDid string, postID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.pending[:0]
+	for _, post := range b.pending {
+		if post.params.CreatorDid != creatorDid || post.params.PostID != postID {
+			kept = append(kept, post)
+		}
+	}
+	b.pending = kept
+}
+
+// flush writes every buffered post. When the database refuses the batch because of what's in it,
+// the posts are written one at a time and those it still refuses are dead lettered, so one bad post
+// doesn't hold up the rest. Any other failure puts the unwritten posts back to be retried by the next flush.
+func (b *postBatcher) flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = make([]batchedPost, 0, b.size)
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	posts := make([]query.CreatePostWithTagsParams, len(batch))
+	for i, post := range batch {
+		posts[i] = post.params
+	}
+	err := query.New(b.db).CreatePostsWithTags(ctx, batchParams(posts))
+	if err == nil {
+		b.metrics.postsPersisted.WithLabelValues(models.CommitOperationCreate).Add(float64(len(batch)))
+		return nil
+	}
+	b.metrics.dbErrors.WithLabelValues(queryCreatePosts).Inc()
+	if !refusedByDatabase(err) {
+		b.requeue(batch)
+		return fmt.Errorf("failed to write batch of %d posts, %w: %w", len(batch), errBatchRequeued, err)
+	}
+
+	for i, post := range batch {
+		err := query.New(b.db).CreatePostWithTags(ctx, post.params)
+		if err == nil {
+			b.metrics.postsPersisted.WithLabelValues(models.CommitOperationCreate).Inc()
+			continue
+		}
+		b.metrics.dbErrors.WithLabelValues(queryCreatePost).Inc()
+		if !refusedByDatabase(err) {
+			b.requeue(batch[i:])
+			return fmt.Errorf("failed to write batch of %d posts, %w: %w", len(batch)-i, errBatchRequeued, err)
+		}
+		b.deadLetter(ctx, post.evt, err)
+	}
+	return nil
+}
+
+// requeue puts unwritten posts back ahead of those buffered since
+func (b *postBatcher) requeue(batch []batchedPost) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(batch, b.pending...)
+}
+
+// refusedByDatabase reports whether a write failed because of the values written, such as text too long
+// for its column or a NUL byte, rather than because of the database or the connection
+func refusedByDatabase(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	// a foreign key violation means a row the post links to went away while it was written, not that the post is bad
+	if pqErr.Code == "23503" {
+		return false
+	}
+	switch pqErr.Code.Class() {
+	case "22", "23": // data exception, integrity constraint violation
+		return true
+	}
+	return false
+}
+
+// run flushes on every tick until the context is cancelled
+func (b *postBatcher) run(ctx context.Context, interval time.Duration, logger *slog.Logger) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := b.flush(ctx); err != nil {
+				logger.Error("Failed to flush posts", "error", err)
+			}
+		}
+	}
+}
+
+// batchParams flattens a batch into the column arrays CreatePostsWithTags unnests
+func batchParams(batch []query.CreatePostWithTagsParams) query.CreatePostsWithTagsParams {
+	params := query.CreatePostsWithTagsParams{
+		PostIds:     make([]string, 0, len(batch)),
+		CreatorDids: make([]string, 0, len(batch)),
+		CreatedAts:  make([]time.Time, 0, len(batch)),
+		Texts:       make([]string, 0, len(batch)),
+
+		ReplyRootUris:   make([]string, 0, len(batch)),
+		ReplyRootCids:   make([]string, 0, len(batch)),
+		ReplyParentUris: make([]string, 0, len(batch)),
+		ReplyParentCids: make([]string, 0, len(batch)),
+		Langs:           make([]string, 0, len(batch)),
+	}
+
+	for _, post := range batch {
+		params.PostIds = append(params.PostIds, post.PostID)
+		params.CreatorDids = append(params.CreatorDids, post.CreatorDid)
+		params.CreatedAts = append(params.CreatedAts, post.CreatedAt)
+		params.Texts = append(params.Texts, post.Text)
+		// NullString's zero String is empty, which the query stores as NULL
+		params.ReplyRootUris = append(params.ReplyRootUris, post.ReplyRootUri.String)
+		params.ReplyRootCids = append(params.ReplyRootCids, post.ReplyRootCid.String)
+		params.ReplyParentUris = append(params.ReplyParentUris, post.ReplyParentUri.String)
+		params.ReplyParentCids = append(params.ReplyParentCids, post.ReplyParentCid.String)
+		params.Langs = append(params.Langs, strings.Join(post.Langs, ","))
+
+		for i, tag := range post.Tags {
+			params.LinkPostIds = append(params.LinkPostIds, post.PostID)
+			params.LinkCreatorDids = append(params.LinkCreatorDids, post.CreatorDid)
+			params.LinkTags = append(params.LinkTags, tag)
+			source := jetstream.TagSourceFacet
+			if i < len(post.TagSources) {
+				source = post.TagSources[i]
+			}
+			params.LinkTagSources = append(params.LinkTagSources, source)
+		}
+		for _, did := range post.Mentions {
+			params.MentionPostIds = append(params.MentionPostIds, post.PostID)
+			params.MentionCreatorDids = append(params.MentionCreatorDids, post.CreatorDid)
+			params.MentionDids = append(params.MentionDids, did)
+		}
+		for i, url := range post.LinkUrls {
+			params.UrlPostIds = append(params.UrlPostIds, post.PostID)
+			params.UrlCreatorDids = append(params.UrlCreatorDids, post.CreatorDid)
+			params.Urls = append(params.Urls, url)
+			params.UrlDomains = append(params.UrlDomains, post.LinkDomains[i])
+		}
+		if post.EmbedType.Valid {
+			params.EmbedPostIds = append(params.EmbedPostIds, post.PostID)
+			params.EmbedCreatorDids = append(params.EmbedCreatorDids, post.CreatorDid)
+			params.EmbedTypes = append(params.EmbedTypes, post.EmbedType.String)
+			params.ExternalUris = append(params.ExternalUris, post.ExternalUri.String)
+			params.ExternalTitles = append(params.ExternalTitles, post.ExternalTitle.String)
+			params.QuotedUris = append(params.QuotedUris, post.QuotedUri.String)
+			params.QuotedCids = append(params.QuotedCids, post.QuotedCid.String)
+		}
+		for i, kind := range post.MediaKinds {
+			params.MediaPostIds = append(params.MediaPostIds, post.PostID)
+			params.MediaCreatorDids = append(params.MediaCreatorDids, post.CreatorDid)
+			// positions are 1-based like the ordinality of the single post queries
+			params.MediaPositions = append(params.MediaPositions, int32(i+1))
+			params.MediaKinds = append(params.MediaKinds, kind)
+			params.MediaCids = append(params.MediaCids, post.MediaCids[i])
+			params.MediaMimeTypes = append(params.MediaMimeTypes, post.MediaMimeTypes[i])
+			params.MediaAlts = append(params.MediaAlts, post.MediaAlts[i])
+		}
+	}
+	return params
+}
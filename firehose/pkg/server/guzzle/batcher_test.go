@@ -0,0 +1,239 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"firehose/pkg/db/query"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchParams(t *testing.T) {
+	createdAt := time.Date(2024, 12, 16, 12, 57, 0, 0, time.UTC)
+	params := batchParams([]query.CreatePostWithTagsParams{
+		{PostID: "3ldgevpyjhk2d", CreatorDid: "did:plc:a", CreatedAt: createdAt, Text: "one", Tags: []string{"art", "sketch"}, Langs: []string{"en", "ja"},
+			Mentions: []string{"did:plc:b", "did:plc:c"}, TagSources: []string{"facet", "text"},
+			LinkUrls: []string{"https://www.example.com/a"}, LinkDomains: []string{"example.com"},
+		},
+		{PostID: "3ldgevq2xk22c", CreatorDid: "did:plc:b", CreatedAt: createdAt, Text: "two", Tags: []string{"art"},
+			ReplyRootUri:   sql.NullString{String: "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d", Valid: true},
+			ReplyParentUri: sql.NullString{String: "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d", Valid: true},
+			EmbedType:      sql.NullString{String: "app.bsky.embed.recordWithMedia", Valid: true},
+			QuotedUri:      sql.NullString{String: "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d", Valid: true},
+			MediaKinds:     []string{"image", "image"},
+			MediaCids:      []string{"bafkreia", "bafkreib"},
+			MediaMimeTypes: []string{"image/jpeg", "image/png"},
+			MediaAlts:      []string{"a cat", ""},
+		},
+	})
+
+	assert.Equal(t, []string{"3ldgevpyjhk2d", "3ldgevq2xk22c"}, params.PostIds)
+	assert.Equal(t, []string{"did:plc:a", "did:plc:b"}, params.CreatorDids)
+	assert.Equal(t, []string{"one", "two"}, params.Texts)
+	assert.Len(t, params.CreatedAts, 2)
+
+	// top level posts get empty reply references, which the query stores as NULL
+	assert.Equal(t, []string{"", "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d"}, params.ReplyRootUris)
+	assert.Equal(t, []string{"", "at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d"}, params.ReplyParentUris)
+	assert.Equal(t, []string{"", ""}, params.ReplyRootCids)
+
+	// nested arrays can't be unnested, so languages are comma separated
+	assert.Equal(t, []string{"en,ja", ""}, params.Langs)
+
+	// one element per (post, tag) pair
+	assert.Equal(t, []string{"3ldgevpyjhk2d", "3ldgevpyjhk2d", "3ldgevq2xk22c"}, params.LinkPostIds)
+	assert.Equal(t, []string{"did:plc:a", "did:plc:a", "did:plc:b"}, params.LinkCreatorDids)
+	assert.Equal(t, []string{"art", "sketch", "art"}, params.LinkTags)
+	// posts without sources have facet tags
+	assert.Equal(t, []string{"facet", "text", "facet"}, params.LinkTagSources)
+
+	// likewise one element per (post, mention) and (post, URL) pair
+	assert.Equal(t, []string{"3ldgevpyjhk2d", "3ldgevpyjhk2d"}, params.MentionPostIds)
+	assert.Equal(t, []string{"did:plc:b", "did:plc:c"}, params.MentionDids)
+	assert.Equal(t, []string{"did:plc:a"}, params.UrlCreatorDids)
+	assert.Equal(t, []string{"https://www.example.com/a"}, params.Urls)
+	assert.Equal(t, []string{"example.com"}, params.UrlDomains)
+
+	// one embed element per post that has an embed, and one media element per image
+	assert.Equal(t, []string{"3ldgevq2xk22c"}, params.EmbedPostIds)
+	assert.Equal(t, []string{"app.bsky.embed.recordWithMedia"}, params.EmbedTypes)
+	assert.Equal(t, []string{""}, params.ExternalUris)
+	assert.Equal(t, []string{"at://did:plc:a/app.bsky.feed.post/3ldgevpyjhk2d"}, params.QuotedUris)
+	assert.Equal(t, []string{"did:plc:b", "did:plc:b"}, params.MediaCreatorDids)
+	assert.Equal(t, []int32{1, 2}, params.MediaPositions)
+	assert.Equal(t, []string{"bafkreia", "bafkreib"}, params.MediaCids)
+	assert.Equal(t, []string{"a cat", ""}, params.MediaAlts)
+}
+
+func TestPostBatcherDiscard(t *testing.T) {
+	ctx := context.Background()
+	b := newPostBatcher(nil, 10, newMetrics(), nil)
+
+	require.NoError(t, b.add(ctx, nil, query.CreatePostWithTagsParams{PostID: "a", CreatorDid: "did:plc:a"}))
+	require.NoError(t, b.add(ctx, nil, query.CreatePostWithTagsParams{PostID: "b", CreatorDid: "did:plc:a"}))
+	require.NoError(t, b.add(ctx, nil, query.CreatePostWithTagsParams{PostID: "a", CreatorDid: "did:plc:b"}))
+
+	b.discard("did:plc:a", "a")
+
+	require.Len(t, b.pending, 2)
+	assert.Equal(t, "b", b.pending[0].params.PostID)
+	assert.Equal(t, "did:plc:b", b.pending[1].params.CreatorDid)
+}
+
+func TestBatchedCreatesAreWrittenOnFlush(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+	g.batcher = newPostBatcher(db, 10, g.metrics, g.saveDeadLetter)
+
+	events := loadSampleEvents(t, "jetstream-delete-events.json")
+	removeTestPosts(t, db, events)
+
+	// the first create is replayed inside the same batch
+	require.NoError(t, g.handleEvent(ctx, events[0]))
+	require.NoError(t, g.handleEvent(ctx, events[0]))
+	require.NoError(t, g.handleEvent(ctx, events[1]))
+	assert.Equal(t, 0, countStoredPosts(t, db, events[0]), "posts are only written on flush")
+
+	require.NoError(t, g.batcher.flush(ctx))
+	assert.Equal(t, 1, countStoredPosts(t, db, events[0]))
+	assert.Equal(t, 1, countStoredPosts(t, db, events[1]))
+	assert.ElementsMatch(t, []string{"rayleightestshared", "rayleightestorphan"}, storedPostTags(t, db, events[0]))
+	assert.Equal(t, []string{"rayleightestshared"}, storedPostTags(t, db, events[1]))
+}
+
+func TestBatchWithARefusedPostWritesTheRest(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	var refused []*models.Event
+	b := newPostBatcher(db, 10, newMetrics(), func(_ context.Context, evt *models.Event, err error) {
+		refused = append(refused, evt)
+	})
+
+	// the second post's embed type is too long for post_embeds.embed_type
+	did := "did:plc:rayleightestbatch"
+	var events []*models.Event
+	for i, embedType := range []string{"app.bsky.embed.images", strings.Repeat("x", 65), ""} {
+		evt := &models.Event{Did: did, Kind: models.EventKindCommit, Commit: &models.Commit{
+			Operation: models.CommitOperationCreate, Collection: jetstream.PostCollection, RKey: fmt.Sprintf("3ldgbatch%04d", i),
+		}}
+		events = append(events, evt)
+		require.NoError(t, b.add(ctx, evt, query.CreatePostWithTagsParams{
+			PostID:     evt.Commit.RKey,
+			CreatorDid: did,
+			CreatedAt:  time.Now(),
+			Text:       "batched #rayleightestbatch",
+			Tags:       []string{"rayleightestbatch"},
+			EmbedType:  sql.NullString{String: embedType, Valid: embedType != ""},
+		}))
+	}
+	removeTestPosts(t, db, events)
+
+	require.NoError(t, b.flush(ctx))
+	assert.Equal(t, 1, countStoredPosts(t, db, events[0]))
+	assert.Equal(t, 0, countStoredPosts(t, db, events[1]))
+	assert.Equal(t, 1, countStoredPosts(t, db, events[2]))
+	assert.Equal(t, []*models.Event{events[1]}, refused)
+	assert.Empty(t, b.pending)
+}
+
+func TestPostBatcherIsBounded(t *testing.T) {
+	ctx := context.Background()
+	b := newPostBatcher(closedDB(t), 2, newMetrics(), nil)
+
+	// an unreachable database keeps every post for the next flush, up to a limit
+	for i := 0; i < 2*maxPendingBatches; i++ {
+		err := b.add(ctx, nil, query.CreatePostWithTagsParams{PostID: fmt.Sprint(i), CreatorDid: "did:plc:a"})
+		if err != nil {
+			require.ErrorIs(t, err, errBatchRequeued)
+		}
+	}
+	assert.Len(t, b.pending, 2*maxPendingBatches)
+	assert.ErrorIs(t, b.add(ctx, nil, query.CreatePostWithTagsParams{PostID: "over", CreatorDid: "did:plc:a"}), errBatcherFull)
+	assert.Len(t, b.pending, 2*maxPendingBatches)
+}
+
+func TestSaveCursorWaitsForAFlushInFlight(t *testing.T) {
+	g := newTestGuzzle(closedDB(t))
+	g.batcher = newPostBatcher(g.db, 10, g.metrics, nil)
+	g.cursor.Store(1734353822220585)
+
+	// a worker's flush has taken the buffered posts and not written them yet
+	g.batcher.flushMu.Lock()
+	batch := []batchedPost{{params: query.CreatePostWithTagsParams{PostID: "a", CreatorDid: "did:plc:a"}}}
+	done := make(chan error, 1)
+	go func() {
+		done <- g.saveCursor(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("cursor saved while a batch was in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the write fails and the batch goes back, so the cursor isn't saved past it
+	g.batcher.requeue(batch)
+	g.batcher.flushMu.Unlock()
+	require.ErrorIs(t, <-done, errBatchRequeued)
+	assert.Len(t, g.batcher.pending, 1)
+}
+
+func TestRefusedByDatabase(t *testing.T) {
+	assert.True(t, refusedByDatabase(fmt.Errorf("wrapped: %w", &pq.Error{Code: "22001"})), "value too long")
+	assert.True(t, refusedByDatabase(&pq.Error{Code: "22021"}), "NUL byte")
+	assert.False(t, refusedByDatabase(&pq.Error{Code: "57P01"}), "admin shutdown")
+	assert.False(t, refusedByDatabase(&pq.Error{Code: "23503"}), "foreign key violation")
+	assert.False(t, refusedByDatabase(sql.ErrConnDone))
+}
+
+// BenchmarkPostWrites compares writing each sample post on its own with writing them in batches
+func BenchmarkPostWrites(b *testing.B) {
+	db := openTestDB(b)
+	ctx := context.Background()
+
+	var events []*models.Event
+	for _, evt := range loadSampleEvents(b, "jetstream-samples-with-tags.json") {
+		post, err := jetstream.ExtractPost(evt)
+		if err == nil && newPostFilter(FilterRules{}).reason(evt.Did, post) == "" {
+			events = append(events, evt)
+		}
+	}
+	removeTestPosts(b, db, events)
+
+	for _, batchSize := range []int{1, 50, 500} {
+		b.Run(fmt.Sprintf("batch-%d", batchSize), func(b *testing.B) {
+			g := newTestGuzzle(db)
+			if batchSize > 1 {
+				g.batcher = newPostBatcher(db, batchSize, g.metrics, g.saveDeadLetter)
+			}
+
+			for i := 0; i < b.N; i++ {
+				// start from an empty table each time so the inserts aren't all conflicts
+				b.StopTimer()
+				deleteTestPosts(b, db, events)
+				b.StartTimer()
+
+				for _, evt := range events {
+					if err := g.handleEvent(ctx, evt); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if g.batcher != nil {
+					if err := g.batcher.flush(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(len(events)*b.N)/b.Elapsed().Seconds(), "posts/s")
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"firehose/pkg/db/query"
+)
+
+const (
+	// jetstreamCursorName identifies guzzle's row in the jetstream_cursors table
+	jetstreamCursorName       = "guzzle"
+	defaultCursorSaveInterval = 10 * time.Second
+	cursorSaveOnCloseTimeout  = 5 * time.Second
+	// jetstream's default event TTL, a cursor older than this can't be fully replayed
+	defaultReplayWindow = 24 * time.Hour
+)
+
+// CursorLastSaved resumes from the cursor saved by the previous run, failing if there isn't one.
+// An empty cursor resumes from it too, but falls back to the live stream.
+const CursorLastSaved = "last-saved"
+
+// ParseCursor reads a cursor given on the command line as the time_us to start from. It accepts
+// a time_us, an RFC3339 timestamp, a DD/MM/YYYY date, or a duration before now such as "-2h".
+// "" and CursorLastSaved depend on the saved cursor, so they return nil for Run to resolve.
+func ParseCursor(value string, now time.Time) (*int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == CursorLastSaved {
+		return nil, nil
+	}
+
+	var start time.Time
+	if timeUS, err := strconv.ParseInt(value, 10, 64); err == nil {
+		start = time.UnixMicro(timeUS)
+	} else if ago, ok := strings.CutPrefix(value, "-"); ok {
+		d, err := time.ParseDuration(ago)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q: %w", value, err)
+		}
+		start = now.Add(-d)
+	} else if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		start = t
+	} else if t, err := time.Parse("02/01/2006", value); err == nil {
+		start = t
+	} else {
+		return nil, fmt.Errorf("invalid cursor %q: want a time_us, RFC3339 time, DD/MM/YYYY date, duration ago like -2h or %s", value, CursorLastSaved)
+	}
+
+	if start.After(now) {
+		return nil, fmt.Errorf("invalid cursor %q: %s is in the future", value, start.Format(time.RFC3339))
+	}
+	timeUS := start.UnixMicro()
+	return &timeUS, nil
+}
+
+// startCursor resolves Run's cursor argument to the time_us to connect from, nil for the live stream
+func (g *Guzzle) startCursor(ctx context.Context, value string) (*int64, error) {
+	if value != "" && value != CursorLastSaved {
+		g.logger.Info("Attempting to backfill from cursor", "cursor", value)
+		return ParseCursor(value, time.Now())
+	}
+
+	saved, err := g.loadCursor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil && value == CursorLastSaved {
+		return nil, errors.New("no saved cursor to resume from")
+	}
+	if saved != nil {
+		g.logger.Info("Resuming from saved cursor", "cursor", *saved, "from", time.UnixMicro(*saved).Format(time.RFC3339))
+	}
+	return saved, nil
+}
+
+// trackCursor records the time_us of a processed event, keeping the latest one seen
+func (g *Guzzle) trackCursor(timeUS int64) {
+	for {
+		current := g.cursor.Load()
+		if timeUS <= current || g.cursor.CompareAndSwap(current, timeUS) {
+			return
+		}
+	}
+}
+
+// loadCursor returns the last saved cursor, or nil if guzzle has never saved one
+func (g *Guzzle) loadCursor(ctx context.Context) (*int64, error) {
+	timeUS, err := query.New(g.db).GetJetstreamCursor(ctx, jetstreamCursorName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryLoadCursor).Inc()
+		return nil, fmt.Errorf("failed to load saved cursor: %w", err)
+	}
+	return &timeUS, nil
+}
+
+// saveCursor persists the latest processed cursor so a restart can resume from it.
+// Buffered posts are flushed first, so the saved cursor never covers a post that was only in memory.
+func (g *Guzzle) saveCursor(ctx context.Context) error {
+	timeUS := g.cursor.Load()
+	if timeUS == 0 {
+		return nil
+	}
+
+	// every event up to timeUS was handed to the batcher before this flush starts, and a batch a worker's
+	// flush already took is written or put back before this one runs
+	if g.batcher != nil {
+		if err := g.batcher.flush(ctx); err != nil {
+			return err
+		}
+	}
+	// and to the archive, which shouldn't lose more than the cursor would replay after a crash
+	if g.archive != nil {
+		if err := g.archive.Flush(); err != nil {
+			g.metrics.archiveErrors.Inc()
+			g.logger.Error("Failed to flush event archive", "error", err)
+		}
+	}
+
+	err := query.New(g.db).SaveJetstreamCursor(ctx, query.SaveJetstreamCursorParams{
+		Name:   jetstreamCursorName,
+		TimeUs: timeUS,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(querySaveCursor).Inc()
+		return fmt.Errorf("failed to save cursor: %w", err)
+	}
+	return nil
+}
+
+// persistCursor saves the cursor periodically until the context is cancelled
+func (g *Guzzle) persistCursor(ctx context.Context) error {
+	ticker := time.NewTicker(g.config.CursorSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// a failed save is retried on the next tick, so it shouldn't stop ingestion
+			if err := g.saveCursor(ctx); err != nil {
+				g.logger.Error("Failed to save cursor", "error", err)
+			}
+		}
+	}
+}
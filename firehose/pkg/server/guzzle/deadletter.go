@@ -0,0 +1,172 @@
+package guzzle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"firehose/pkg/db/query"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// errBatchRequeued marks a failed batch write whose posts were put back for the next flush.
+// The event that happened to trigger the flush didn't fail, so it isn't dead lettered.
+var errBatchRequeued = errors.New("batch kept for the next flush")
+
+// deadLetterTimeout bounds a dead letter's write, which outlives the caller's context so that
+// events failed by a cancellation can still be kept
+const deadLetterTimeout = 5 * time.Second
+
+// where a dead letter ended up
+const (
+	deadLetterStoreTable = "table"
+	deadLetterStoreFile  = "file"
+	deadLetterStoreLost  = "lost"
+)
+
+// deadLetterEntry is a line of the dead letter file, written when the database can't take a dead letter
+type deadLetterEntry struct {
+	Did        string          `json:"did"`
+	TimeUS     int64           `json:"time_us"`
+	Collection string          `json:"collection"`
+	Operation  string          `json:"operation"`
+	RKey       string          `json:"rkey"`
+	Error      string          `json:"error"`
+	FailedAt   time.Time       `json:"failed_at"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// saveDeadLetter keeps an event that failed processing so it can be inspected and retried later.
+// When the database can't take it either, as during an outage, it's appended to the dead letter file.
+func (g *Guzzle) saveDeadLetter(ctx context.Context, evt *models.Event, cause error) {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		g.metrics.deadLetters.WithLabelValues(deadLetterStoreLost).Inc()
+		g.eventLogger(evt).Error("Failed to encode dead letter", "error", err)
+		return
+	}
+
+	params := query.SaveDeadLetterParams{
+		Did:    evt.Did,
+		TimeUs: evt.TimeUS,
+		Error:  cause.Error(),
+		Event:  raw,
+	}
+	if evt.Commit != nil {
+		params.Collection = evt.Commit.Collection
+		params.Operation = evt.Commit.Operation
+		params.Rkey = evt.Commit.RKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadLetterTimeout)
+	defer cancel()
+	err = query.New(g.db).SaveDeadLetter(ctx, params)
+	if err == nil {
+		g.metrics.deadLetters.WithLabelValues(deadLetterStoreTable).Inc()
+		return
+	}
+	g.metrics.dbErrors.WithLabelValues(querySaveDeadLetter).Inc()
+	g.eventLogger(evt).Error("Failed to save dead letter", "error", err)
+
+	if g.config.DeadLetterPath == "" {
+		g.metrics.deadLetters.WithLabelValues(deadLetterStoreLost).Inc()
+		return
+	}
+	if err := g.appendDeadLetter(params); err != nil {
+		g.metrics.deadLetters.WithLabelValues(deadLetterStoreLost).Inc()
+		g.eventLogger(evt).Error("Failed to write dead letter", "path", g.config.DeadLetterPath, "error", err)
+		return
+	}
+	g.metrics.deadLetters.WithLabelValues(deadLetterStoreFile).Inc()
+}
+
+// appendDeadLetter writes a dead letter to the end of the dead letter file
+func (g *Guzzle) appendDeadLetter(params query.SaveDeadLetterParams) error {
+	line, err := json.Marshal(deadLetterEntry{
+		Did:        params.Did,
+		TimeUS:     params.TimeUs,
+		Collection: params.Collection,
+		Operation:  params.Operation,
+		RKey:       params.Rkey,
+		Error:      params.Error,
+		FailedAt:   time.Now().UTC(),
+		Event:      params.Event,
+	})
+	if err != nil {
+		return err
+	}
+
+	// workers fail concurrently, keep their lines whole
+	g.deadLetterMu.Lock()
+	defer g.deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(g.config.DeadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadDeadLetterFile reads the dead letters written to a dead letter file, ready to be saved to the dead_letters table
+func ReadDeadLetterFile(path string) ([]query.SaveDeadLetterParams, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	var letters []query.SaveDeadLetterParams
+	scanner := bufio.NewScanner(f)
+	// events carry whole post records, well past the default line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry deadLetterEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid dead letter on line %d of %s: %w", line, path, err)
+		}
+		letters = append(letters, query.SaveDeadLetterParams{
+			Did:        entry.Did,
+			TimeUs:     entry.TimeUS,
+			Collection: entry.Collection,
+			Operation:  entry.Operation,
+			Rkey:       entry.RKey,
+			Error:      entry.Error,
+			Event:      entry.Event,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	return letters, nil
+}
+
+// RetryDeadLetter processes a dead lettered event again and deletes the dead letter once it succeeds.
+// If it fails again the dead letter stays, with the new error and one more attempt.
+func (g *Guzzle) RetryDeadLetter(ctx context.Context, letter query.DeadLetter) error {
+	var evt models.Event
+	if err := json.Unmarshal(letter.Event, &evt); err != nil {
+		return fmt.Errorf("failed to decode dead letter %d: %w", letter.ID, err)
+	}
+
+	if err := g.handleEvent(ctx, &evt); err != nil {
+		return err
+	}
+	if g.batcher != nil {
+		if err := g.batcher.flush(ctx); err != nil {
+			return err
+		}
+	}
+	return query.New(g.db).DeleteDeadLetters(ctx, []int32{letter.ID})
+}
@@ -0,0 +1,147 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"firehose/pkg/db/query"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closedDB fails every query the way an unreachable database would
+func closedDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", "postgres://localhost:1/rayleigh?sslmode=disable")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	return db
+}
+
+func TestDeadLettersFallBackToFile(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGuzzle(closedDB(t))
+	g.config.DeadLetterPath = filepath.Join(t.TempDir(), "dead_letters.jsonl")
+
+	malformed := loadSampleEvents(t, "jetstream-malformed-events.json")[0]
+	tagged := loadSampleEvents(t, "jetstream-samples-with-tags-tiny.json")[0]
+
+	// one record that can't be decoded, one post the database can't take
+	assert.Error(t, g.handleEvent(ctx, malformed))
+	assert.Error(t, g.handleEvent(ctx, tagged))
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.deadLetters.WithLabelValues(deadLetterStoreFile)))
+
+	letters, err := ReadDeadLetterFile(g.config.DeadLetterPath)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+
+	assert.Equal(t, malformed.Did, letters[0].Did)
+	assert.Equal(t, malformed.TimeUS, letters[0].TimeUs)
+	assert.Equal(t, models.CommitOperationCreate, letters[0].Operation)
+	assert.Equal(t, malformed.Commit.RKey, letters[0].Rkey)
+	assert.Contains(t, letters[0].Error, "failed to unmarshal post commit data")
+	assert.Contains(t, letters[1].Error, "database is closed")
+
+	// the raw event survives the round trip
+	var replayed models.Event
+	require.NoError(t, json.Unmarshal(letters[1].Event, &replayed))
+	assert.Equal(t, tagged.Commit.RKey, replayed.Commit.RKey)
+	assert.JSONEq(t, string(tagged.Commit.Record), string(replayed.Commit.Record))
+}
+
+func TestDeadLettersWithoutFileAreCounted(t *testing.T) {
+	g := newTestGuzzle(closedDB(t))
+
+	assert.Error(t, g.handleEvent(context.Background(), loadSampleEvents(t, "jetstream-malformed-events.json")[0]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.deadLetters.WithLabelValues(deadLetterStoreLost)))
+}
+
+func TestRequeuedBatchIsNotDeadLettered(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGuzzle(closedDB(t))
+	g.config.DeadLetterPath = filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	g.batcher = newPostBatcher(g.db, 1, g.metrics, g.saveDeadLetter)
+
+	err := g.handleEvent(ctx, loadSampleEvents(t, "jetstream-samples-with-tags-tiny.json")[0])
+	assert.True(t, errors.Is(err, errBatchRequeued))
+	assert.Len(t, g.batcher.pending, 1)
+	assert.NoFileExists(t, g.config.DeadLetterPath)
+}
+
+func TestDeadLetterOutlivesCancelledContext(t *testing.T) {
+	db := openTestDB(t)
+	g := newTestGuzzle(db)
+
+	evt := loadSampleEvents(t, "jetstream-facet-events.json")[0]
+	removeDeadLetters := func() {
+		_, err := db.Exec(`DELETE FROM dead_letters WHERE did = $1`, evt.Did)
+		require.NoError(t, err)
+	}
+	removeDeadLetters()
+	t.Cleanup(removeDeadLetters)
+
+	// the event failed because the run was stopping, its dead letter is still written
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.saveDeadLetter(ctx, evt, context.Canceled)
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.deadLetters.WithLabelValues(deadLetterStoreTable)))
+
+	var letters int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM dead_letters WHERE did = $1 AND time_us = $2`, evt.Did, evt.TimeUS).Scan(&letters))
+	assert.Equal(t, 1, letters)
+}
+
+func TestRetryDeadLetters(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+	q := query.New(db)
+
+	malformed := loadSampleEvents(t, "jetstream-malformed-events.json")[0]
+	valid := loadSampleEvents(t, "jetstream-facet-events.json")[0]
+	events := []*models.Event{malformed, valid}
+	removeTestPosts(t, db, events)
+	removeDeadLetters := func() {
+		_, err := db.Exec(`DELETE FROM dead_letters WHERE did = ANY($1)`, pq.Array([]string{malformed.Did, valid.Did}))
+		require.NoError(t, err)
+	}
+	removeDeadLetters()
+	t.Cleanup(removeDeadLetters)
+
+	findLetter := func(evt *models.Event) *query.DeadLetter {
+		letters, err := q.ListDeadLetters(ctx, query.ListDeadLettersParams{RowLimit: 1000})
+		require.NoError(t, err)
+		for _, letter := range letters {
+			if letter.Did == evt.Did && letter.TimeUs == evt.TimeUS {
+				return &letter
+			}
+		}
+		return nil
+	}
+
+	// a malformed record keeps failing, each retry counts another attempt
+	assert.Error(t, g.handleEvent(ctx, malformed))
+	letter := findLetter(malformed)
+	require.NotNil(t, letter)
+	assert.Equal(t, int32(1), letter.Attempts)
+	assert.Error(t, g.RetryDeadLetter(ctx, *letter))
+	letter = findLetter(malformed)
+	require.NotNil(t, letter)
+	assert.Equal(t, int32(2), letter.Attempts)
+
+	// a post that failed on a transient error is stored by the retry and its dead letter removed
+	g.saveDeadLetter(ctx, valid, errors.New("connection reset by peer"))
+	letter = findLetter(valid)
+	require.NotNil(t, letter)
+	require.NoError(t, g.RetryDeadLetter(ctx, *letter))
+	assert.Nil(t, findLetter(valid))
+	assert.Equal(t, 1, countStoredPosts(t, db, valid))
+}
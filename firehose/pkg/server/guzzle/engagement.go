@@ -0,0 +1,113 @@
+package guzzle
+
+import (
+	"context"
+	"time"
+
+	"firehose/pkg/db/query"
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// the kinds of interaction post_interactions records
+const (
+	interactionLike   = "like"
+	interactionRepost = "repost"
+)
+
+// interactionKind returns the kind of interaction a like or repost commit records
+func interactionKind(collection string) string {
+	if collection == jetstream.LikeCollection {
+		return interactionLike
+	}
+	return interactionRepost
+}
+
+// handleInteraction counts a created like or repost against the stored post it's about, or uncounts a deleted one.
+// Likes and reposts of anything else are dropped, as are those of a post still waiting in the batcher. Most are
+// about posts that were never stored, so they're checked against the key filters before touching the database.
+func (g *Guzzle) handleInteraction(ctx context.Context, evt *models.Event) error {
+	if evt.Commit.Operation == models.CommitOperationDelete {
+		return g.removeInteraction(ctx, evt)
+	}
+	return g.addInteraction(ctx, evt)
+}
+
+// addInteraction counts a like or repost, once however often its event is seen
+func (g *Guzzle) addInteraction(ctx context.Context, evt *models.Event) error {
+	interaction, err := jetstream.ExtractInteraction(evt)
+	if err != nil {
+		g.eventLogger(evt).Error("Failed to extract interaction", "error", err)
+		return err
+	}
+	subjectDid, subjectPostID, err := jetstream.ParsePostURI(interaction.Subject.URI)
+	if err != nil {
+		// feed generators and lists can be liked too
+		g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject).Inc()
+		return nil
+	}
+	if !g.storedPosts.mayContain(subjectDid, subjectPostID) {
+		g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject).Inc()
+		return nil
+	}
+	createdAt := interaction.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.UnixMicro(evt.TimeUS)
+	}
+
+	kind := interactionKind(evt.Commit.Collection)
+	start := time.Now()
+	counted, err := query.New(g.db).AddPostInteraction(ctx, query.AddPostInteractionParams{
+		Did:           evt.Did,
+		Kind:          kind,
+		Rkey:          evt.Commit.RKey,
+		CreatedAt:     createdAt,
+		SubjectDid:    subjectDid,
+		SubjectPostID: subjectPostID,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryAddInteraction).Inc()
+		g.eventLogger(evt).Error("Failed to count interaction", "latency", time.Since(start), "error", err)
+		return err
+	}
+	if counted == 0 {
+		// the post isn't stored, or this like or repost was counted already
+		g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject).Inc()
+		return nil
+	}
+	g.countedInteractions.add(evt.Did, kind, evt.Commit.RKey)
+	g.archiveKept(evt)
+	g.metrics.interactionsCounted.WithLabelValues(kind, models.CommitOperationCreate).Inc()
+	g.eventLogger(evt).Debug("Interaction counted", "kind", kind, "subject", interaction.Subject.URI)
+	return nil
+}
+
+// removeInteraction uncounts a deleted like or repost. Its delete only names the like or repost,
+// so it's looked up among those counted.
+func (g *Guzzle) removeInteraction(ctx context.Context, evt *models.Event) error {
+	kind := interactionKind(evt.Commit.Collection)
+	if !g.countedInteractions.mayContain(evt.Did, kind, evt.Commit.RKey) {
+		g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject).Inc()
+		return nil
+	}
+	start := time.Now()
+	removed, err := query.New(g.db).RemovePostInteraction(ctx, query.RemovePostInteractionParams{
+		Did:  evt.Did,
+		Kind: kind,
+		Rkey: evt.Commit.RKey,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryRemoveInteraction).Inc()
+		g.eventLogger(evt).Error("Failed to uncount interaction", "latency", time.Since(start), "error", err)
+		return err
+	}
+	if removed == 0 {
+		g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject).Inc()
+		return nil
+	}
+	g.archiveKept(evt)
+	g.metrics.interactionsCounted.WithLabelValues(kind, models.CommitOperationDelete).Inc()
+	g.eventLogger(evt).Debug("Interaction uncounted", "kind", kind)
+	return nil
+}
@@ -0,0 +1,120 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"firehose/pkg/db/query"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchEngagementTag returns the fixture's tagged posts as the API's tag search finds them
+func searchEngagementTag(t *testing.T, db *sql.DB, byEngagement bool) []query.GetRecentRootPostsByTagsRow {
+	t.Helper()
+
+	rows, err := query.New(db).GetRecentRootPostsByTags(context.Background(), query.GetRecentRootPostsByTagsParams{
+		TagNames:         []string{"rayleightestengage"},
+		CreatedAfter:     time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		RowLimit:         10,
+		SortByEngagement: byEngagement,
+	})
+	require.NoError(t, err)
+	return rows
+}
+
+func TestEngagementCounts(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+
+	events := loadSampleEvents(t, "jetstream-engagement-events.json")
+	require.Len(t, events, 9)
+	removeTestPosts(t, db, events)
+	a, b := events[0], events[1]
+
+	for _, evt := range events[:7] {
+		require.NoError(t, g.handleEvent(ctx, evt))
+	}
+	// a like seen twice is counted once
+	require.NoError(t, g.handleEvent(ctx, events[2]))
+
+	recent := searchEngagementTag(t, db, false)
+	require.Len(t, recent, 2)
+	assert.Equal(t, b.Did, recent[0].CreatorDid)
+	assert.Equal(t, int32(1), recent[0].Reposts)
+
+	popular := searchEngagementTag(t, db, true)
+	require.Len(t, popular, 2)
+	assert.Equal(t, a.Did, popular[0].CreatorDid)
+	assert.Equal(t, int32(2), popular[0].Likes)
+	assert.Equal(t, int32(0), popular[0].Reposts)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.interactionsCounted.WithLabelValues(interactionLike, models.CommitOperationCreate)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.interactionsCounted.WithLabelValues(interactionRepost, models.CommitOperationCreate)))
+	// the like of an unstored post, the like of a feed generator and the repeated like
+	assert.Equal(t, 3.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject)))
+
+	// deletes uncount them, deleting one that was never counted changes nothing
+	for _, evt := range events[7:] {
+		require.NoError(t, g.handleEvent(ctx, evt))
+	}
+	require.NoError(t, g.handleEvent(ctx, events[8]))
+	popular = searchEngagementTag(t, db, true)
+	require.Len(t, popular, 2)
+	assert.Equal(t, a.Did, popular[0].CreatorDid)
+	assert.Equal(t, int32(1), popular[0].Likes)
+	assert.Equal(t, int32(0), popular[1].Reposts)
+	assert.Equal(t, 4.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject)))
+
+	// deleting a post drops its counts along with it
+	require.NoError(t, g.handleEvent(ctx, &models.Event{Did: a.Did, Kind: models.EventKindCommit, Commit: &models.Commit{
+		Operation: models.CommitOperationDelete, Collection: a.Commit.Collection, RKey: a.Commit.RKey,
+	}}))
+	var interactions int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM post_interactions WHERE did = $1`, events[2].Did).Scan(&interactions))
+	assert.Zero(t, interactions)
+}
+
+func TestMalformedInteractionFails(t *testing.T) {
+	g := newTestGuzzle(nil)
+
+	// nothing reaches the (nil) db
+	evt := &models.Event{Did: "did:plc:a", Kind: models.EventKindCommit, Commit: &models.Commit{
+		Operation: models.CommitOperationCreate, Collection: "app.bsky.feed.like", RKey: "3ldglike0001",
+		Record: []byte(`{"$type":"app.bsky.feed.like","createdAt":"2024-12-16T13:00:02.000Z"}`),
+	}}
+	assert.Error(t, g.processEvent(context.Background(), evt))
+}
+
+func TestInteractionsOfUnstoredPostsSkipTheDatabase(t *testing.T) {
+	ctx := context.Background()
+	events := loadSampleEvents(t, "jetstream-engagement-events.json")
+
+	// every query fails, so only the likes and reposts that get past the key filters reach it
+	g := newTestGuzzle(closedDB(t))
+	g.storedPosts = newKeyFilter(0)
+	g.storedPosts.loaded.Store(true)
+	g.countedInteractions = newKeyFilter(0)
+	g.countedInteractions.loaded.Store(true)
+
+	for _, evt := range events[2:] {
+		require.NoError(t, g.handleEvent(ctx, evt))
+	}
+	assert.Equal(t, 7.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonSubject)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(g.metrics.dbErrors.WithLabelValues(queryAddInteraction)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(g.metrics.dbErrors.WithLabelValues(queryRemoveInteraction)))
+
+	// once the post is stored its likes are counted, and once counted their deletes are uncounted
+	g.storedPosts.add(events[0].Did, events[0].Commit.RKey)
+	assert.Error(t, g.handleEvent(ctx, events[3]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.dbErrors.WithLabelValues(queryAddInteraction)))
+	g.countedInteractions.add(events[7].Did, interactionLike, events[7].Commit.RKey)
+	assert.Error(t, g.handleEvent(ctx, events[7]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.dbErrors.WithLabelValues(queryRemoveInteraction)))
+}
@@ -0,0 +1,142 @@
+package guzzle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"firehose/pkg/jetstream"
+)
+
+// FilterRules decides which posts are persisted. The zero value keeps posts
+// that have at least one tag and aren't replies.
+type FilterRules struct {
+	// Only keep posts carrying at least one of these tags, empty allows every tag
+	AllowTags []string `json:"allow_tags"`
+	// Drop posts carrying any of these tags
+	DenyTags []string `json:"deny_tags"`
+	// Only keep posts declaring at least one of these languages, empty allows every language
+	AllowLangs []string `json:"allow_langs"`
+	// Only keep posts from these DIDs, empty allows every DID
+	AllowDIDs []string `json:"allow_dids"`
+	// Drop posts from these DIDs
+	DenyDIDs []string `json:"deny_dids"`
+	// Keep replies as well as top level posts, untagged replies too when their thread's root is stored
+	IncludeReplies bool `json:"include_replies"`
+	// Keep posts without any tags, the tag allow list then only applies to tagged posts
+	IncludeUntagged bool `json:"include_untagged"`
+	// Drop posts whose text is shorter than this many characters
+	MinTextLength int `json:"min_text_length"`
+}
+
+// LoadFilterRules reads filter rules from a JSON file, rejecting unknown fields so typos don't silently widen the filter
+func LoadFilterRules(path string) (FilterRules, error) {
+	var rules FilterRules
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read filter rules: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return rules, fmt.Errorf("failed to parse filter rules %s: %w", path, err)
+	}
+	if rules.MinTextLength < 0 {
+		return rules, fmt.Errorf("invalid filter rules %s: min_text_length must not be negative", path)
+	}
+	return rules, nil
+}
+
+// postFilter is FilterRules with its lists turned into sets. Tags are normalized like the tags of posts
+// and languages compared case-insensitively.
+type postFilter struct {
+	rules      FilterRules
+	allowTags  map[string]bool
+	denyTags   map[string]bool
+	allowLangs map[string]bool
+	allowDIDs  map[string]bool
+	denyDIDs   map[string]bool
+}
+
+func newPostFilter(rules FilterRules) *postFilter {
+	return &postFilter{
+		rules:      rules,
+		allowTags:  toSet(rules.AllowTags, jetstream.NormalizeTag),
+		denyTags:   toSet(rules.DenyTags, jetstream.NormalizeTag),
+		allowLangs: toSet(rules.AllowLangs, strings.ToLower),
+		allowDIDs:  toSet(rules.AllowDIDs, nil),
+		denyDIDs:   toSet(rules.DenyDIDs, nil),
+	}
+}
+
+func toSet(values []string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if normalize != nil {
+			value = normalize(value)
+		}
+		set[value] = true
+	}
+	return set
+}
+
+// reason says why a post from did shouldn't be persisted, or "" if it qualifies
+func (f *postFilter) reason(did string, post *jetstream.PostCommitRecord) string {
+	return f.reasonInThread(did, post, false)
+}
+
+// reasonInThread is reason for a post whose thread's root is stored or not.
+// Kept replies in a stored thread don't need tags of their own.
+func (f *postFilter) reasonInThread(did string, post *jetstream.PostCommitRecord, rootStored bool) string {
+	if f.denyDIDs[did] || (len(f.allowDIDs) > 0 && !f.allowDIDs[did]) {
+		return filterReasonDID
+	}
+	if post.Reply != nil && !f.rules.IncludeReplies {
+		return filterReasonReply
+	}
+
+	if len(post.Tags) == 0 {
+		if !f.rules.IncludeUntagged && !(post.Reply != nil && rootStored) {
+			return filterReasonNoTags
+		}
+	} else {
+		// post tags are already normalized by jetstream.ExtractPost
+		allowed := len(f.allowTags) == 0
+		for _, tag := range post.Tags {
+			if f.denyTags[tag] {
+				return filterReasonTagDenied
+			}
+			allowed = allowed || f.allowTags[tag]
+		}
+		if !allowed {
+			return filterReasonTagNotAllowed
+		}
+	}
+
+	if len(f.allowLangs) > 0 && !f.hasAllowedLang(post.Langs) {
+		return filterReasonLang
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(post.Text)) < f.rules.MinTextLength {
+		return filterReasonTooShort
+	}
+	return ""
+}
+
+// hasAllowedLang matches on the primary subtag too, so "en" allows "en-US"
+func (f *postFilter) hasAllowedLang(langs []string) bool {
+	for _, lang := range langs {
+		lang = strings.ToLower(lang)
+		if f.allowLangs[lang] {
+			return true
+		}
+		if primary, _, found := strings.Cut(lang, "-"); found && f.allowLangs[primary] {
+			return true
+		}
+	}
+	return false
+}
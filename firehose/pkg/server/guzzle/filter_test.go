@@ -0,0 +1,137 @@
+package guzzle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"firehose/pkg/jetstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countKept runs every post in a sample file through the rules
+func countKept(t *testing.T, name string, rules FilterRules) (int, map[string]int) {
+	t.Helper()
+	filter := newPostFilter(rules)
+
+	kept := 0
+	reasons := make(map[string]int)
+	for _, evt := range loadSampleEvents(t, name) {
+		post, err := jetstream.ExtractPost(evt)
+		require.NoError(t, err)
+		if reason := filter.reason(evt.Did, post); reason != "" {
+			reasons[reason]++
+			continue
+		}
+		kept++
+	}
+	return kept, reasons
+}
+
+func TestFilterRulesAgainstSamples(t *testing.T) {
+	// 143 tagged posts, 15 of them replies
+	tests := []struct {
+		name  string
+		rules FilterRules
+		kept  int
+	}{
+		{"default keeps tagged top level posts", FilterRules{}, 128},
+		{"include replies", FilterRules{IncludeReplies: true}, 143},
+		{"tag allow list is case insensitive", FilterRules{AllowTags: []string{"MIXI2"}}, 6},
+		{"tag deny list", FilterRules{DenyTags: []string{"nsfw"}}, 126},
+		{"language allow list", FilterRules{AllowLangs: []string{"ja"}}, 26},
+		{"several languages", FilterRules{AllowLangs: []string{"ja", "en"}}, 91},
+		{"minimum text length", FilterRules{MinTextLength: 20}, 118},
+		{"minimum text length with replies", FilterRules{MinTextLength: 20, IncludeReplies: true}, 132},
+		{"DID allow list", FilterRules{AllowDIDs: []string{"did:plc:b7vtgtxpxm35vemoxrn6w3xy"}}, 4},
+		{"DID deny list", FilterRules{DenyDIDs: []string{"did:plc:b7vtgtxpxm35vemoxrn6w3xy", "did:plc:pdvjdejvjinix4lnt4sgzg7r"}}, 121},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, _ := countKept(t, "jetstream-samples-with-tags.json", tt.rules)
+			assert.Equal(t, tt.kept, kept)
+		})
+	}
+}
+
+func TestFilterRulesUntaggedPosts(t *testing.T) {
+	kept, reasons := countKept(t, "jetstream-sample.json", FilterRules{})
+	assert.Equal(t, 0, kept)
+	assert.Equal(t, 1, reasons[filterReasonNoTags])
+
+	kept, _ = countKept(t, "jetstream-sample.json", FilterRules{IncludeUntagged: true})
+	assert.Equal(t, 1, kept)
+
+	// the tag allow list only applies to posts that have tags
+	kept, _ = countKept(t, "jetstream-sample.json", FilterRules{IncludeUntagged: true, AllowTags: []string{"mixi2"}})
+	assert.Equal(t, 1, kept)
+}
+
+func TestFilterReasons(t *testing.T) {
+	filter := newPostFilter(FilterRules{
+		AllowTags:     []string{"#Go"},
+		DenyTags:      []string{"spam"},
+		AllowLangs:    []string{"en"},
+		DenyDIDs:      []string{"did:plc:blocked"},
+		MinTextLength: 5,
+	})
+	post := func(text string, langs []string, tags ...string) *jetstream.PostCommitRecord {
+		return &jetstream.PostCommitRecord{Text: text, Langs: langs, Tags: tags}
+	}
+
+	assert.Equal(t, "", filter.reason("did:plc:a", post("hello #go", []string{"en-GB"}, "go")))
+	assert.Equal(t, filterReasonDID, filter.reason("did:plc:blocked", post("hello #go", []string{"en"}, "go")))
+	assert.Equal(t, filterReasonTagDenied, filter.reason("did:plc:a", post("hello #go #spam", []string{"en"}, "go", "spam")))
+	assert.Equal(t, filterReasonTagNotAllowed, filter.reason("did:plc:a", post("hello #rust", []string{"en"}, "rust")))
+	assert.Equal(t, filterReasonLang, filter.reason("did:plc:a", post("hallo #go", []string{"de"}, "go")))
+	assert.Equal(t, filterReasonLang, filter.reason("did:plc:a", post("hello #go", nil, "go")))
+	assert.Equal(t, filterReasonTooShort, filter.reason("did:plc:a", post(" #go ", []string{"en"}, "go")))
+	assert.Equal(t, filterReasonNoTags, filter.reason("did:plc:a", post("hello", []string{"en"})))
+
+	reply := post("hello #go", []string{"en"}, "go")
+	reply.Reply = &jetstream.Reply{}
+	assert.Equal(t, filterReasonReply, filter.reason("did:plc:a", reply))
+}
+
+func TestFilterRepliesInStoredThreads(t *testing.T) {
+	filter := newPostFilter(FilterRules{IncludeReplies: true, AllowLangs: []string{"en"}})
+	reply := &jetstream.PostCommitRecord{Text: "agreed", Langs: []string{"en"}, Reply: &jetstream.Reply{}}
+
+	assert.Equal(t, filterReasonNoTags, filter.reason("did:plc:a", reply))
+	assert.Equal(t, filterReasonNoTags, filter.reasonInThread("did:plc:a", reply, false))
+	assert.Equal(t, "", filter.reasonInThread("did:plc:a", reply, true))
+
+	// the other rules still apply
+	reply.Langs = []string{"de"}
+	assert.Equal(t, filterReasonLang, filter.reasonInThread("did:plc:a", reply, true))
+
+	// a top level post needs its own tags
+	post := &jetstream.PostCommitRecord{Text: "agreed", Langs: []string{"en"}}
+	assert.Equal(t, filterReasonNoTags, filter.reasonInThread("did:plc:a", post, true))
+}
+
+func TestLoadFilterRules(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "filter.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"allow_tags": ["go"], "include_replies": true, "min_text_length": 10}`), 0644))
+	rules, err := LoadFilterRules(path)
+	require.NoError(t, err)
+	assert.Equal(t, FilterRules{AllowTags: []string{"go"}, IncludeReplies: true, MinTextLength: 10}, rules)
+
+	typo := filepath.Join(dir, "typo.json")
+	require.NoError(t, os.WriteFile(typo, []byte(`{"allow_tag": ["go"]}`), 0644))
+	_, err = LoadFilterRules(typo)
+	assert.Error(t, err)
+
+	negative := filepath.Join(dir, "negative.json")
+	require.NoError(t, os.WriteFile(negative, []byte(`{"min_text_length": -1}`), 0644))
+	_, err = LoadFilterRules(negative)
+	assert.Error(t, err)
+
+	_, err = LoadFilterRules(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
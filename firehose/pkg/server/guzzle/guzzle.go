@@ -3,28 +3,26 @@ package guzzle
 import (
 	"context"
 	"database/sql"
-	dbutils "firehose/pkg/db"
+	"errors"
+	"firehose/pkg/archive"
 	"firehose/pkg/db/query"
 	"firehose/pkg/jetstream"
 	"fmt"
-	"io"
-	"log"
 	"log/slog"
 	"math"
-	"os"
-	"strings"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/jetstream/pkg/client"
-	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
 	"github.com/bluesky-social/jetstream/pkg/models"
 )
 
 const (
-	maxRetries     = 3
-	baseRetryDelay = time.Second
-	failureTimeout = time.Hour
+	maxRetries            = 3
+	defaultRetryDelay     = time.Second
+	defaultAllFailedDelay = time.Hour
 )
 
 // documented at https://github.com/bluesky-social/jetstream/tree/main
@@ -37,27 +35,83 @@ var defaultJetstreamURLs = []string{
 
 // Config holds the guzzle configuration
 type Config struct {
-	DBPath  string
-	LogPath string
+	DBPath string
+	// Where guzzle logs, slog.Default() when nil
+	Logger *slog.Logger
+	// Postgres connection string, see config.Database.ConnString
+	DatabaseURL string
 	// Optional custom Jetstream URLs
 	JetstreamURLs []string
+	// How far back jetstream can replay, a cursor older than this warns that events were lost
+	ReplayWindow time.Duration
+	// Base of the exponential backoff before connecting to the next Jetstream URL
+	RetryDelay time.Duration
+	// Wait once every Jetstream URL has failed in turn, before starting over
+	AllFailedDelay time.Duration
+	// How often the last processed cursor is saved to the db
+	CursorSaveInterval time.Duration
+	// Number of workers processing events, events from the same DID stay in order.
+	// 0 or 1 processes every event sequentially.
+	Workers int
+	// Events each worker can queue before the websocket reader blocks
+	QueueSize int
+	// Number of posts written per batch, 0 or 1 writes each post as it arrives
+	BatchSize int
+	// Longest a partial batch waits before it's written
+	FlushInterval time.Duration
+	// Address the /metrics, /healthz and /readyz endpoints listen on, empty disables them
+	HTTPAddr string
+	// Readiness fails when no event has arrived for this long
+	ReadyWindow time.Duration
+	// Which posts are persisted, see LoadFilterRules
+	Filter FilterRules
+	// Also take the #hashtags written in post text, for clients that don't emit tag facets
+	TextTags bool
+	// File dead letters are appended to when the database can't take them, empty drops them
+	DeadLetterPath string
+	// Directory raw events are archived to, empty disables the archive
+	ArchiveDir string
+	// Archive only the events of posts that pass the filter, plus post deletes, counted likes and reposts and account changes, instead of every event
+	ArchiveKeptOnly bool
+	// Uncompressed size at which an archive segment is rotated, 0 uses archive.DefaultSegmentSize
+	ArchiveSegmentSize int64
+	// Archive segments older than this are deleted, 0 keeps them forever
+	ArchiveRetention time.Duration
+	// How often the posts of deleted accounts are hard deleted, 0 never purges them
+	PurgeInterval time.Duration
+	// How often tags no post uses any more are deleted, 0 never deletes them
+	TagSweepInterval time.Duration
 }
 
 // Guzzle represents the firehose ingestion service
 type Guzzle struct {
-	config  *Config
-	db      *sql.DB
-	client  *client.Client
-	mu      sync.RWMutex
-	metrics *metrics
-	logger  *log.Logger
-}
-
-// metrics tracks operational metrics
-type metrics struct {
-	entriesLogged uint64
-	spaceUsed     uint64
-	lastUpdate    time.Time
+	config *Config
+	db     *sql.DB
+	client *client.Client
+	mu     sync.RWMutex
+	// connection state for the health endpoints, guarded by mu
+	endpoint  string
+	connected bool
+	startedAt time.Time
+	metrics   *metrics
+	logger    *slog.Logger
+	filter    *postFilter
+	// nil unless posts are written in batches
+	batcher *postBatcher
+	// the posts stored and the likes and reposts counted so far, nil until Run loads them,
+	// which leaves every post possibly stored and every like or repost possibly counted
+	storedPosts         *keyFilter
+	countedInteractions *keyFilter
+	// time_us of the latest processed event
+	cursor atomic.Int64
+	// set while catching up from a cursor in the past
+	backfilling atomic.Bool
+	// unix nanoseconds when the websocket reader last handed over an event
+	lastEventAt atomic.Int64
+	// serializes writes to the dead letter file
+	deadLetterMu sync.Mutex
+	// nil unless events are archived
+	archive *archive.Writer
 }
 
 // New creates a new guzzle instance
@@ -70,55 +124,89 @@ func New(cfg *Config) (*Guzzle, error) {
 	if len(cfg.JetstreamURLs) == 0 {
 		cfg.JetstreamURLs = defaultJetstreamURLs
 	}
+	if cfg.ReplayWindow <= 0 {
+		cfg.ReplayWindow = defaultReplayWindow
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	if cfg.AllFailedDelay <= 0 {
+		cfg.AllFailedDelay = defaultAllFailedDelay
+	}
+	if cfg.CursorSaveInterval <= 0 {
+		cfg.CursorSaveInterval = defaultCursorSaveInterval
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.ReadyWindow <= 0 {
+		cfg.ReadyWindow = defaultReadyWindow
+	}
 
-	// Open log file
-	logFile, err := os.OpenFile(cfg.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
 	}
 
 	// connect to the db
-	connStr := dbutils.GetPostgresURL()
-	dbConn, err := sql.Open("postgres", connStr)
+	dbConn, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	g := &Guzzle{
-		config: cfg,
-		db:     dbConn,
-		metrics: &metrics{
-			lastUpdate: time.Now(),
-		},
-		logger: log.New(logFile, "", log.LstdFlags),
+		config:  cfg,
+		db:      dbConn,
+		metrics: newMetrics(),
+		logger:  cfg.Logger,
+		filter:  newPostFilter(cfg.Filter),
+	}
+	if cfg.BatchSize > 1 {
+		g.batcher = newPostBatcher(dbConn, cfg.BatchSize, g.metrics, g.saveDeadLetter)
+	}
+	if cfg.ArchiveDir != "" {
+		g.archive, err = archive.Open(archive.Config{
+			Dir:         cfg.ArchiveDir,
+			SegmentSize: cfg.ArchiveSegmentSize,
+			Retention:   cfg.ArchiveRetention,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event archive: %w", err)
+		}
 	}
 
 	return g, nil
 }
 
-// Run runs the guzzle service until the context is cancelled
+// Run runs the guzzle service until the context is cancelled.
+// A non-empty cursor overrides the cursor saved by a previous run.
 func (g *Guzzle) Run(ctx context.Context, cursor string) error {
-	g.logger.Println("Starting guzzle service...")
-	defer g.logger.Println("Guzzle service stopped")
+	g.logger.Info("Starting guzzle service")
+	defer g.logger.Info("Guzzle service stopped")
 
-	// Backfill -- NOT it only goes back a few hours. You do this to catch up if the server is down for any time.
-	var startTime time.Time
-	var cursorPtr *int64
+	g.mu.Lock()
+	g.startedAt = time.Now()
+	g.mu.Unlock()
 
-	if cursor != "" {
-		g.logger.Printf("Attempting to backfill from cursor: %s", cursor)
-		parsedTime, err := time.Parse("02/01/2006", cursor) // Standard format for DD/MM/YYYY
+	// Backfill -- jetstream only replays its last ReplayWindow of events, so it catches up after a short outage
+	cursorPtr, err := g.startCursor(ctx, cursor)
+	if err != nil {
+		return err
+	}
+	if cursorPtr != nil {
+		g.cursor.Store(*cursorPtr)
+		g.startBackfill(*cursorPtr)
+	} else {
+		g.logger.Info("Realtime firehose enabled")
+	}
+
+	// Bind /metrics, /healthz and /readyz before anything else starts, a busy address fails here
+	// instead of going unnoticed until the jetstream connection next drops
+	var httpListener net.Listener
+	if g.config.HTTPAddr != "" {
+		httpListener, err = net.Listen("tcp", g.config.HTTPAddr)
 		if err != nil {
-			return fmt.Errorf("invalid cursor format: %w", err)
+			return fmt.Errorf("failed to listen on %s: %w", g.config.HTTPAddr, err)
 		}
-		startTime = parsedTime
-		cursorValue := startTime.UnixMicro()
-		cursorPtr = &cursorValue
-		g.logger.Printf("Backfill starting from: %s", startTime.Format(time.RFC3339))
-	} else {
-		g.logger.Println("Realtime firehose enabled")
-		startTime = time.Now()
-		cursorPtr = nil
 	}
 
 	// Create error channel for goroutine errors
@@ -133,41 +221,97 @@ func (g *Guzzle) Run(ctx context.Context, cursor string) error {
 		}
 	}()
 
-	// Create a scheduler that will handle events sequentially
-	scheduler := sequential.NewScheduler("raileigh_guzzle", slog.Default(), func(ctx context.Context, event *models.Event) error {
-		return g.handleEvent(ctx, event)
-	})
+	// Serve /metrics, /healthz and /readyz
+	if httpListener != nil {
+		go func() {
+			if err := g.serveHTTP(metricsCtx, httpListener); err != nil {
+				errCh <- fmt.Errorf("http server error: %w", err)
+			}
+		}()
+	}
+
+	// Periodically save the cursor so a restart resumes where we left off
+	go func() {
+		if err := g.persistCursor(metricsCtx); err != nil {
+			errCh <- fmt.Errorf("cursor persistence error: %w", err)
+		}
+	}()
+
+	// Report how far behind the live stream the backfill is until it catches up
+	if g.backfilling.Load() {
+		go g.trackBackfill(metricsCtx, *cursorPtr, backfillLogInterval)
+	}
+
+	// Write partial batches once they've waited long enough
+	if g.batcher != nil {
+		go func() {
+			if err := g.batcher.run(metricsCtx, g.config.FlushInterval, g.logger); err != nil {
+				errCh <- fmt.Errorf("batch writer error: %w", err)
+			}
+		}()
+	}
+
+	// Hard delete the posts of deleted accounts, inactive accounts' posts are only hidden
+	if g.config.PurgeInterval > 0 {
+		go g.purgeDeletedAccounts(metricsCtx, g.config.PurgeInterval)
+	}
+
+	// Delete tags no post uses any more, apart from the writes that could be reusing them
+	if g.config.TagSweepInterval > 0 {
+		go g.sweepOrphanedTags(metricsCtx, g.config.TagSweepInterval)
+	}
+
+	g.loadKeyFilters(ctx)
+
+	// Create a scheduler that will handle events sequentially or on a per-DID worker pool
+	scheduler := g.newScheduler()
+	defer scheduler.Shutdown()
 
 	// we round-robin between the URLs
 	jetStreamUrlIndex := 0
+	connections := 0
 	for {
 		select {
 		case <-ctx.Done():
-			g.logger.Println("Received shutdown signal")
+			g.logger.Info("Received shutdown signal")
 			return ctx.Err()
 		case err := <-errCh:
-			g.logger.Printf("Error in background task: %v", err)
+			g.logger.Error("Background task failed", "error", err)
 			return err
 		default:
 			url := g.config.JetstreamURLs[jetStreamUrlIndex]
-			g.logConnectionStatus(true, url)
+			g.logger.Info("Connecting to jetstream", "endpoint", url)
+			g.setEndpoint(url)
+			if connections > 0 {
+				g.metrics.reconnects.Inc()
+			}
+			connections++
 
 			clientConfig := client.DefaultClientConfig()
 			clientConfig.WebsocketURL = url
 			clientConfig.Compress = true
 
 			var err error
-			g.client, err = client.NewClient(clientConfig, slog.Default(), scheduler)
+			g.client, err = client.NewClient(clientConfig, g.logger, scheduler)
 			if err != nil {
-				g.logConnectionStatus(false, url)
+				g.logger.Error("Failed to create jetstream client", "endpoint", url, "error", err)
 				return fmt.Errorf("failed to create client: %w", err)
 			}
 
-			g.logger.Printf("Using cursor: %v", cursorPtr) // Log the cursor value
+			// on reconnect, pick up from the last processed event rather than the original cursor
+			if latest := g.cursor.Load(); latest > 0 {
+				cursorPtr = &latest
+			}
+			if cursorPtr != nil {
+				g.logger.Info("Using cursor", "endpoint", url, "cursor", *cursorPtr)
+			}
+			g.setConnected(true)
+			connectedAt := time.Now()
 			err = g.client.ConnectAndRead(ctx, cursorPtr)
+			g.setConnected(false)
 
 			if err != nil {
-				g.logConnectionStatus(false, url)
+				g.logConnectionLost(url, time.Since(connectedAt), err)
 				if err == context.Canceled {
 					return err
 				}
@@ -178,12 +322,12 @@ func (g *Guzzle) Run(ctx context.Context, cursor string) error {
 					select {
 					case <-ctx.Done():
 						return ctx.Err()
-					case <-time.After(failureTimeout):
+					case <-time.After(g.config.AllFailedDelay):
 						continue
 					}
 				}
 
-				backoff := time.Duration(math.Pow(2, float64(jetStreamUrlIndex))) * baseRetryDelay
+				backoff := time.Duration(math.Pow(2, float64(jetStreamUrlIndex))) * g.config.RetryDelay
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
@@ -191,81 +335,365 @@ func (g *Guzzle) Run(ctx context.Context, cursor string) error {
 					continue
 				}
 			}
-
-			endTime := time.Now()
-			g.logger.Printf("Backfill completed. Execution time: %s", endTime.Sub(startTime))
 		}
 	}
 }
 
-// handleEvent processes a single event from the firehose
+// eventLogger adds the fields identifying an event to the lines logged about it
+func (g *Guzzle) eventLogger(evt *models.Event) *slog.Logger {
+	logger := g.logger.With("did", evt.Did, "time_us", evt.TimeUS)
+	if evt.Commit != nil {
+		logger = logger.With("collection", evt.Commit.Collection, "rkey", evt.Commit.RKey)
+	}
+	return logger
+}
+
+// handleEvent processes a single event from the firehose, keeping it as a dead letter if that fails
 func (g *Guzzle) handleEvent(ctx context.Context, evt *models.Event) error {
+	err := g.processEvent(ctx, evt)
+	// an event cut off by shutdown is replayed from the saved cursor rather than dead lettered
+	if err != nil && !errors.Is(err, errBatchRequeued) && ctx.Err() == nil {
+		g.saveDeadLetter(ctx, evt, err)
+	}
+	return err
+}
 
-	// filter
-	if evt.Kind != models.EventKindCommit || evt.Commit == nil {
-		return nil
+func (g *Guzzle) processEvent(ctx context.Context, evt *models.Event) error {
+	collection := ""
+	if evt.Commit != nil {
+		collection = evt.Commit.Collection
 	}
+	g.metrics.observeEvent(evt.Kind, collection, evt.TimeUS)
 
-	// only updates and deletes are sent as posts are immutable.
-	// we only continue for bsky feed posts and Create and Delete operations
-	//if evt.Commit.Collection != "app.bsky.feed.post" || evt.Commit.Operation != models.CommitOperationCreate && evt.Commit.Operation != models.CommitOperationDelete {
-	// TODO support deletion
-	if evt.Commit.Collection != "app.bsky.feed.post" || evt.Commit.Operation != models.CommitOperationCreate {
-		if evt.Commit.Operation == models.CommitOperationDelete {
-			// TODO support deletion
-			g.logger.Printf("Commit: TODO request to DELETE '%s' just logging for now", string(evt.Commit.RKey))
-		}
+	switch evt.Kind {
+	case models.EventKindAccount:
+		return g.saveAccountStatus(ctx, evt)
+	case models.EventKindIdentity:
+		return g.saveAccountHandle(ctx, evt)
+	}
+
+	if reason := skipReason(evt); reason != "" {
+		g.metrics.eventsFiltered.WithLabelValues(reason).Inc()
 		return nil
 	}
+	if evt.Commit.Collection != jetstream.PostCollection {
+		return g.handleInteraction(ctx, evt)
+	}
 
-	// print the commit record
-	// evtJSON, err := json.Marshal(evt)
-	// if err != nil {
-	// 	g.logger.Printf("failed to marshal event: %v", err)
-	// 	return err
-	// }
-	//g.logger.Printf("Commit: %s", string(evtJSON))
+	switch evt.Commit.Operation {
+	case models.CommitOperationCreate:
+		return g.createPost(ctx, evt)
+	case models.CommitOperationUpdate:
+		// an update can remove a kept post, so it's archived whether or not it passes the filter
+		g.archiveKept(evt)
+		return g.updatePost(ctx, evt)
+	default:
+		g.archiveKept(evt)
+		return g.deletePost(ctx, evt)
+	}
+}
+
+// skipReason returns why a commit or an event of an unknown kind is dropped before its record is looked at,
+// or "" for post commits and for likes and reposts, which count towards the engagement of stored posts
+func skipReason(evt *models.Event) string {
+	if evt.Kind != models.EventKindCommit || evt.Commit == nil {
+		return filterReasonKind
+	}
+	switch evt.Commit.Collection {
+	case jetstream.PostCollection:
+		switch evt.Commit.Operation {
+		case models.CommitOperationCreate, models.CommitOperationUpdate, models.CommitOperationDelete:
+			return ""
+		}
+	case jetstream.LikeCollection, jetstream.RepostCollection:
+		// likes and reposts are created and deleted, never rewritten
+		switch evt.Commit.Operation {
+		case models.CommitOperationCreate, models.CommitOperationDelete:
+			return ""
+		}
+	default:
+		// we only care about bsky feed posts and what they're liked and reposted
+		return filterReasonCollection
+	}
+	return filterReasonOperation
+}
 
+// createPost persists a newly created post if it qualifies
+func (g *Guzzle) createPost(ctx context.Context, evt *models.Event) error {
 	// extract the tags
 	// data := pqtype.NullRawMessage{Valid: true, RawMessage: evt.Commit.Record}
-	post, err := jetstream.ExtractPost(evt)
+	post, err := g.extractPost(evt)
 	if err != nil {
-		// golly it'd be nice of the logger library supported %w too right
-		g.logger.Printf("failed to extract post: %v", err)
+		g.eventLogger(evt).Error("Failed to extract post", "error", err)
 		return err
 	}
 
-	// now check we have a qualifying post: we need to have at least one tag and no reply
-	if len(post.Tags) == 0 || post.Reply != nil {
-		//g.logger.Printf("Post does not meet criteria for persistence (tags: %d, reply: %v)", len(post.Tags), post.Reply != nil)
+	// now check we have a qualifying post
+	reason, err := g.filterReason(ctx, evt.Did, post)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		g.eventLogger(evt).Debug("Post filtered", "reason", reason)
+		g.metrics.eventsFiltered.WithLabelValues(reason).Inc()
 		return nil
 	}
+	g.archiveKept(evt)
+	g.storedPosts.add(evt.Did, evt.Commit.RKey)
 
 	// This mapping was HOURS of work to figure out.
 	// it'd be nice if the jetstream library exposed the post commit interfaces
 	// but as of Dec 2024 it didn't
+	reply := replyRefsOf(post)
+	linkURLs, linkDomains := linkColumns(post.Links)
+	embed := embedColumnsOf(post.Embed)
 	postParams := query.CreatePostWithTagsParams{
-		PostID:     evt.Commit.RKey,
-		CreatorDid: evt.Did,
-		Text:       post.Text,
-		CreatedAt:  post.CreatedAt,
-		Tags:       post.Tags,
+		PostID:         evt.Commit.RKey,
+		CreatorDid:     evt.Did,
+		Text:           post.Text,
+		CreatedAt:      post.CreatedAt,
+		ReplyRootUri:   reply.rootURI,
+		ReplyRootCid:   reply.rootCID,
+		ReplyParentUri: reply.parentURI,
+		ReplyParentCid: reply.parentCID,
+		Langs:          jetstream.NormalizeLangs(post.Langs),
+		Tags:           post.Tags,
+		TagSources:     post.TagSources,
+		Mentions:       post.Mentions,
+		LinkUrls:       linkURLs,
+		LinkDomains:    linkDomains,
+		EmbedType:      embed.embedType,
+		ExternalUri:    embed.externalURI,
+		ExternalTitle:  embed.externalTitle,
+		QuotedUri:      embed.quotedURI,
+		QuotedCid:      embed.quotedCID,
+		MediaKinds:     embed.mediaKinds,
+		MediaCids:      embed.mediaCIDs,
+		MediaMimeTypes: embed.mediaMimeTypes,
+		MediaAlts:      embed.mediaAlts,
+	}
+
+	if g.batcher != nil {
+		return g.batcher.add(ctx, evt, postParams)
 	}
 
+	start := time.Now()
 	dbQueries := query.New(g.db)
 	err = dbQueries.CreatePostWithTags(ctx, postParams)
 	if err != nil {
-		g.logger.Printf("failed to create post: %v", err)
+		g.metrics.dbErrors.WithLabelValues(queryCreatePost).Inc()
+		g.eventLogger(evt).Error("Failed to create post", "latency", time.Since(start), "error", err)
 		return err
 	}
-	g.logger.Printf("Post saved to db, ID: %s, text '%s'", evt.Commit.RKey, strings.ReplaceAll(post.Text, "\n", " "))
+	g.metrics.postsPersisted.WithLabelValues(models.CommitOperationCreate).Inc()
+	g.eventLogger(evt).Info("Post saved", "latency", time.Since(start), "text", post.Text)
 	return nil
 }
 
-// formatBytes formats bytes into a human readable string with both bytes and MB
-func formatBytes(bytes uint64) string {
-	mb := float64(bytes) / (1024 * 1024)
-	return fmt.Sprintf("%d bytes (%.2f MB)", bytes, mb)
+// updatePost replaces a stored post with its rewritten record.
+// A rewrite can make a post start or stop qualifying, so it's inserted or deleted accordingly.
+func (g *Guzzle) updatePost(ctx context.Context, evt *models.Event) error {
+	post, err := g.extractPost(evt)
+	if err != nil {
+		g.eventLogger(evt).Error("Failed to extract post", "error", err)
+		return err
+	}
+
+	reason, err := g.filterReason(ctx, evt.Did, post)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		g.metrics.eventsFiltered.WithLabelValues(reason).Inc()
+		return g.deletePost(ctx, evt)
+	}
+	g.storedPosts.add(evt.Did, evt.Commit.RKey)
+
+	// the upsert supersedes a create that is still waiting to be written
+	if g.batcher != nil {
+		g.batcher.discard(evt.Did, evt.Commit.RKey)
+	}
+
+	reply := replyRefsOf(post)
+	linkURLs, linkDomains := linkColumns(post.Links)
+	embed := embedColumnsOf(post.Embed)
+	start := time.Now()
+	err = query.New(g.db).UpsertPostWithTags(ctx, query.UpsertPostWithTagsParams{
+		PostID:         evt.Commit.RKey,
+		CreatorDid:     evt.Did,
+		CreatedAt:      post.CreatedAt,
+		Text:           post.Text,
+		ReplyRootUri:   reply.rootURI,
+		ReplyRootCid:   reply.rootCID,
+		ReplyParentUri: reply.parentURI,
+		ReplyParentCid: reply.parentCID,
+		Langs:          jetstream.NormalizeLangs(post.Langs),
+		Tags:           post.Tags,
+		TagSources:     post.TagSources,
+		Mentions:       post.Mentions,
+		LinkUrls:       linkURLs,
+		LinkDomains:    linkDomains,
+		EmbedType:      embed.embedType,
+		ExternalUri:    embed.externalURI,
+		ExternalTitle:  embed.externalTitle,
+		QuotedUri:      embed.quotedURI,
+		QuotedCid:      embed.quotedCID,
+		MediaKinds:     embed.mediaKinds,
+		MediaCids:      embed.mediaCIDs,
+		MediaMimeTypes: embed.mediaMimeTypes,
+		MediaAlts:      embed.mediaAlts,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryUpsertPost).Inc()
+		g.eventLogger(evt).Error("Failed to update post", "latency", time.Since(start), "error", err)
+		return err
+	}
+	g.metrics.postsPersisted.WithLabelValues(models.CommitOperationUpdate).Inc()
+	g.eventLogger(evt).Info("Post updated", "latency", time.Since(start), "text", post.Text)
+	return nil
+}
+
+// filterReason says why a post shouldn't be persisted, or "" if it qualifies. When replies are kept,
+// an untagged reply qualifies too if its thread's root is stored, so the conversation under a tagged post is kept with it.
+func (g *Guzzle) filterReason(ctx context.Context, did string, post *jetstream.PostCommitRecord) (string, error) {
+	reason := g.filter.reason(did, post)
+	// replies only get as far as the tags when they're kept
+	if reason != filterReasonNoTags || post.Reply == nil {
+		return reason, nil
+	}
+	stored, err := g.rootStored(ctx, post.Reply.Root.URI)
+	if err != nil || !stored {
+		return reason, err
+	}
+	return g.filter.reasonInThread(did, post, true), nil
+}
+
+// rootStored reports whether a thread's root post is stored or waiting in the batcher
+func (g *Guzzle) rootStored(ctx context.Context, uri string) (bool, error) {
+	did, rkey, err := jetstream.ParsePostURI(uri)
+	if err != nil || !g.storedPosts.mayContain(did, rkey) {
+		return false, nil
+	}
+	if g.batcher != nil && g.batcher.contains(did, rkey) {
+		return true, nil
+	}
+
+	_, err = query.New(g.db).GetPost(ctx, query.GetPostParams{CreatorDid: did, PostID: rkey})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryGetPost).Inc()
+		return false, fmt.Errorf("failed to look up thread root %s: %w", uri, err)
+	}
+	return true, nil
+}
+
+// extractPost decodes a post's record, merging in the hashtags of its text when configured to
+func (g *Guzzle) extractPost(evt *models.Event) (*jetstream.PostCommitRecord, error) {
+	post, err := jetstream.ExtractPost(evt)
+	if err != nil {
+		return nil, err
+	}
+	if g.config.TextTags {
+		post.AddTextTags()
+	}
+	return post, nil
+}
+
+// replyRefs holds a reply's thread references as nullable columns, all NULL for a top level post
+type replyRefs struct {
+	rootURI   sql.NullString
+	rootCID   sql.NullString
+	parentURI sql.NullString
+	parentCID sql.NullString
+}
+
+func replyRefsOf(post *jetstream.PostCommitRecord) replyRefs {
+	if post.Reply == nil {
+		return replyRefs{}
+	}
+	return replyRefs{
+		rootURI:   sql.NullString{String: post.Reply.Root.URI, Valid: post.Reply.Root.URI != ""},
+		rootCID:   sql.NullString{String: post.Reply.Root.CID, Valid: post.Reply.Root.CID != ""},
+		parentURI: sql.NullString{String: post.Reply.Parent.URI, Valid: post.Reply.Parent.URI != ""},
+		parentCID: sql.NullString{String: post.Reply.Parent.CID, Valid: post.Reply.Parent.CID != ""},
+	}
+}
+
+// linkColumns splits links into the parallel URL and domain arrays the queries unnest
+func linkColumns(links []jetstream.Link) ([]string, []string) {
+	urls := make([]string, 0, len(links))
+	domains := make([]string, 0, len(links))
+	for _, link := range links {
+		urls = append(urls, link.URL)
+		domains = append(domains, link.Domain)
+	}
+	return urls, domains
+}
+
+// embedColumns holds a post's embed as nullable columns and its media as parallel arrays,
+// the columns are all NULL when the post has no embed
+type embedColumns struct {
+	embedType     sql.NullString
+	externalURI   sql.NullString
+	externalTitle sql.NullString
+	quotedURI     sql.NullString
+	quotedCID     sql.NullString
+
+	mediaKinds     []string
+	mediaCIDs      []string
+	mediaMimeTypes []string
+	mediaAlts      []string
+}
+
+func embedColumnsOf(embed *jetstream.Embed) embedColumns {
+	// empty rather than nil media arrays, nil is sent as NULL and an update would keep the media it removed
+	columns := embedColumns{
+		mediaKinds:     make([]string, 0),
+		mediaCIDs:      make([]string, 0),
+		mediaMimeTypes: make([]string, 0),
+		mediaAlts:      make([]string, 0),
+	}
+	if embed == nil || embed.Type == "" {
+		return columns
+	}
+	columns.embedType = sql.NullString{String: embed.Type, Valid: true}
+	if external := embed.ExternalLink(); external != nil {
+		columns.externalURI = sql.NullString{String: external.URI, Valid: external.URI != ""}
+		columns.externalTitle = sql.NullString{String: external.Title, Valid: external.Title != ""}
+	}
+	if quoted := embed.QuotedPost(); quoted != nil {
+		columns.quotedURI = sql.NullString{String: quoted.URI, Valid: quoted.URI != ""}
+		columns.quotedCID = sql.NullString{String: quoted.CID, Valid: quoted.CID != ""}
+	}
+	for _, media := range embed.MediaItems() {
+		columns.mediaKinds = append(columns.mediaKinds, media.Kind)
+		columns.mediaCIDs = append(columns.mediaCIDs, media.CID)
+		columns.mediaMimeTypes = append(columns.mediaMimeTypes, media.MimeType)
+		columns.mediaAlts = append(columns.mediaAlts, media.Alt)
+	}
+	return columns
+}
+
+// deletePost removes a deleted post along with its tag links.
+// Deletes arrive for every post on the network, most of which we never stored, so a miss is not an error.
+func (g *Guzzle) deletePost(ctx context.Context, evt *models.Event) error {
+	if g.batcher != nil {
+		g.batcher.discard(evt.Did, evt.Commit.RKey)
+	}
+
+	start := time.Now()
+	err := query.New(g.db).DeletePost(ctx, query.DeletePostParams{
+		CreatorDid: evt.Did,
+		PostID:     evt.Commit.RKey,
+	})
+	if err != nil {
+		g.metrics.dbErrors.WithLabelValues(queryDeletePost).Inc()
+		g.eventLogger(evt).Error("Failed to delete post", "latency", time.Since(start), "error", err)
+		return err
+	}
+	return nil
 }
 
 // logMetrics logs the current metrics every minute
@@ -278,53 +706,63 @@ func (g *Guzzle) logMetrics(ctx context.Context) error {
 		case <-ctx.Done():
 			return nil
 		case <-ticker.C:
-			g.mu.RLock()
-			g.logger.Printf("Metrics - Entries: %d, Space Used: %s",
-				g.metrics.entriesLogged,
-				formatBytes(g.metrics.spaceUsed))
-			g.mu.RUnlock()
+			g.logger.Info("Metrics",
+				"events", sumCounterVec(g.metrics.eventsReceived),
+				"posts_persisted", sumCounterVec(g.metrics.postsPersisted),
+				"db_errors", sumCounterVec(g.metrics.dbErrors),
+				"ingestion_lag_seconds", gaugeValue(g.metrics.ingestionLag))
 		}
 	}
 }
 
-// logConnectionStatus logs connection attempts and failures
-func (g *Guzzle) logConnectionStatus(connected bool, url string) {
-	status := "Connected to"
-	if !connected {
-		status = "Failed to connect to"
+// logConnectionLost logs a dropped or failed jetstream connection
+func (g *Guzzle) logConnectionLost(url string, connected time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		g.logger.Info("Disconnected from jetstream", "endpoint", url, "connected_for", connected.Round(time.Millisecond))
+		return
 	}
+	g.logger.Warn("Jetstream connection lost", "endpoint", url, "connected_for", connected.Round(time.Millisecond), "error", err)
+}
 
-	g.logger.Printf("Connection status: %s %s", status, url)
+// setEndpoint records the jetstream URL in use
+func (g *Guzzle) setEndpoint(url string) {
+	g.mu.Lock()
+	g.endpoint = url
+	g.mu.Unlock()
+	g.metrics.setEndpoint(g.config.JetstreamURLs, url)
 }
 
 // logAllEndpointsFailed logs when all endpoints have failed
 func (g *Guzzle) logAllEndpointsFailed() {
-	message := "All endpoints failed, sleeping for 1 hour"
-	g.logger.Println(message)
+	g.logger.Error("All endpoints failed", "retry_in", g.config.AllFailedDelay)
 }
 
 // Close closes the guzzle service and cleans up resources
 func (g *Guzzle) Close() error {
-	g.logger.Println("Shutting down guzzle service...")
+	g.logger.Info("Shutting down guzzle service")
 
-	g.mu.RLock()
-	g.logger.Printf("Final Metrics - Total Entries: %d, Total Space Used: %s, Cursor Time: %s",
-		g.metrics.entriesLogged,
-		formatBytes(g.metrics.spaceUsed),
-		time.Now().Format(time.RFC3339))
-	g.mu.RUnlock()
+	g.logger.Info("Final metrics",
+		"events", sumCounterVec(g.metrics.eventsReceived),
+		"posts_persisted", sumCounterVec(g.metrics.postsPersisted),
+		"cursor", time.UnixMicro(g.cursor.Load()).Format(time.RFC3339))
 
-	if err := g.db.Close(); err != nil {
-		g.logger.Printf("Error closing database: %v", err)
-		return fmt.Errorf("failed to close database: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), cursorSaveOnCloseTimeout)
+	defer cancel()
+	if err := g.saveCursor(ctx); err != nil {
+		g.logger.Error("Failed to save cursor", "error", err)
 	}
-	g.logger.Println("Database closed successfully")
 
-	if closer, ok := g.logger.Writer().(io.Closer); ok {
-		if err := closer.Close(); err != nil {
-			return fmt.Errorf("failed to close log file: %w", err)
+	if g.archive != nil {
+		if err := g.archive.Close(); err != nil {
+			g.logger.Error("Failed to close event archive", "error", err)
 		}
 	}
 
+	if err := g.db.Close(); err != nil {
+		g.logger.Error("Failed to close database", "error", err)
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	g.logger.Info("Database closed successfully")
+
 	return nil
 }
@@ -0,0 +1,99 @@
+package guzzle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadyWindow = time.Minute
+	dbPingTimeout      = 2 * time.Second
+)
+
+// healthStatus is the body served by /healthz and /readyz
+type healthStatus struct {
+	Connected           bool     `json:"connected"`
+	Endpoint            string   `json:"endpoint"`
+	LastEventAgeSeconds *float64 `json:"last_event_age_seconds"`
+	DatabaseReachable   bool     `json:"database_reachable"`
+	Ready               bool     `json:"ready"`
+	Reasons             []string `json:"reasons,omitempty"`
+
+	// set while catching up from a past cursor
+	BackfillBehindSeconds *float64 `json:"backfill_behind_seconds,omitempty"`
+}
+
+// markReceived records that the websocket reader just handed over an event
+func (g *Guzzle) markReceived() {
+	g.lastEventAt.Store(time.Now().UnixNano())
+}
+
+// setConnected records whether guzzle is inside a websocket session
+func (g *Guzzle) setConnected(connected bool) {
+	g.mu.Lock()
+	g.connected = connected
+	g.mu.Unlock()
+}
+
+// health checks the connection, the event stream and the database
+func (g *Guzzle) health(ctx context.Context) healthStatus {
+	g.mu.RLock()
+	status := healthStatus{
+		Connected: g.connected,
+		Endpoint:  g.endpoint,
+	}
+	startedAt := g.startedAt
+	g.mu.RUnlock()
+
+	// until the first event arrives, the window runs from when guzzle started
+	quietSince := startedAt
+	if lastEventAt := g.lastEventAt.Load(); lastEventAt > 0 {
+		quietSince = time.Unix(0, lastEventAt)
+		age := time.Since(quietSince).Seconds()
+		status.LastEventAgeSeconds = &age
+	}
+
+	if g.backfilling.Load() {
+		behind := time.Since(time.UnixMicro(g.cursor.Load())).Seconds()
+		status.BackfillBehindSeconds = &behind
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	status.DatabaseReachable = g.db.PingContext(pingCtx) == nil
+
+	if !status.Connected {
+		status.Reasons = append(status.Reasons, "websocket not connected")
+	}
+	if time.Since(quietSince) > g.config.ReadyWindow {
+		status.Reasons = append(status.Reasons, "no event received within "+g.config.ReadyWindow.String())
+	}
+	if !status.DatabaseReachable {
+		status.Reasons = append(status.Reasons, "database unreachable")
+	}
+	status.Ready = len(status.Reasons) == 0
+	return status
+}
+
+// handleHealthz reports status without failing, the process is alive if it can answer
+func (g *Guzzle) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, g.health(r.Context()), http.StatusOK)
+}
+
+// handleReadyz fails with 503 unless guzzle is connected, receiving events and able to reach postgres
+func (g *Guzzle) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := g.health(r.Context())
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, code)
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,75 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getHealth(t *testing.T, handler http.HandlerFunc, path string) (int, healthStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var status healthStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	return rec.Code, status
+}
+
+func TestReadyzFailsWithoutConnectionOrDatabase(t *testing.T) {
+	// nothing listens on port 1, so pinging fails straight away
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	require.NoError(t, err)
+	defer db.Close()
+
+	g := newTestGuzzle(db)
+	g.startedAt = time.Now().Add(-2 * defaultReadyWindow)
+
+	code, status := getHealth(t, g.handleReadyz, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, status.Ready)
+	assert.False(t, status.Connected)
+	assert.False(t, status.DatabaseReachable)
+	assert.Nil(t, status.LastEventAgeSeconds)
+	assert.Len(t, status.Reasons, 3)
+
+	// liveness still answers with the same details
+	code, status = getHealth(t, g.handleHealthz, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Ready)
+}
+
+func TestReadyzFollowsEvents(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	g := newTestGuzzle(db)
+	g.config.ReadyWindow = 50 * time.Millisecond
+	g.startedAt = time.Now()
+	g.setEndpoint(defaultJetstreamURLs[0])
+	g.setConnected(true)
+
+	scheduler := &observedScheduler{Scheduler: newDIDScheduler(1, 1, func(context.Context, *models.Event) error { return nil }, func(int64) {}), received: g.markReceived}
+	defer scheduler.Shutdown()
+	require.NoError(t, scheduler.AddWork(context.Background(), "did:plc:a", &models.Event{Did: "did:plc:a", TimeUS: 1}))
+
+	code, status := getHealth(t, g.handleReadyz, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Ready)
+	assert.Equal(t, defaultJetstreamURLs[0], status.Endpoint)
+	require.NotNil(t, status.LastEventAgeSeconds)
+
+	// the stream going quiet for longer than the window fails readiness
+	time.Sleep(100 * time.Millisecond)
+	code, status = getHealth(t, g.handleReadyz, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"no event received within 50ms"}, status.Reasons)
+}
@@ -0,0 +1,39 @@
+package guzzle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+// serveHTTP serves guzzle's operational endpoints on listener until the context is cancelled.
+// Run binds the listener itself, so an address already in use fails the start rather than this.
+func (g *Guzzle) serveHTTP(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g.metrics.gatherer(), promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", g.handleHealthz)
+	mux.HandleFunc("/readyz", g.handleReadyz)
+
+	server := &http.Server{
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	g.logger.Info("Serving metrics and health checks", "addr", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package guzzle
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"firehose/pkg/db/query"
+)
+
+const (
+	// a filter holds at least this many keys before its false positive rate climbs
+	minKeyFilterCapacity = 1 << 20
+	// ~1% false positives at capacity
+	keyFilterBitsPerKey = 10
+	keyFilterHashes     = 7
+	// keys read per query while loading
+	keyFilterLoadPage = 10000
+)
+
+// keyFilter is a bloom filter of stored rows' keys, so the firehose's likes, reposts and replies
+// can be checked against what's stored without a database round trip. It never misses a key it
+// was told about, but it can claim one that isn't stored, including a deleted one, so a hit still
+// has to be confirmed by the database.
+//
+// It is sized when Run loads it and doesn't grow, more keys than its capacity only raise the false
+// positive rate and with it the lookups. Posts written by another process, such as cmd/db/replay or a
+// dead letter retried by cmd/db/dead_letters, are only known once guzzle restarts and reloads it.
+// Until then their likes and reposts aren't counted and untagged replies to them are filtered out.
+type keyFilter struct {
+	bits   []atomic.Uint64
+	loaded atomic.Bool
+}
+
+func newKeyFilter(capacity int64) *keyFilter {
+	capacity = max(capacity, minKeyFilterCapacity)
+	return &keyFilter{bits: make([]atomic.Uint64, (capacity*keyFilterBitsPerKey+63)/64)}
+}
+
+// loadKeyFilters loads the stored posts and counted interactions that replies, likes and reposts are
+// checked against before asking the database. Without them every one is looked up, which is slower but still correct.
+func (g *Guzzle) loadKeyFilters(ctx context.Context) {
+	queries := query.New(g.db)
+
+	start := time.Now()
+	if posts, err := loadStoredPosts(ctx, queries); err != nil {
+		g.logger.Warn("Failed to load stored posts, looking up every thread, like and repost", "error", err)
+	} else {
+		g.storedPosts = posts
+		g.logger.Info("Loaded stored posts", "latency", time.Since(start))
+	}
+
+	start = time.Now()
+	if interactions, err := loadCountedInteractions(ctx, queries); err != nil {
+		g.logger.Warn("Failed to load counted interactions, looking up every deleted like and repost", "error", err)
+	} else {
+		g.countedInteractions = interactions
+		g.logger.Info("Loaded counted interactions", "latency", time.Since(start))
+	}
+}
+
+// loadStoredPosts builds a filter holding every stored post by creator DID and post ID, sized for twice as many
+func loadStoredPosts(ctx context.Context, queries *query.Queries) (*keyFilter, error) {
+	count, err := queries.CountPosts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stored posts: %w", err)
+	}
+	f := newKeyFilter(2 * count)
+
+	var afterID int32
+	for {
+		keys, err := queries.ListPostKeys(ctx, query.ListPostKeysParams{AfterID: afterID, RowLimit: keyFilterLoadPage})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load stored posts: %w", err)
+		}
+		for _, key := range keys {
+			f.add(key.CreatorDid, key.PostID)
+			afterID = key.ID
+		}
+		if len(keys) < keyFilterLoadPage {
+			break
+		}
+	}
+	f.loaded.Store(true)
+	return f, nil
+}
+
+// loadCountedInteractions builds a filter holding every counted like and repost by DID, kind and rkey, sized for twice as many
+func loadCountedInteractions(ctx context.Context, queries *query.Queries) (*keyFilter, error) {
+	count, err := queries.CountPostInteractions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count interactions: %w", err)
+	}
+	f := newKeyFilter(2 * count)
+
+	var after query.ListPostInteractionKeysParams
+	after.RowLimit = keyFilterLoadPage
+	for {
+		keys, err := queries.ListPostInteractionKeys(ctx, after)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load interactions: %w", err)
+		}
+		for _, key := range keys {
+			f.add(key.Did, key.Kind, key.Rkey)
+			after.AfterDid, after.AfterKind, after.AfterRkey = key.Did, key.Kind, key.Rkey
+		}
+		if len(keys) < keyFilterLoadPage {
+			break
+		}
+	}
+	f.loaded.Store(true)
+	return f, nil
+}
+
+// add records a key as stored
+func (f *keyFilter) add(key ...string) {
+	if f == nil {
+		return
+	}
+	h1, h2 := keyFilterHash(key)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < keyFilterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+// mayContain reports whether a key may be stored, always true until the filter is loaded
+func (f *keyFilter) mayContain(key ...string) bool {
+	if f == nil || !f.loaded.Load() {
+		return true
+	}
+	h1, h2 := keyFilterHash(key)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < keyFilterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// keyFilterHash derives the two hashes a key's bit positions are combined from
+func keyFilterHash(key []string) (uint64, uint64) {
+	h := fnv.New64a()
+	for _, part := range key {
+		h.Write([]byte(part))
+		h.Write([]byte{'/'})
+	}
+	sum := h.Sum64()
+	// an odd step visits different bits for each hash
+	return sum, (sum>>32 | sum<<32) | 1
+}
@@ -0,0 +1,42 @@
+package guzzle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFilter(t *testing.T) {
+	s := newKeyFilter(0)
+
+	// until it's loaded every post may be stored
+	assert.True(t, s.mayContain("did:plc:a", "3ldgfconvo001"))
+	s.loaded.Store(true)
+	assert.False(t, s.mayContain("did:plc:a", "3ldgfconvo001"))
+
+	s.add("did:plc:a", "3ldgfconvo001")
+	assert.True(t, s.mayContain("did:plc:a", "3ldgfconvo001"))
+	assert.False(t, s.mayContain("did:plc:b", "3ldgfconvo001"))
+
+	// a filter that was never loaded knows nothing
+	var missing *keyFilter
+	missing.add("did:plc:a", "3ldgfconvo001")
+	assert.True(t, missing.mayContain("did:plc:b", "3ldgfconvo002"))
+}
+
+func TestKeyFilterFalsePositives(t *testing.T) {
+	s := newKeyFilter(0)
+	s.loaded.Store(true)
+	for i := range minKeyFilterCapacity / 2 {
+		s.add("did:plc:a", fmt.Sprintf("stored%d", i))
+	}
+
+	hits := 0
+	for i := range 10000 {
+		if s.mayContain("did:plc:a", fmt.Sprintf("missing%d", i)) {
+			hits++
+		}
+	}
+	assert.Less(t, hits, 100)
+}
@@ -0,0 +1,200 @@
+package guzzle
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// reasons an event is dropped without being persisted
+const (
+	filterReasonKind          = "kind"
+	filterReasonCollection    = "collection"
+	filterReasonOperation     = "operation"
+	filterReasonNoTags        = "no_tags"
+	filterReasonReply         = "reply"
+	filterReasonDID           = "did"
+	filterReasonTagDenied     = "tag_denied"
+	filterReasonTagNotAllowed = "tag_not_allowed"
+	filterReasonLang          = "lang"
+	filterReasonTooShort      = "too_short"
+	filterReasonSubject       = "subject"
+)
+
+// queries counted by the db errors metric
+const (
+	queryCreatePost  = "create_post"
+	queryCreatePosts = "create_posts"
+	queryUpsertPost  = "upsert_post"
+	queryDeletePost  = "delete_post"
+	queryGetPost     = "get_post"
+	querySaveCursor  = "save_cursor"
+	queryLoadCursor  = "load_cursor"
+
+	querySaveDeadLetter = "save_dead_letter"
+
+	querySaveAccountStatus = "save_account_status"
+	querySaveAccountHandle = "save_account_handle"
+	queryPurgePosts        = "purge_posts"
+	queryDeleteTags        = "delete_orphaned_tags"
+
+	queryAddInteraction    = "add_interaction"
+	queryRemoveInteraction = "remove_interaction"
+)
+
+// metrics tracks operational metrics. Each guzzle has its own registry so
+// several instances (as in tests) don't collide on registration.
+type metrics struct {
+	registry *prometheus.Registry
+
+	eventsReceived  *prometheus.CounterVec
+	eventsFiltered  *prometheus.CounterVec
+	postsPersisted  *prometheus.CounterVec
+	dbErrors        *prometheus.CounterVec
+	deadLetters     *prometheus.CounterVec
+	eventsArchived  prometheus.Counter
+	archiveErrors   prometheus.Counter
+	currentEndpoint *prometheus.GaugeVec
+	reconnects      prometheus.Counter
+	ingestionLag    prometheus.Gauge
+	backfillBehind  prometheus.Gauge
+
+	accountsUpdated *prometheus.CounterVec
+	postsPurged     prometheus.Counter
+	tagsDeleted     prometheus.Counter
+
+	interactionsCounted *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_events_received_total",
+			Help: "Events received from jetstream by kind and commit collection",
+		}, []string{"kind", "collection"}),
+		eventsFiltered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_events_filtered_total",
+			Help: "Events dropped without being persisted, by reason",
+		}, []string{"reason"}),
+		postsPersisted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_posts_persisted_total",
+			Help: "Posts written to the database by commit operation",
+		}, []string{"operation"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_db_errors_total",
+			Help: "Failed database queries by query",
+		}, []string{"query"}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_dead_letters_total",
+			Help: "Events that failed processing by where they were kept: table, file or lost",
+		}, []string{"store"}),
+		eventsArchived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "guzzle_events_archived_total",
+			Help: "Events written to the local event archive",
+		}),
+		archiveErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "guzzle_archive_errors_total",
+			Help: "Failed writes to the local event archive",
+		}),
+		currentEndpoint: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "guzzle_jetstream_endpoint",
+			Help: "1 for the jetstream endpoint currently in use, 0 for the others",
+		}, []string{"url"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "guzzle_jetstream_reconnects_total",
+			Help: "Times the jetstream connection dropped and was re-established",
+		}),
+		ingestionLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "guzzle_ingestion_lag_seconds",
+			Help: "Time between an event's time_us and when guzzle processed it",
+		}),
+		backfillBehind: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "guzzle_backfill_behind_seconds",
+			Help: "How far the cursor is behind now while catching up from a past cursor, 0 once live",
+		}),
+		accountsUpdated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_accounts_updated_total",
+			Help: "Account statuses and handles recorded, by event kind: account or identity",
+		}, []string{"kind"}),
+		postsPurged: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "guzzle_posts_purged_total",
+			Help: "Posts hard deleted because their account was deleted",
+		}),
+		tagsDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "guzzle_tags_deleted_total",
+			Help: "Tags deleted by the sweep because no post uses them any more",
+		}),
+		interactionsCounted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guzzle_interactions_counted_total",
+			Help: "Likes and reposts of stored posts counted, by kind and commit operation: create adds one, delete removes it",
+		}, []string{"kind", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.eventsReceived,
+		m.eventsFiltered,
+		m.postsPersisted,
+		m.dbErrors,
+		m.deadLetters,
+		m.eventsArchived,
+		m.archiveErrors,
+		m.currentEndpoint,
+		m.reconnects,
+		m.ingestionLag,
+		m.backfillBehind,
+		m.accountsUpdated,
+		m.postsPurged,
+		m.tagsDeleted,
+		m.interactionsCounted,
+	)
+	return m
+}
+
+// gatherer serves guzzle's metrics alongside the jetstream client's and the Go runtime's,
+// which live in the default registry
+func (m *metrics) gatherer() prometheus.Gatherer {
+	return prometheus.Gatherers{m.registry, prometheus.DefaultGatherer}
+}
+
+// observeEvent records an event arriving for processing
+func (m *metrics) observeEvent(kind string, collection string, timeUS int64) {
+	m.eventsReceived.WithLabelValues(kind, collection).Inc()
+	m.ingestionLag.Set(time.Since(time.UnixMicro(timeUS)).Seconds())
+}
+
+// setEndpoint marks url as the endpoint in use
+func (m *metrics) setEndpoint(urls []string, url string) {
+	for _, u := range urls {
+		m.currentEndpoint.WithLabelValues(u).Set(0)
+	}
+	m.currentEndpoint.WithLabelValues(url).Set(1)
+}
+
+// sumCounterVec totals a counter across all of its labels, for the periodic log line
+func sumCounterVec(vec *prometheus.CounterVec) float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+
+	var total float64
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err == nil {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+// gaugeValue reads a gauge's current value
+func gaugeValue(gauge prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := gauge.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,81 @@
+package guzzle
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleEventCountsFilteredSamples(t *testing.T) {
+	g := newTestGuzzle(nil)
+	ctx := context.Background()
+
+	// none of these posts qualify, so nothing reaches the (nil) db
+	for _, evt := range loadSampleEvents(t, "jetstream-sample.json") {
+		require.NoError(t, g.handleEvent(ctx, evt))
+	}
+	// a kind guzzle doesn't know of
+	require.NoError(t, g.handleEvent(ctx, &models.Event{Did: "did:plc:a", Kind: "sync"}))
+	require.NoError(t, g.handleEvent(ctx, &models.Event{
+		Did:  "did:plc:a",
+		Kind: models.EventKindCommit,
+		Commit: &models.Commit{
+			Operation:  models.CommitOperationCreate,
+			Collection: "app.bsky.graph.follow",
+		},
+	}))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.eventsReceived.WithLabelValues(models.EventKindCommit, "app.bsky.feed.post")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.eventsReceived.WithLabelValues("sync", "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonNoTags)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonKind)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(g.metrics.eventsFiltered.WithLabelValues(filterReasonCollection)))
+	assert.Equal(t, 3.0, sumCounterVec(g.metrics.eventsReceived))
+	assert.Greater(t, gaugeValue(g.metrics.ingestionLag), 0.0)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	g := newTestGuzzle(nil)
+	g.setEndpoint(defaultJetstreamURLs[1])
+	g.metrics.reconnects.Inc()
+
+	server := httptest.NewServer(promhttp.HandlerFor(g.metrics.gatherer(), promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), `guzzle_jetstream_endpoint{url="wss://jetstream2.us-east.bsky.network/subscribe"} 1`)
+	assert.Contains(t, string(body), `guzzle_jetstream_endpoint{url="wss://jetstream1.us-east.bsky.network/subscribe"} 0`)
+	assert.Contains(t, string(body), "guzzle_jetstream_reconnects_total 1")
+}
+
+func TestRunFailsWhenHTTPAddrInUse(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	g := newTestGuzzle(nil)
+	g.config.JetstreamURLs = []string{"ws://127.0.0.1:1/subscribe"}
+	g.config.HTTPAddr = busy.Addr().String()
+
+	// the listener fails the start before any connection is attempted
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = g.Run(ctx, "01/12/2024")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), busy.Addr().String())
+}
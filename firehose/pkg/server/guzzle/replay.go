@@ -0,0 +1,66 @@
+package guzzle
+
+import (
+	"context"
+
+	"firehose/pkg/jetstream"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// ReplayEvent processes an event read back from a file, as live ingestion would have.
+// The cursor isn't touched, replaying history mustn't change where the firehose resumes.
+func (g *Guzzle) ReplayEvent(ctx context.Context, evt *models.Event) error {
+	return g.handleEvent(ctx, evt)
+}
+
+// Flush writes the posts waiting in the batcher, if posts are batched
+func (g *Guzzle) Flush(ctx context.Context) error {
+	if g.batcher == nil {
+		return nil
+	}
+	return g.batcher.flush(ctx)
+}
+
+// DryRun reports what processing an event would do without writing anything:
+// the commit operation applied to the database, which for a like or repost only counts if its post is stored,
+// the kind of an account or identity event that updates the account, or the reason the event would be filtered out
+func (g *Guzzle) DryRun(evt *models.Event) (string, error) {
+	if evt.Kind == models.EventKindAccount || evt.Kind == models.EventKindIdentity {
+		return evt.Kind, nil
+	}
+	if reason := skipReason(evt); reason != "" {
+		return reason, nil
+	}
+	if evt.Commit.Operation == models.CommitOperationDelete {
+		return models.CommitOperationDelete, nil
+	}
+	if evt.Commit.Collection != jetstream.PostCollection {
+		// whether the liked or reposted post is stored is only known to the database
+		interaction, err := jetstream.ExtractInteraction(evt)
+		if err != nil {
+			return "", err
+		}
+		if _, _, err := jetstream.ParsePostURI(interaction.Subject.URI); err != nil {
+			return filterReasonSubject, nil
+		}
+		return evt.Commit.Operation, nil
+	}
+
+	post, err := g.extractPost(evt)
+	if err != nil {
+		return "", err
+	}
+	reason, err := g.filterReason(context.Background(), evt.Did, post)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case reason == "":
+		return evt.Commit.Operation, nil
+	case evt.Commit.Operation == models.CommitOperationUpdate:
+		// a rewrite that stops qualifying removes the stored post
+		return models.CommitOperationDelete, nil
+	}
+	return reason, nil
+}
@@ -0,0 +1,68 @@
+package guzzle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	g := newTestGuzzle(nil)
+	updates := loadSampleEvents(t, "jetstream-update-events.json")
+	deletes := loadSampleEvents(t, "jetstream-delete-events.json")
+	reply := loadSampleEvents(t, "jetstream-reply-events.json")[1]
+	follow := &models.Event{Did: "did:plc:rayleightestlike", Kind: models.EventKindCommit, Commit: &models.Commit{
+		Operation: models.CommitOperationCreate, Collection: "app.bsky.graph.follow", RKey: "3ldgfollow001",
+	}}
+	engagement := loadSampleEvents(t, "jetstream-engagement-events.json")
+
+	tests := []struct {
+		name string
+		evt  *models.Event
+		want string
+	}{
+		{"identity event", &models.Event{Did: "did:plc:rayleightestidentity", Kind: models.EventKindIdentity}, models.EventKindIdentity},
+		{"unknown kind", &models.Event{Did: "did:plc:rayleightestidentity", Kind: "sync"}, filterReasonKind},
+		{"follow", follow, filterReasonCollection},
+		{"like of a post", engagement[2], models.CommitOperationCreate},
+		{"like of a feed generator", engagement[6], filterReasonSubject},
+		{"repost delete", engagement[8], models.CommitOperationDelete},
+		{"tagged root post", updates[0], models.CommitOperationCreate},
+		{"rewrite that still qualifies", updates[2], models.CommitOperationUpdate},
+		{"rewrite that drops its tags", updates[3], models.CommitOperationDelete},
+		{"delete", deletes[2], models.CommitOperationDelete},
+		{"reply", reply, filterReasonReply},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.DryRun(tt.evt)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := g.DryRun(loadSampleEvents(t, "jetstream-malformed-events.json")[0])
+	assert.Error(t, err)
+}
+
+func TestReplayLeavesCursorAlone(t *testing.T) {
+	db := openTestDB(t)
+	g := newTestGuzzle(db)
+	g.batcher = newPostBatcher(db, 10, g.metrics, g.saveDeadLetter)
+	events := loadSampleEvents(t, "jetstream-delete-events.json")[:2]
+	removeTestPosts(t, db, events)
+
+	for _, evt := range events {
+		require.NoError(t, g.ReplayEvent(context.Background(), evt))
+	}
+	// batched until flushed
+	assert.Equal(t, 0, countStoredPosts(t, db, events[0]))
+	require.NoError(t, g.Flush(context.Background()))
+	for _, evt := range events {
+		assert.Equal(t, 1, countStoredPosts(t, db, evt))
+	}
+	assert.Zero(t, g.cursor.Load())
+}
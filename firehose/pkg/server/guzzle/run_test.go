@@ -0,0 +1,145 @@
+package guzzle
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"firehose/pkg/jetstream/jetstreamtest"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureCursor is before every sample event, so a run from it replays them all
+const fixtureCursor = "01/12/2024"
+
+// startRun runs g in the background, the returned func stops it and returns Run's error
+func startRun(t *testing.T, g *Guzzle, cursor string, servers ...*jetstreamtest.Server) func() error {
+	t.Helper()
+
+	g.config.JetstreamURLs = nil
+	for _, s := range servers {
+		g.config.JetstreamURLs = append(g.config.JetstreamURLs, s.URL)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.Run(ctx, cursor) }()
+
+	stopped := false
+	stop := func() error {
+		if stopped {
+			return nil
+		}
+		stopped = true
+		cancel()
+		// the websocket reader only notices the cancel once its connection closes
+		for _, s := range servers {
+			s.Close()
+		}
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run didn't stop")
+			return nil
+		}
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+func waitForReceived(t *testing.T, g *Guzzle, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return sumCounterVec(g.metrics.eventsReceived) >= float64(n) },
+		5*time.Second, 5*time.Millisecond, "received %.0f of %d events", sumCounterVec(g.metrics.eventsReceived), n)
+}
+
+func TestRunRoundRobinsEndpoints(t *testing.T) {
+	events := jetstreamtest.LoadEvents(t, filepath.Join(samplesDir, "jetstream-samples-with-tags.json"))
+	first := jetstreamtest.NewServer(events)
+	second := jetstreamtest.NewServer(events)
+
+	// the first endpoint drops out, the second refuses, then the first is back
+	first.InjectFaults(jetstreamtest.Fault{DisconnectAfter: 2})
+	second.InjectFaults(jetstreamtest.Fault{Reject: true})
+
+	// the database is unreachable, events fail to save but connections are unaffected
+	g := newTestGuzzle(closedDB(t))
+	g.config.RetryDelay = 20 * time.Millisecond
+	g.config.AllFailedDelay = 100 * time.Millisecond
+	stop := startRun(t, g, fixtureCursor, first, second)
+
+	// the second connection resumes from the last processed event, so that one arrives twice
+	waitForReceived(t, g, len(events)+1)
+	firstConns, secondConns := first.Connections(), second.Connections()
+	assert.ErrorIs(t, stop(), context.Canceled)
+
+	require.Len(t, firstConns, 2)
+	require.Len(t, secondConns, 1)
+	assert.True(t, secondConns[0].Rejected)
+	assert.True(t, firstConns[0].Compressed)
+
+	// backoff before the second endpoint grows with its index, 2^1 * RetryDelay
+	assert.GreaterOrEqual(t, secondConns[0].ConnectedAt.Sub(firstConns[0].ConnectedAt), 40*time.Millisecond)
+	// with every endpoint failed in turn, the wait is AllFailedDelay
+	assert.GreaterOrEqual(t, firstConns[1].ConnectedAt.Sub(secondConns[0].ConnectedAt), 100*time.Millisecond)
+
+	require.NotNil(t, firstConns[0].Cursor)
+	assert.Less(t, *firstConns[0].Cursor, events[0].TimeUS)
+	require.NotNil(t, firstConns[1].Cursor)
+	assert.Equal(t, events[1].TimeUS, *firstConns[1].Cursor)
+	assert.Equal(t, 2.0, testutil.ToFloat64(g.metrics.reconnects))
+}
+
+func TestRunReconnectsAfterMalformedFrame(t *testing.T) {
+	events := jetstreamtest.LoadEvents(t, filepath.Join(samplesDir, "jetstream-samples-with-tags.json"))
+	s := jetstreamtest.NewServer(events)
+	s.InjectFaults(jetstreamtest.Fault{MalformedAt: 3, Delay: time.Millisecond})
+
+	// posts fail to save, which is dead lettered rather than dropping the connection like the bad frame does
+	g := newTestGuzzle(closedDB(t))
+	g.config.AllFailedDelay = 10 * time.Millisecond
+	startRun(t, g, fixtureCursor, s)
+
+	// two events, the bad frame drops the connection, then everything from the second on again
+	waitForReceived(t, g, len(events)+1)
+	conns := s.Connections()
+	require.Len(t, conns, 2)
+	// the server can get a few more events out before the client hangs up
+	assert.GreaterOrEqual(t, conns[0].Sent, 2)
+	require.NotNil(t, conns[1].Cursor)
+	assert.Equal(t, events[1].TimeUS, *conns[1].Cursor)
+	assert.Equal(t, events[len(events)-1].TimeUS, g.cursor.Load())
+}
+
+func TestRunStoresPostsEndToEnd(t *testing.T) {
+	db := openTestDB(t)
+	creates := loadSampleEvents(t, "jetstream-facet-events.json")
+	deletes := loadSampleEvents(t, "jetstream-delete-events.json")
+	removeTestPosts(t, db, append(append(creates, deletes...), loadSampleEvents(t, "jetstream-update-events.json")...))
+
+	s := jetstreamtest.NewServer(append(creates, deletes...))
+	g := newTestGuzzle(db)
+	g.config.Workers = 2
+	stop := startRun(t, g, fixtureCursor, s)
+
+	waitForReceived(t, g, len(creates)+len(deletes))
+	// the live stream carries on where the replay left off
+	updates := loadSampleEvents(t, "jetstream-update-events.json")
+	s.Publish(updates...)
+	waitForReceived(t, g, len(creates)+len(deletes)+len(updates))
+	require.Eventually(t, func() bool { return g.cursor.Load() == updates[len(updates)-1].TimeUS }, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, stop(), context.Canceled)
+
+	// the facet post was created then rewritten, the deleted post is gone, the other delete fixture post stays
+	assert.Equal(t, 1, countStoredPosts(t, db, creates[0]))
+	assert.Equal(t, 0, countStoredPosts(t, db, deletes[2]))
+	assert.Equal(t, 1, countStoredPosts(t, db, deletes[1]))
+	// the update fixture's post kept its tags through the rewrite, the other lost them and was removed
+	assert.Equal(t, 1, countStoredPosts(t, db, updates[1]))
+	assert.Equal(t, 0, countStoredPosts(t, db, updates[3]))
+}
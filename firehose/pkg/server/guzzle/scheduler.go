@@ -0,0 +1,223 @@
+package guzzle
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+const (
+	schedulerIdent   = "raileigh_guzzle"
+	defaultQueueSize = 100
+	// how long Shutdown waits for the queued events before cutting them off
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// newScheduler picks the sequential scheduler for a single worker and the per-DID pool otherwise
+func (g *Guzzle) newScheduler() client.Scheduler {
+	var scheduler client.Scheduler
+	if g.config.Workers <= 1 {
+		scheduler = sequential.NewScheduler(schedulerIdent, g.logger, func(ctx context.Context, event *models.Event) error {
+			// handleEvent logs and dead letters its own failures. Returning one would make the client
+			// drop the connection, and the cursor advances regardless, otherwise a bad event would be replayed forever.
+			// An event cut off by shutdown didn't fail though, the cursor stays before it so the next start replays it.
+			if err := g.handleEvent(ctx, event); err != nil && ctx.Err() != nil {
+				return nil
+			}
+			g.trackCursor(event.TimeUS)
+			return nil
+		})
+	} else {
+		scheduler = newDIDScheduler(g.config.Workers, g.config.QueueSize, g.handleEvent, g.trackCursor)
+	}
+	observed := &observedScheduler{Scheduler: scheduler, received: g.markReceived}
+	if g.archive != nil && !g.config.ArchiveKeptOnly {
+		observed.archive = g.archiveEvent
+	}
+	return observed
+}
+
+// observedScheduler notes each event as the websocket reader hands it over, before any queueing.
+// Archiving every event here keeps the archive in the order jetstream sent it.
+type observedScheduler struct {
+	client.Scheduler
+	received func()
+	// nil unless every event is archived
+	archive func(*models.Event)
+}
+
+func (s *observedScheduler) AddWork(ctx context.Context, repo string, evt *models.Event) error {
+	s.received()
+	if s.archive != nil {
+		s.archive(evt)
+	}
+	return s.Scheduler.AddWork(ctx, repo, evt)
+}
+
+// didScheduler processes events on a fixed pool of workers.
+// Events are sharded by DID so each repo's events stay in order while different repos run concurrently.
+// Each worker's queue is bounded, so a slow database blocks AddWork and pushes back on the websocket reader.
+// Events are processed with the scheduler's own context rather than the reader's, which is cancelled
+// on shutdown while events are still queued, so Shutdown can drain them.
+type didScheduler struct {
+	handleEvent func(context.Context, *models.Event) error
+	// called with the time_us up to which every event has been processed
+	onProcessed func(timeUS int64)
+
+	queues       []chan *scheduledEvent
+	watermark    *cursorWatermark
+	wg           sync.WaitGroup
+	ctx          context.Context
+	cancel       context.CancelFunc
+	drainTimeout time.Duration
+}
+
+type scheduledEvent struct {
+	evt  *models.Event
+	mark *list.Element
+}
+
+func newDIDScheduler(workers int, queueSize int, handleEvent func(context.Context, *models.Event) error, onProcessed func(int64)) *didScheduler {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &didScheduler{
+		handleEvent:  handleEvent,
+		onProcessed:  onProcessed,
+		queues:       make([]chan *scheduledEvent, workers),
+		watermark:    newCursorWatermark(),
+		ctx:          ctx,
+		cancel:       cancel,
+		drainTimeout: defaultDrainTimeout,
+	}
+
+	s.wg.Add(workers)
+	for i := range s.queues {
+		s.queues[i] = make(chan *scheduledEvent, queueSize)
+		go s.worker(s.queues[i])
+	}
+	return s
+}
+
+// AddWork queues an event on the worker that owns its DID, blocking while that queue is full
+func (s *didScheduler) AddWork(ctx context.Context, repo string, evt *models.Event) error {
+	queue := s.queues[shardFor(repo, len(s.queues))]
+	item := &scheduledEvent{
+		evt:  evt,
+		mark: s.watermark.add(evt.TimeUS),
+	}
+
+	select {
+	case queue <- item:
+		return nil
+	case <-ctx.Done():
+		// never queued, so it mustn't hold the cursor back
+		s.watermark.remove(item.mark)
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting work and waits for the queued events to be processed, which the reader
+// has stopped adding to by then. Events still queued after the drain timeout are cut off and left
+// behind the cursor, so the next start replays them.
+func (s *didScheduler) Shutdown() {
+	defer s.cancel()
+	for _, queue := range s.queues {
+		close(queue)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(s.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		s.cancel()
+		<-drained
+	}
+}
+
+func (s *didScheduler) worker(queue chan *scheduledEvent) {
+	defer s.wg.Done()
+	for item := range queue {
+		// handleEvent logs and dead letters its own failures, and one repo's bad event shouldn't stall everyone else.
+		// One cut off by Shutdown didn't fail though, it's left incomplete to keep the cursor before it.
+		if err := s.handleEvent(s.ctx, item.evt); err != nil && s.ctx.Err() != nil {
+			continue
+		}
+
+		if timeUS, advanced := s.watermark.complete(item.mark); advanced {
+			s.onProcessed(timeUS)
+		}
+	}
+}
+
+// shardFor maps a DID onto one of n workers
+func shardFor(did string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(did))
+	return int(h.Sum32() % uint32(n))
+}
+
+// cursorWatermark tracks events in the order they were scheduled so the cursor
+// never moves past an event that is still queued or in progress on another worker
+type cursorWatermark struct {
+	mu      sync.Mutex
+	pending *list.List
+}
+
+type watermarkEntry struct {
+	timeUS int64
+	done   bool
+}
+
+func newCursorWatermark() *cursorWatermark {
+	return &cursorWatermark{pending: list.New()}
+}
+
+// add records a newly scheduled event
+func (w *cursorWatermark) add(timeUS int64) *list.Element {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending.PushBack(&watermarkEntry{timeUS: timeUS})
+}
+
+// remove forgets an event that was never scheduled after all
+func (w *cursorWatermark) remove(mark *list.Element) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending.Remove(mark)
+}
+
+// complete marks an event processed and returns the time_us every earlier event has reached, if it moved
+func (w *cursorWatermark) complete(mark *list.Element) (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	mark.Value.(*watermarkEntry).done = true
+
+	var timeUS int64
+	advanced := false
+	for front := w.pending.Front(); front != nil; front = w.pending.Front() {
+		entry := front.Value.(*watermarkEntry)
+		if !entry.done {
+			break
+		}
+		timeUS = entry.timeUS
+		advanced = true
+		w.pending.Remove(front)
+	}
+	return timeUS, advanced
+}
@@ -0,0 +1,202 @@
+package guzzle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDIDSchedulerKeepsPerDIDOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string][]int64)
+
+	s := newDIDScheduler(4, 2, func(ctx context.Context, evt *models.Event) error {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		seen[evt.Did] = append(seen[evt.Did], evt.TimeUS)
+		mu.Unlock()
+		return nil
+	}, func(int64) {})
+
+	want := make(map[string][]int64)
+	for i := int64(0); i < 300; i++ {
+		did := fmt.Sprintf("did:plc:test%d", i%7)
+		want[did] = append(want[did], i)
+		require.NoError(t, s.AddWork(context.Background(), did, &models.Event{Did: did, TimeUS: i}))
+	}
+	s.Shutdown()
+
+	assert.Equal(t, want, seen)
+}
+
+func TestDIDSchedulerAppliesBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	s := newDIDScheduler(1, 1, func(ctx context.Context, evt *models.Event) error {
+		<-release
+		return nil
+	}, func(int64) {})
+	defer s.Shutdown()
+	defer close(release)
+
+	// the first event occupies the worker, the second fills its queue
+	require.NoError(t, s.AddWork(context.Background(), "did:plc:a", &models.Event{TimeUS: 1}))
+	require.Eventually(t, func() bool { return len(s.queues[0]) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, s.AddWork(context.Background(), "did:plc:a", &models.Event{TimeUS: 2}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.AddWork(ctx, "did:plc:a", &models.Event{TimeUS: 3})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// the event that was never queued doesn't hold the cursor back
+	assert.Equal(t, 2, s.watermark.pending.Len())
+}
+
+func TestDIDSchedulerDrainsAfterReaderStops(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled int
+	var processed int64
+	s := newDIDScheduler(2, 10, func(ctx context.Context, evt *models.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			cancelled++
+		}
+		return ctx.Err()
+	}, func(timeUS int64) {
+		// workers report progress concurrently, like trackCursor only the latest counts
+		mu.Lock()
+		defer mu.Unlock()
+		processed = max(processed, timeUS)
+	})
+
+	// the reader's context is cancelled on shutdown with events still queued
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := int64(1); i <= 20; i++ {
+		require.NoError(t, s.AddWork(ctx, fmt.Sprintf("did:plc:test%d", i%3), &models.Event{TimeUS: i}))
+	}
+	cancel()
+	s.Shutdown()
+
+	assert.Zero(t, cancelled)
+	assert.Equal(t, int64(20), processed)
+}
+
+func TestDIDSchedulerLeavesCutOffEventsBehindTheCursor(t *testing.T) {
+	var processed atomic.Int64
+	s := newDIDScheduler(1, 10, func(ctx context.Context, evt *models.Event) error {
+		if evt.TimeUS < 2 {
+			return nil
+		}
+		// stuck until the drain is cut off
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(timeUS int64) { processed.Store(timeUS) })
+	s.drainTimeout = 20 * time.Millisecond
+
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, s.AddWork(context.Background(), "did:plc:a", &models.Event{TimeUS: i}))
+	}
+	s.Shutdown()
+
+	assert.Equal(t, int64(1), processed.Load())
+}
+
+func TestCursorWatermarkWaitsForEarlierEvents(t *testing.T) {
+	w := newCursorWatermark()
+	first := w.add(100)
+	second := w.add(200)
+	third := w.add(300)
+
+	// a later event finishing first mustn't move the cursor past the earlier one still in progress
+	_, advanced := w.complete(second)
+	assert.False(t, advanced)
+
+	timeUS, advanced := w.complete(first)
+	assert.True(t, advanced)
+	assert.Equal(t, int64(200), timeUS)
+
+	timeUS, advanced = w.complete(third)
+	assert.True(t, advanced)
+	assert.Equal(t, int64(300), timeUS)
+}
+
+// simulatedInsertLatency approximates a CreatePostWithTags round trip to a local Postgres
+const simulatedInsertLatency = 500 * time.Microsecond
+
+// benchmarkScheduler feeds the sample events through a scheduler the way the websocket reader does
+func benchmarkScheduler(b *testing.B, events []*models.Event, newScheduler func() client.Scheduler) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		scheduler := newScheduler()
+		for _, evt := range events {
+			if err := scheduler.AddWork(ctx, evt.Did, evt); err != nil {
+				b.Fatal(err)
+			}
+		}
+		scheduler.Shutdown()
+	}
+	b.ReportMetric(float64(len(events)*b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+func BenchmarkSchedulers(b *testing.B) {
+	events := loadSampleEvents(b, "jetstream-samples-with-tags.json")
+	handle := func(ctx context.Context, evt *models.Event) error {
+		time.Sleep(simulatedInsertLatency)
+		return nil
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		benchmarkScheduler(b, events, func() client.Scheduler {
+			return sequential.NewScheduler(schedulerIdent, slog.New(slog.NewTextHandler(io.Discard, nil)), handle)
+		})
+	})
+	for _, workers := range []int{4, 16} {
+		b.Run(fmt.Sprintf("per-did-%d", workers), func(b *testing.B) {
+			benchmarkScheduler(b, events, func() client.Scheduler {
+				return newDIDScheduler(workers, defaultQueueSize, handle, func(int64) {})
+			})
+		})
+	}
+}
+
+// BenchmarkHandleEvent runs the real handleEvent path against the test database
+func BenchmarkHandleEvent(b *testing.B) {
+	db := openTestDB(b)
+	events := loadSampleEvents(b, "jetstream-samples-with-tags.json")
+	removeTestPosts(b, db, events)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			g := newTestGuzzle(db)
+			g.config.Workers = workers
+			benchmarkScheduler(b, events, g.newScheduler)
+		})
+	}
+}
+
+func TestSequentialSchedulerLeavesCutOffEventBehindTheCursor(t *testing.T) {
+	g := newTestGuzzle(closedDB(t))
+	scheduler := g.newScheduler()
+	defer scheduler.Shutdown()
+	post := loadSampleEvents(t, "jetstream-update-events.json")[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, scheduler.AddWork(ctx, post.Did, post))
+
+	// replayed on the next start, so neither processed nor dead lettered
+	assert.Zero(t, g.cursor.Load())
+	assert.Zero(t, sumCounterVec(g.metrics.deadLetters))
+}
@@ -0,0 +1,80 @@
+package guzzle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"firehose/pkg/db/query"
+
+	"github.com/lib/pq"
+)
+
+// tags deleted per sweep query, so a sweep doesn't hold locks for long
+const tagSweepBatchSize = 1000
+
+// SweepOrphanedTags deletes every tag no post uses any more, returning how many went.
+// Deleting a post, purging an account or editing a tag out of a post leaves the tags behind for this,
+// dropping them in the same statement raced with posts written at the same time that reuse the tag.
+func (g *Guzzle) SweepOrphanedTags(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		deleted, err := g.deleteOrphanedTags(ctx)
+		if err != nil {
+			g.metrics.dbErrors.WithLabelValues(queryDeleteTags).Inc()
+			return total, err
+		}
+		total += deleted
+		g.metrics.tagsDeleted.Add(float64(deleted))
+		if deleted < tagSweepBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// deleteOrphanedTags deletes one batch in a repeatable read transaction, which fails with a serialization error
+// when a post takes one of the tags meanwhile instead of unlinking it from that post
+func (g *Guzzle) deleteOrphanedTags(ctx context.Context) (int64, error) {
+	tx, err := g.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := query.New(tx).DeleteOrphanedTags(ctx, tagSweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// sweepOrphanedTags sweeps tags no post uses every interval until the context is cancelled
+func (g *Guzzle) sweepOrphanedTags(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := g.SweepOrphanedTags(ctx)
+			switch {
+			case err != nil && serializationFailure(err):
+				// a post reused a tag being swept, whatever is still unused goes next time
+				g.logger.Debug("Tag sweep interrupted by a post reusing a tag", "deleted", deleted)
+			case err != nil && ctx.Err() == nil:
+				g.logger.Error("Failed to sweep orphaned tags", "deleted", deleted, "error", err)
+			case deleted > 0:
+				g.logger.Info("Swept orphaned tags", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// serializationFailure reports whether a repeatable read transaction failed because of a concurrent write
+func serializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
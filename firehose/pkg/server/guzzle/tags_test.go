@@ -0,0 +1,39 @@
+package guzzle
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepOrphanedTags(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := newTestGuzzle(db)
+
+	events := loadSampleEvents(t, "jetstream-delete-events.json")
+	removeTestPosts(t, db, events)
+	require.NoError(t, g.handleEvent(ctx, events[1]))
+	_, err := db.Exec(`INSERT INTO tags (name) VALUES ('rayleightestunused')`)
+	require.NoError(t, err)
+
+	// the tag a stored post uses stays
+	deleted, err := g.SweepOrphanedTags(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+	assert.Equal(t, 0, countTags(t, db, "rayleightestunused"))
+	assert.Equal(t, 1, countTags(t, db, "rayleightestshared"))
+	assert.Equal(t, float64(deleted), testutil.ToFloat64(g.metrics.tagsDeleted))
+	assert.Equal(t, []string{"rayleightestshared"}, storedPostTags(t, db, events[1]))
+}
+
+func TestSerializationFailure(t *testing.T) {
+	assert.True(t, serializationFailure(fmt.Errorf("wrapped: %w", &pq.Error{Code: "40001"})))
+	assert.False(t, serializationFailure(&pq.Error{Code: "23503"}))
+	assert.False(t, serializationFailure(context.Canceled))
+}